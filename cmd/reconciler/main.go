@@ -0,0 +1,32 @@
+package main
+
+import (
+	"context"
+	"sync"
+
+	"github.com/cankoe/rrule-scheduler/internal/helpers"
+	"github.com/cankoe/rrule-scheduler/internal/roles"
+
+	"github.com/rs/zerolog/log"
+)
+
+func main() {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	helpers.CancelOnSignal(cancel, "Reconciler")
+
+	components, err := helpers.InitializeCommonComponents("reconciler", false)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to initialize Reconciler")
+	}
+
+	var wg sync.WaitGroup
+	if err := roles.Reconciler(ctx, &wg, components); err != nil {
+		log.Fatal().Err(err).Msg("Failed to start Reconciler")
+	}
+
+	wg.Wait()
+	components.CloseAll(context.Background())
+	log.Info().Msg("Reconciler exited gracefully")
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/cankoe/rrule-scheduler/internal/helpers"
+	"github.com/cankoe/rrule-scheduler/internal/roles"
+
+	"github.com/rs/zerolog/log"
+)
+
+// main runs the roles given by --roles in one process. They share the
+// clients and stop together: on a signal, every role winds down, and the
+// clients are closed once the last one has.
+func main() {
+	rolesFlag := flag.String("roles", strings.Join(roles.Names, ","),
+		"Comma-separated roles to run: "+strings.Join(roles.Names, ", "))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	helpers.CancelOnSignal(cancel, "Scheduler")
+
+	// Parses the flags, including --roles
+	components, err := helpers.InitializeCommonComponents("scheduler", true)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to initialize Scheduler")
+	}
+
+	selected, err := parseRoles(*rolesFlag)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Invalid --roles")
+	}
+	log.Info().Strs("roles", selected).Msg("Starting roles")
+
+	var wg sync.WaitGroup
+	var startErr error
+	for _, name := range selected {
+		if err := roles.ByName[name](ctx, &wg, components); err != nil {
+			startErr = fmt.Errorf("failed to start role %s: %w", name, err)
+			log.Error().Err(err).Str("role", name).Msg("Failed to start role, shutting down")
+			cancel()
+			break
+		}
+	}
+
+	wg.Wait()
+	components.CloseAll(context.Background())
+	if startErr != nil {
+		// The roles that did start were stopped and the clients closed
+		log.Fatal().Err(startErr).Msg("Scheduler failed")
+	}
+	log.Info().Msg("Scheduler exited gracefully")
+}
+
+// parseRoles returns the requested roles in start order, without duplicates.
+func parseRoles(value string) ([]string, error) {
+	requested := make(map[string]bool)
+	for _, name := range strings.Split(value, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		if _, ok := roles.ByName[name]; !ok {
+			return nil, fmt.Errorf("unknown role %q, expected one of %s", name, strings.Join(roles.Names, ", "))
+		}
+		requested[name] = true
+	}
+	if len(requested) == 0 {
+		return nil, fmt.Errorf("no roles given")
+	}
+
+	var selected []string
+	for _, name := range roles.Names {
+		if requested[name] {
+			selected = append(selected, name)
+		}
+	}
+	return selected, nil
+}
@@ -2,13 +2,10 @@ package main
 
 import (
 	"context"
-	"os"
-	"os/signal"
 	"sync"
-	"syscall"
 
 	"github.com/cankoe/rrule-scheduler/internal/helpers"
-	"github.com/cankoe/rrule-scheduler/internal/worker"
+	"github.com/cankoe/rrule-scheduler/internal/roles"
 
 	"github.com/rs/zerolog/log"
 )
@@ -17,36 +14,16 @@ func main() {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	var wg sync.WaitGroup
-
-	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
-	go func() {
-		sig := <-sigChan
-		log.Info().Msgf("Received signal %s, shutting down Worker gracefully...", sig)
-		cancel()
-	}()
+	helpers.CancelOnSignal(cancel, "Worker")
 
-	components, err := helpers.InitializeCommonComponents("worker")
+	components, err := helpers.InitializeCommonComponents("worker", false)
 	if err != nil {
-		log.Fatal().Err(err)
+		log.Fatal().Err(err).Msg("Failed to initialize Worker")
 	}
 
-	eventsCol := components.MongoDatabase.Collection("events")
-	archivedEventsCol := components.MongoDatabase.Collection("archived_events")
-	schedulesCol := components.MongoDatabase.Collection("schedules")
-
-	if err := worker.EnsureIndexes(eventsCol, schedulesCol); err != nil {
-		log.Fatal().Err(err).Msg("Failed to create necessary indexes")
-	}
-
-	workerCount := components.Config.Worker.Count
-	log.Info().Int("workers", workerCount).Msg("Spawning worker goroutines")
-
-	for i := 0; i < workerCount; i++ {
-		wg.Add(1)
-		go worker.EventWorker(ctx, &wg, components.RedisClient, eventsCol,
-			archivedEventsCol, schedulesCol, i+1, components.Config.Worker.MaxRetries)
+	var wg sync.WaitGroup
+	if err := roles.Worker(ctx, &wg, components); err != nil {
+		log.Fatal().Err(err).Msg("Failed to start Worker")
 	}
 
 	wg.Wait()
@@ -1,18 +1,26 @@
 package api
 
 import (
+	"github.com/cankoe/rrule-scheduler/internal/queue"
 	"github.com/cankoe/rrule-scheduler/internal/schedules"
+	"github.com/cankoe/rrule-scheduler/internal/store"
 
 	"github.com/gin-gonic/gin"
-	"go.mongodb.org/mongo-driver/mongo"
 )
 
 // RegisterRoutes registers all top-level domain routes.
-func RegisterRoutes(r *gin.Engine, db *mongo.Database) {
+func RegisterRoutes(r *gin.Engine,
+	scheduleStore store.ScheduleStore,
+	eventStore store.EventStore,
+	queues queue.Queues,
+) {
 	// Serve Swagger UI
 	r.Static("/swagger-ui", "./swagger-ui")
 	r.StaticFile("/docs/openapi.yml", "./docs/openapi.yml")
 
 	// Schedules & related events
-	schedules.RegisterScheduleRoutes(r, db)
+	schedules.RegisterScheduleRoutes(r, scheduleStore, eventStore, queues)
+
+	// Actions on events, e.g. retrying archived ones
+	schedules.RegisterEventRoutes(r, eventStore, queues)
 }
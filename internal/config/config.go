@@ -9,11 +9,27 @@ import (
 )
 
 type Config struct {
+	Storage struct {
+		Backend string `mapstructure:"backend"`
+	} `mapstructure:"storage"`
+
 	Mongo struct {
 		URI      string `mapstructure:"uri"`
 		Database string `mapstructure:"database"`
 	} `mapstructure:"mongo"`
 
+	Postgres struct {
+		DSN string `mapstructure:"dsn"`
+	} `mapstructure:"postgres"`
+
+	SQLite struct {
+		Path string `mapstructure:"path"`
+	} `mapstructure:"sqlite"`
+
+	Queue struct {
+		Backend string `mapstructure:"backend"`
+	} `mapstructure:"queue"`
+
 	Redis struct {
 		Host string `mapstructure:"host"`
 		Port int    `mapstructure:"port"`
@@ -22,13 +38,31 @@ type Config struct {
 	PreQueuer struct {
 		TickerIntervalSeconds int `mapstructure:"ticker_interval_seconds"`
 		EventTimeframeMinutes int `mapstructure:"event_timeframe_minutes"`
+		BatchSize             int `mapstructure:"batch_size"`
+		MaxMisfiredRuns       int `mapstructure:"max_misfired_runs"`
 	} `mapstructure:"prequeuer"`
 
+	Dispatcher struct {
+		BatchSize int `mapstructure:"batch_size"`
+	} `mapstructure:"dispatcher"`
+
 	Worker struct {
-		Count      int `mapstructure:"count"`
-		MaxRetries int `mapstructure:"max_retries"`
+		Count                    int `mapstructure:"count"`
+		MaxRetries               int `mapstructure:"max_retries"`
+		RetryBaseDelaySeconds    int `mapstructure:"retry_base_delay_seconds"`
+		RetryMaxDelaySeconds     int `mapstructure:"retry_max_delay_seconds"`
+		VisibilityTimeoutSeconds int `mapstructure:"visibility_timeout_seconds"`
 	} `mapstructure:"worker"`
 
+	Reconciler struct {
+		IntervalSeconds    int `mapstructure:"interval_seconds"`
+		GracePeriodSeconds int `mapstructure:"grace_period_seconds"`
+	} `mapstructure:"reconciler"`
+
+	Leader struct {
+		TTLSeconds int `mapstructure:"ttl_seconds"`
+	} `mapstructure:"leader"`
+
 	Log struct {
 		Level string `mapstructure:"level"`
 	} `mapstructure:"log"`
@@ -40,14 +74,27 @@ func LoadConfig(configPath string, args []string) (*Config, error) {
 	v := viper.New()
 
 	// Set defaults
+	v.SetDefault("storage.backend", "mongo")
 	v.SetDefault("mongo.uri", "mongodb://localhost:27017")
 	v.SetDefault("mongo.database", "scheduler")
+	v.SetDefault("postgres.dsn", "postgres://localhost:5432/scheduler?sslmode=disable")
+	v.SetDefault("sqlite.path", "scheduler.db")
+	v.SetDefault("queue.backend", "redis")
 	v.SetDefault("redis.host", "localhost")
 	v.SetDefault("redis.port", 6379)
 	v.SetDefault("prequeuer.ticker_interval_seconds", 30)
 	v.SetDefault("prequeuer.event_timeframe_minutes", 60)
+	v.SetDefault("prequeuer.batch_size", 1000)
+	v.SetDefault("prequeuer.max_misfired_runs", 100)
+	v.SetDefault("dispatcher.batch_size", 500)
 	v.SetDefault("worker.max_retries", 3)
 	v.SetDefault("worker.count", 5)
+	v.SetDefault("worker.retry_base_delay_seconds", 5)
+	v.SetDefault("worker.retry_max_delay_seconds", 300)
+	v.SetDefault("worker.visibility_timeout_seconds", 60)
+	v.SetDefault("reconciler.interval_seconds", 300)
+	v.SetDefault("reconciler.grace_period_seconds", 60)
+	v.SetDefault("leader.ttl_seconds", 15)
 	v.SetDefault("log.level", "info")
 
 	// Read from config file if present
@@ -58,14 +105,27 @@ func LoadConfig(configPath string, args []string) (*Config, error) {
 	}
 
 	// Explicitly bind environment variables
+	bindEnvOrPanic(v, "storage.backend", "STORAGE_BACKEND")
 	bindEnvOrPanic(v, "mongo.uri", "MONGO_URI")
 	bindEnvOrPanic(v, "mongo.database", "MONGO_DATABASE")
+	bindEnvOrPanic(v, "postgres.dsn", "POSTGRES_DSN")
+	bindEnvOrPanic(v, "sqlite.path", "SQLITE_PATH")
+	bindEnvOrPanic(v, "queue.backend", "QUEUE_BACKEND")
 	bindEnvOrPanic(v, "redis.host", "REDIS_HOST")
 	bindEnvOrPanic(v, "redis.port", "REDIS_PORT")
 	bindEnvOrPanic(v, "prequeuer.ticker_interval_seconds", "PREQUEUER_TICKER_INTERVAL_SECONDS")
 	bindEnvOrPanic(v, "prequeuer.event_timeframe_minutes", "PREQUEUER_EVENT_TIMEFRAME_MINUTES")
+	bindEnvOrPanic(v, "prequeuer.batch_size", "PREQUEUER_BATCH_SIZE")
+	bindEnvOrPanic(v, "prequeuer.max_misfired_runs", "PREQUEUER_MAX_MISFIRED_RUNS")
+	bindEnvOrPanic(v, "dispatcher.batch_size", "DISPATCHER_BATCH_SIZE")
 	bindEnvOrPanic(v, "worker.max_retries", "WORKER_MAX_RETRIES")
 	bindEnvOrPanic(v, "worker.count", "WORKER_COUNT")
+	bindEnvOrPanic(v, "worker.retry_base_delay_seconds", "WORKER_RETRY_BASE_DELAY_SECONDS")
+	bindEnvOrPanic(v, "worker.retry_max_delay_seconds", "WORKER_RETRY_MAX_DELAY_SECONDS")
+	bindEnvOrPanic(v, "worker.visibility_timeout_seconds", "WORKER_VISIBILITY_TIMEOUT_SECONDS")
+	bindEnvOrPanic(v, "reconciler.interval_seconds", "RECONCILER_INTERVAL_SECONDS")
+	bindEnvOrPanic(v, "reconciler.grace_period_seconds", "RECONCILER_GRACE_PERIOD_SECONDS")
+	bindEnvOrPanic(v, "leader.ttl_seconds", "LEADER_TTL_SECONDS")
 	bindEnvOrPanic(v, "log.level", "LOG_LEVEL")
 
 	// Parse command-line flags for prequeuer
@@ -110,6 +170,13 @@ func bindEnvOrPanic(v *viper.Viper, key, env string) {
 }
 
 func validateConfig(cfg *Config) error {
+	// Validate storage settings
+	switch cfg.Storage.Backend {
+	case "mongo", "postgres", "sqlite":
+	default:
+		return fmt.Errorf("storage backend must be one of mongo, postgres, sqlite, got %q", cfg.Storage.Backend)
+	}
+
 	// Validate Mongo settings
 	if cfg.Mongo.URI == "" {
 		log.Warn().Msg("MONGO_URI not provided, using default")
@@ -118,6 +185,23 @@ func validateConfig(cfg *Config) error {
 		log.Warn().Msg("MONGO_DATABASE not provided, using default")
 	}
 
+	// Validate Postgres settings
+	if cfg.Storage.Backend == "postgres" && cfg.Postgres.DSN == "" {
+		return fmt.Errorf("postgres dsn must be set when the storage backend is postgres")
+	}
+
+	// Validate SQLite settings
+	if cfg.Storage.Backend == "sqlite" && cfg.SQLite.Path == "" {
+		return fmt.Errorf("sqlite path must be set when the storage backend is sqlite")
+	}
+
+	// Validate queue settings
+	switch cfg.Queue.Backend {
+	case "redis", "memory":
+	default:
+		return fmt.Errorf("queue backend must be one of redis, memory, got %q", cfg.Queue.Backend)
+	}
+
 	// Validate Redis settings
 	if cfg.Redis.Host == "" {
 		log.Warn().Msg("REDIS_HOST not provided, using default")
@@ -130,13 +214,46 @@ func validateConfig(cfg *Config) error {
 	if cfg.PreQueuer.EventTimeframeMinutes <= 0 {
 		return fmt.Errorf("PreQueuer event_timeframe_minutes must be > 0, got %d", cfg.PreQueuer.EventTimeframeMinutes)
 	}
+	if cfg.PreQueuer.BatchSize <= 0 {
+		return fmt.Errorf("PreQueuer batch_size must be > 0, got %d", cfg.PreQueuer.BatchSize)
+	}
+	if cfg.PreQueuer.MaxMisfiredRuns <= 0 {
+		return fmt.Errorf("PreQueuer max_misfired_runs must be > 0, got %d", cfg.PreQueuer.MaxMisfiredRuns)
+	}
+
+	// Validate Dispatcher settings
+	if cfg.Dispatcher.BatchSize <= 0 {
+		return fmt.Errorf("dispatcher batch_size must be > 0, got %d", cfg.Dispatcher.BatchSize)
+	}
 
 	// Validate Worker settings
 	if cfg.Worker.MaxRetries <= 0 {
 		return fmt.Errorf("worker max_retries must be > 0, got %d", cfg.Worker.MaxRetries)
 	}
 	if cfg.Worker.Count <= 0 {
-		return fmt.Errorf("worker count must be > 0, got %d", cfg.Worker.MaxRetries)
+		return fmt.Errorf("worker count must be > 0, got %d", cfg.Worker.Count)
+	}
+	if cfg.Worker.RetryBaseDelaySeconds < 0 {
+		return fmt.Errorf("worker retry_base_delay_seconds must be >= 0, got %d", cfg.Worker.RetryBaseDelaySeconds)
+	}
+	if cfg.Worker.RetryMaxDelaySeconds < cfg.Worker.RetryBaseDelaySeconds {
+		return fmt.Errorf("worker retry_max_delay_seconds must be >= retry_base_delay_seconds, got %d", cfg.Worker.RetryMaxDelaySeconds)
+	}
+	if cfg.Worker.VisibilityTimeoutSeconds < 3 {
+		return fmt.Errorf("worker visibility_timeout_seconds must be >= 3, got %d", cfg.Worker.VisibilityTimeoutSeconds)
+	}
+
+	// Validate Reconciler settings
+	if cfg.Reconciler.IntervalSeconds <= 0 {
+		return fmt.Errorf("reconciler interval_seconds must be > 0, got %d", cfg.Reconciler.IntervalSeconds)
+	}
+	if cfg.Reconciler.GracePeriodSeconds < 0 {
+		return fmt.Errorf("reconciler grace_period_seconds must be >= 0, got %d", cfg.Reconciler.GracePeriodSeconds)
+	}
+
+	// Validate leader election settings
+	if cfg.Leader.TTLSeconds < 3 {
+		return fmt.Errorf("leader ttl_seconds must be >= 3, got %d", cfg.Leader.TTLSeconds)
 	}
 
 	return nil
@@ -0,0 +1,33 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	_ "github.com/jackc/pgx/v5/stdlib"
+	"github.com/rs/zerolog/log"
+)
+
+// NewPostgresDB opens a connection pool to PostgreSQL. The DSN is not logged
+// since it usually carries the password.
+func NewPostgresDB(dsn string) (*sql.DB, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	db, err := sql.Open("pgx", dsn)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to open PostgreSQL connection")
+		return nil, fmt.Errorf("failed to open PostgreSQL connection: %w", err)
+	}
+
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
+		log.Error().Err(err).Msg("Failed to ping PostgreSQL")
+		return nil, fmt.Errorf("failed to ping PostgreSQL: %w", err)
+	}
+
+	log.Info().Msg("Successfully connected and pinged PostgreSQL")
+	return db, nil
+}
@@ -0,0 +1,36 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	_ "modernc.org/sqlite"
+)
+
+// NewSQLiteDB opens the SQLite database at path, creating it if needed;
+// ":memory:" keeps the database in memory. SQLite allows a single writer, so
+// the pool holds one connection, which also keeps an in-memory database
+// alive.
+func NewSQLiteDB(path string) (*sql.DB, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	db, err := sql.Open("sqlite", "file:"+path+"?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)&_pragma=synchronous(NORMAL)")
+	if err != nil {
+		log.Error().Err(err).Str("path", path).Msg("Failed to open SQLite database")
+		return nil, fmt.Errorf("failed to open SQLite database: %w", err)
+	}
+	db.SetMaxOpenConns(1)
+
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
+		log.Error().Err(err).Str("path", path).Msg("Failed to open SQLite database")
+		return nil, fmt.Errorf("failed to open SQLite database: %w", err)
+	}
+
+	log.Info().Str("path", path).Msg("Successfully opened SQLite database")
+	return db, nil
+}
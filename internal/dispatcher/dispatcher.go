@@ -2,65 +2,45 @@ package dispatcher
 
 import (
 	"context"
-	"strconv"
 	"time"
 
 	"github.com/cankoe/rrule-scheduler/internal/events"
+	"github.com/cankoe/rrule-scheduler/internal/queue"
+	"github.com/cankoe/rrule-scheduler/internal/store"
 
-	"github.com/go-redis/redis/v8"
 	"github.com/rs/zerolog/log"
-	"go.mongodb.org/mongo-driver/mongo"
 )
 
-// DispatchDueEvents fetches due events from the "ready_queue" and dispatches them.
+// DispatchDueEvents moves due events from the "ready_queue" to the
+// "worker_queue" in batches of batchSize. The move itself is atomic in the
+// queue backend; the "worker_queue" status is written to the event store
+// afterwards and is only informational, so a failure there never strands an
+// event.
 func DispatchDueEvents(ctx context.Context,
-	redisClient *redis.Client,
-	eventsCollection, archivedEventsCollection *mongo.Collection,
+	delayQueue queue.DelayQueue,
+	eventStore store.EventStore,
+	batchSize int,
 ) {
-	now := time.Now().UTC().Unix()
-	eventIDs, err := redisClient.ZRangeByScore(ctx, "ready_queue", &redis.ZRangeBy{
-		Min: "-inf",
-		Max: strconv.FormatInt(now, 10),
-	}).Result()
-
-	if err != nil {
-		log.Error().Err(err).Msg("Failed to fetch events from ready_queue")
-		return
-	}
-	if len(eventIDs) == 0 {
-		log.Debug().Msg("No due events found in ready_queue")
-		return
-	}
-
-	for _, eventID := range eventIDs {
-		removedCount, err := redisClient.ZRem(ctx, "ready_queue", eventID).Result()
+	now := time.Now().UTC()
+	for {
+		eventIDs, err := delayQueue.DispatchDue(ctx, now, batchSize)
 		if err != nil {
-			log.Error().Err(err).Str("event_id", eventID).Msg("Failed to remove event from ready_queue")
-			events.RecordErrorStatus(ctx, eventsCollection, archivedEventsCollection, eventID,
-				"Failed to remove from ready_queue: "+err.Error())
-			continue
+			log.Error().Err(err).Msg("Failed to move due events from ready_queue to worker_queue")
+			return
 		}
-		if removedCount == 0 {
-			log.Warn().Str("event_id", eventID).Msg("Event already removed by another dispatcher, skipping")
-			continue
+		if len(eventIDs) == 0 {
+			log.Debug().Msg("No due events found in ready_queue")
+			return
 		}
+		log.Info().Int("events", len(eventIDs)).Msg("Dispatched events to worker_queue")
 
 		// Update event status -> "worker_queue"
-		if err := events.UpdateEventStatus(ctx, eventsCollection, eventID, "worker_queue", "Event dispatched to worker queue"); err != nil {
-			log.Error().Err(err).Str("event_id", eventID).Msg("Failed to update event status to worker_queue")
-			events.RecordErrorStatus(ctx, eventsCollection, archivedEventsCollection, eventID,
-				"Failed to update status to worker_queue: "+err.Error())
-			continue
+		if err := events.UpdateEventsStatus(ctx, eventStore, eventIDs, "worker_queue", "Event dispatched to worker queue"); err != nil {
+			log.Error().Err(err).Strs("event_ids", eventIDs).Msg("Failed to update event status to worker_queue")
 		}
 
-		// Put event in the worker_queue
-		if err := redisClient.LPush(ctx, "worker_queue", eventID).Err(); err != nil {
-			log.Error().Err(err).Str("event_id", eventID).Msg("Failed to push event to worker_queue")
-			events.RecordErrorStatus(ctx, eventsCollection, archivedEventsCollection, eventID,
-				"Failed to push to worker_queue: "+err.Error())
-			continue
+		if len(eventIDs) < batchSize {
+			return
 		}
-
-		log.Info().Str("event_id", eventID).Msg("Dispatched event to worker_queue")
 	}
 }
@@ -4,78 +4,125 @@ import (
 	"context"
 	"time"
 
+	"github.com/cankoe/rrule-scheduler/internal/models"
+	"github.com/cankoe/rrule-scheduler/internal/store"
+
 	"github.com/rs/zerolog/log"
-	"go.mongodb.org/mongo-driver/bson"
-	"go.mongodb.org/mongo-driver/bson/primitive"
-	"go.mongodb.org/mongo-driver/mongo"
 )
 
 // UpdateEventStatus adds a new status entry to the event's status array.
-func UpdateEventStatus(ctx context.Context, eventsCollection *mongo.Collection, eventID, status, message string) error {
-	objectID, err := primitive.ObjectIDFromHex(eventID)
-	if err != nil {
-		return err
+func UpdateEventStatus(ctx context.Context, eventStore store.EventStore, eventID, status, message string) error {
+	return PushStatus(ctx, eventStore, eventID, models.StatusEntry{
+		Status:  status,
+		Message: message,
+	})
+}
+
+// UpdateEventsStatus adds the same status entry to several events at once.
+// Unknown IDs are skipped.
+func UpdateEventsStatus(ctx context.Context, eventStore store.EventStore, eventIDs []string, status, message string) error {
+	if len(eventIDs) == 0 {
+		return nil
 	}
-	statusUpdate := bson.M{
-		"time":    time.Now().UTC(),
-		"status":  status,
-		"message": message,
+	entry := models.StatusEntry{
+		Time:    time.Now().UTC(),
+		Status:  status,
+		Message: message,
 	}
-	filter := bson.M{"_id": objectID}
-	update := bson.M{"$push": bson.M{"status": statusUpdate}}
-
-	if _, err := eventsCollection.UpdateOne(ctx, filter, update); err != nil {
-		log.Error().Err(err).Str("event_id", eventID).Msg("Failed to update event status")
+	if err := eventStore.PushStatusMany(ctx, eventIDs, entry); err != nil {
+		log.Error().Err(err).Int("events", len(eventIDs)).Msg("Failed to update event statuses")
 		return err
 	}
-	log.Info().Str("event_id", eventID).Str("status", status).Msg("Event status updated successfully")
 	return nil
 }
 
-// UpdateAndArchiveEvent updates the event's status and moves it to the archivedEventsCollection.
-func UpdateAndArchiveEvent(ctx context.Context,
-	eventsCollection, archivedCollection *mongo.Collection,
-	eventID, status, message string,
-) error {
-	if err := UpdateEventStatus(ctx, eventsCollection, eventID, status, message); err != nil {
+// PushStatus appends a full status entry (e.g. one carrying the callback's
+// HTTP status code) to the event's status array. A zero Time is set to now.
+func PushStatus(ctx context.Context, eventStore store.EventStore, eventID string, entry models.StatusEntry) error {
+	if err := eventStore.PushStatus(ctx, eventID, stamp(entry)); err != nil {
+		log.Error().Err(err).Str("event_id", eventID).Msg("Failed to update event status")
 		return err
 	}
-	oid, err := primitive.ObjectIDFromHex(eventID)
-	if err != nil {
+	log.Info().Str("event_id", eventID).Str("status", entry.Status).Msg("Event status updated successfully")
+	return nil
+}
+
+// RecordAttempt appends a callback attempt to the event's attempts array.
+func RecordAttempt(ctx context.Context, eventStore store.EventStore, eventID string, record models.AttemptRecord) error {
+	if err := eventStore.RecordAttempt(ctx, eventID, record); err != nil {
+		log.Error().Err(err).Str("event_id", eventID).Msg("Failed to record callback attempt")
 		return err
 	}
+	return nil
+}
 
-	// Find the event
-	var eventDoc bson.M
-	if err := eventsCollection.FindOne(ctx, bson.M{"_id": oid}).Decode(&eventDoc); err != nil {
-		log.Error().Err(err).Str("event_id", eventID).Msg("Failed to retrieve event for archiving")
+// ScheduleRetry records a failed attempt and the time of the next one. The
+// caller is responsible for putting the event back into the ready_queue.
+func ScheduleRetry(ctx context.Context, eventStore store.EventStore,
+	eventID string, attempt int, retryAt time.Time, entry models.StatusEntry,
+) error {
+	if err := eventStore.ScheduleRetry(ctx, eventID, attempt, retryAt, stamp(entry)); err != nil {
+		log.Error().Err(err).Str("event_id", eventID).Msg("Failed to update event status")
 		return err
 	}
+	log.Info().Str("event_id", eventID).Str("status", entry.Status).Msg("Event status updated successfully")
+	return nil
+}
+
+// UpdateAndArchiveEvent updates the event's status and moves it to the archive.
+func UpdateAndArchiveEvent(ctx context.Context,
+	eventStore store.EventStore,
+	eventID, status, message string,
+) error {
+	return ArchiveEvent(ctx, eventStore, eventID, models.StatusEntry{
+		Status:  status,
+		Message: message,
+	})
+}
 
-	// Insert into the archive
-	if _, err := archivedCollection.InsertOne(ctx, eventDoc); err != nil {
+// ArchiveEvent appends the final status entry and moves the event to the archive.
+func ArchiveEvent(ctx context.Context,
+	eventStore store.EventStore,
+	eventID string, entry models.StatusEntry,
+) error {
+	if err := eventStore.Archive(ctx, eventID, stamp(entry)); err != nil {
 		log.Error().Err(err).Str("event_id", eventID).Msg("Failed to archive event")
 		return err
 	}
+	log.Info().Str("event_id", eventID).Msg("Event archived and deleted successfully")
+	return nil
+}
 
-	// Delete from original
-	if _, err := eventsCollection.DeleteOne(ctx, bson.M{"_id": oid}); err != nil {
-		// Attempt rollback
-		if _, rbErr := archivedCollection.DeleteOne(ctx, bson.M{"_id": oid}); rbErr != nil {
-			log.Error().Err(rbErr).Str("event_id", eventID).Msg("Rollback failed after deleteOne error")
-		}
+// RestoreEvent is the reverse of ArchiveEvent: it moves an archived event back
+// to the pending events with its status history preserved, appends entry and
+// resets the attempt counter so that the event gets a fresh set of retries.
+// The caller is responsible for putting the event back into a queue.
+func RestoreEvent(ctx context.Context,
+	eventStore store.EventStore,
+	eventID string, entry models.StatusEntry,
+) error {
+	if err := eventStore.Restore(ctx, eventID, stamp(entry)); err != nil {
+		log.Error().Err(err).Str("event_id", eventID).Msg("Failed to restore event")
 		return err
 	}
-	log.Info().Str("event_id", eventID).Msg("Event archived and deleted successfully")
+	log.Info().Str("event_id", eventID).Msg("Event restored from archive")
 	return nil
 }
 
 // RecordErrorStatus is a small helper to set status="error" & message.
 func RecordErrorStatus(ctx context.Context,
-	eventsCol, archivedCol *mongo.Collection,
+	eventStore store.EventStore,
 	eventID, errorMsg string,
 ) {
-	if err := UpdateAndArchiveEvent(ctx, eventsCol, archivedCol, eventID, "error", errorMsg); err != nil {
+	if err := UpdateAndArchiveEvent(ctx, eventStore, eventID, "error", errorMsg); err != nil {
 		log.Error().Err(err).Str("event_id", eventID).Msg("Failed to record error status")
 	}
 }
+
+// stamp sets a zero Time of the entry to now.
+func stamp(entry models.StatusEntry) models.StatusEntry {
+	if entry.Time.IsZero() {
+		entry.Time = time.Now().UTC()
+	}
+	return entry
+}
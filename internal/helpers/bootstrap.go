@@ -2,11 +2,17 @@ package helpers
 
 import (
 	"context"
+	"database/sql"
 	"fmt"
 	"os"
 
 	"github.com/cankoe/rrule-scheduler/internal/config"
 	"github.com/cankoe/rrule-scheduler/internal/database"
+	"github.com/cankoe/rrule-scheduler/internal/queue"
+	"github.com/cankoe/rrule-scheduler/internal/store"
+	"github.com/cankoe/rrule-scheduler/internal/store/mongostore"
+	"github.com/cankoe/rrule-scheduler/internal/store/pgstore"
+	"github.com/cankoe/rrule-scheduler/internal/store/sqlitestore"
 
 	"github.com/go-redis/redis/v8"
 	"github.com/rs/zerolog"
@@ -19,13 +25,26 @@ type AppComponents struct {
 	MongoClient   *mongo.Client
 	RedisClient   *redis.Client
 	MongoDatabase *mongo.Database
+	PostgresDB    *sql.DB
+	SQLiteDB      *sql.DB
+	Schedules     store.ScheduleStore
+	Events        store.EventStore
+	Queues        queue.Queues
+	Locker        queue.ScheduleLocker
 }
 
-func InitializeCommonComponents(serviceName string) (*AppComponents, error) {
+// InitializeCommonComponents loads the configuration and connects the stores
+// and queues of a service. inProcess is set by the binary that runs several
+// roles in one process; the in-process queues are only shared within a
+// process, so the standalone services refuse them.
+func InitializeCommonComponents(serviceName string, inProcess bool) (*AppComponents, error) {
 	cfg, err := config.LoadConfig("config/config.yaml", os.Args[1:])
 	if err != nil {
 		return nil, fmt.Errorf("failed to load config: %w", err)
 	}
+	if cfg.Queue.Backend == "memory" && !inProcess {
+		return nil, fmt.Errorf("queue backend memory only works with the all-in-one scheduler binary (cmd/scheduler), the %s service needs redis", serviceName)
+	}
 
 	// Set log level
 	level, err := zerolog.ParseLevel(cfg.Log.Level)
@@ -37,30 +56,82 @@ func InitializeCommonComponents(serviceName string) (*AppComponents, error) {
 
 	log.Info().Msgf("Starting %s service with log level %s...", serviceName, level.String())
 
-	mongoClient, err := database.NewMongoClient(cfg.Mongo.URI)
-	if err != nil {
-		return nil, fmt.Errorf("failed to connect to MongoDB: %w", err)
-	}
+	components := &AppComponents{Config: cfg}
 
-	db := mongoClient.Database(cfg.Mongo.Database)
+	switch cfg.Storage.Backend {
+	case "postgres":
+		db, err := database.NewPostgresDB(cfg.Postgres.DSN)
+		if err != nil {
+			return nil, fmt.Errorf("failed to connect to PostgreSQL: %w", err)
+		}
+		// Every service needs the tables, so the schema is migrated on startup
+		if err := pgstore.Migrate(context.Background(), db); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("failed to migrate PostgreSQL schema: %w", err)
+		}
+		components.PostgresDB = db
+		components.Schedules = pgstore.NewScheduleStore(db)
+		components.Events = pgstore.NewEventStore(db)
+	case "sqlite":
+		db, err := database.NewSQLiteDB(cfg.SQLite.Path)
+		if err != nil {
+			return nil, err
+		}
+		if err := sqlitestore.Migrate(context.Background(), db); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("failed to migrate SQLite schema: %w", err)
+		}
+		components.SQLiteDB = db
+		components.Schedules = sqlitestore.NewScheduleStore(db)
+		components.Events = sqlitestore.NewEventStore(db)
+	default:
+		mongoClient, err := database.NewMongoClient(cfg.Mongo.URI)
+		if err != nil {
+			return nil, fmt.Errorf("failed to connect to MongoDB: %w", err)
+		}
+		db := mongoClient.Database(cfg.Mongo.Database)
+		components.MongoClient = mongoClient
+		components.MongoDatabase = db
+		components.Schedules = mongostore.NewScheduleStore(db)
+		components.Events = mongostore.NewEventStore(db)
+	}
 
-	redisClient := redis.NewClient(&redis.Options{
-		Addr: fmt.Sprintf("%s:%d", cfg.Redis.Host, cfg.Redis.Port),
-	})
+	switch cfg.Queue.Backend {
+	case "memory":
+		// Only shared by the roles running in this process
+		components.Queues = queue.NewMemoryQueues()
+		components.Locker = queue.NewMemoryScheduleLocker()
+	default:
+		redisClient := redis.NewClient(&redis.Options{
+			Addr: fmt.Sprintf("%s:%d", cfg.Redis.Host, cfg.Redis.Port),
+		})
+		components.RedisClient = redisClient
+		components.Queues = queue.NewRedisQueues(redisClient)
+		components.Locker = queue.NewRedisScheduleLocker(redisClient)
+	}
 
-	return &AppComponents{
-		Config:        cfg,
-		MongoClient:   mongoClient,
-		RedisClient:   redisClient,
-		MongoDatabase: db,
-	}, nil
+	return components, nil
 }
 
 func (c *AppComponents) CloseAll(ctx context.Context) {
-	if err := c.MongoClient.Disconnect(ctx); err != nil {
-		log.Error().Err(err).Msg("Failed to disconnect MongoDB client")
+	if c.MongoClient != nil {
+		if err := c.MongoClient.Disconnect(ctx); err != nil {
+			log.Error().Err(err).Msg("Failed to disconnect MongoDB client")
+		}
+	}
+	if c.PostgresDB != nil {
+		if err := c.PostgresDB.Close(); err != nil {
+			log.Error().Err(err).Msg("Failed to close PostgreSQL connection")
+		}
+	}
+	if c.SQLiteDB != nil {
+		if err := c.SQLiteDB.Close(); err != nil {
+			log.Error().Err(err).Msg("Failed to close SQLite database")
+		}
 	}
-	if err := c.RedisClient.Close(); err != nil {
-		log.Error().Err(err).Msg("Failed to close Redis client")
+	if c.RedisClient != nil {
+		if err := c.RedisClient.Close(); err != nil {
+			log.Error().Err(err).Msg("Failed to close Redis client")
+		}
 	}
 }
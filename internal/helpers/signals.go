@@ -0,0 +1,23 @@
+package helpers
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/rs/zerolog/log"
+)
+
+// CancelOnSignal cancels the context on SIGINT or SIGTERM, which starts the
+// graceful shutdown of the service.
+func CancelOnSignal(cancel context.CancelFunc, serviceName string) {
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
+
+	go func() {
+		sig := <-sigChan
+		log.Info().Msgf("Received signal %s, shutting down %s gracefully...", sig, serviceName)
+		cancel()
+	}()
+}
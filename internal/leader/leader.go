@@ -0,0 +1,146 @@
+package leader
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/rs/zerolog/log"
+)
+
+// renewScript extends the lease only if it is still held by this candidate.
+var renewScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return 0
+`)
+
+// releaseScript deletes the lease only if it is still held by this candidate.
+var releaseScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+
+// Elector elects one leader among the replicas of a service through a lease
+// key in Redis. The leader renews the lease well before it expires; when it
+// dies, another replica takes over once the lease has expired.
+type Elector struct {
+	client *redis.Client
+	key    string
+	id     string
+	ttl    time.Duration
+	leader atomic.Bool
+}
+
+// NewElector creates an elector for the given service. All replicas of the
+// service compete for the same lease. Without a Redis client, e.g. with the
+// in-process queues, there is a single replica, which always leads.
+func NewElector(client *redis.Client, service string, ttl time.Duration) *Elector {
+	return &Elector{
+		client: client,
+		key:    "leader:" + service,
+		id:     candidateID(),
+		ttl:    ttl,
+	}
+}
+
+// IsLeader reports whether this replica currently holds the lease.
+func (e *Elector) IsLeader() bool {
+	return e.leader.Load()
+}
+
+// Run campaigns for the lease until ctx is cancelled, then releases it so
+// another replica can take over without waiting for the lease to expire.
+func (e *Elector) Run(ctx context.Context) {
+	if e.client == nil {
+		e.leader.Store(true)
+		<-ctx.Done()
+		e.leader.Store(false)
+		return
+	}
+
+	ticker := time.NewTicker(e.ttl / 3)
+	defer ticker.Stop()
+
+	e.campaign(ctx)
+	for {
+		select {
+		case <-ctx.Done():
+			e.release()
+			return
+		case <-ticker.C:
+			e.campaign(ctx)
+		}
+	}
+}
+
+func (e *Elector) campaign(ctx context.Context) {
+	var held bool
+	var err error
+	if e.leader.Load() {
+		var renewed int64
+		renewed, err = renewScript.Run(ctx, e.client, []string{e.key}, e.id, e.ttl.Milliseconds()).Int64()
+		held = renewed == 1
+	} else {
+		held, err = e.client.SetNX(ctx, e.key, e.id, e.ttl).Result()
+	}
+	if err != nil {
+		// Step down: the lease may expire before Redis is reachable again.
+		log.Error().Err(err).Str("lease", e.key).Msg("Failed to campaign for leadership")
+		held = false
+	}
+
+	if was := e.leader.Swap(held); was != held {
+		if held {
+			log.Info().Str("lease", e.key).Str("candidate", e.id).Msg("Acquired leadership")
+		} else {
+			log.Warn().Str("lease", e.key).Str("candidate", e.id).Msg("Lost leadership")
+		}
+		// The renewal may have failed after it reached Redis, leaving the
+		// lease with a replica that no longer leads. Hand it over right away
+		// rather than once it expires.
+		if was && err != nil {
+			e.deleteLease()
+		}
+	}
+}
+
+func (e *Elector) release() {
+	if !e.leader.Swap(false) {
+		return
+	}
+	if e.deleteLease() {
+		log.Info().Str("lease", e.key).Msg("Released leadership")
+	}
+}
+
+// deleteLease deletes the lease if it is still held by this candidate, and
+// reports whether that could be checked.
+func (e *Elector) deleteLease() bool {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := releaseScript.Run(ctx, e.client, []string{e.key}, e.id).Err(); err != nil {
+		log.Error().Err(err).Str("lease", e.key).Msg("Failed to release leadership")
+		return false
+	}
+	return true
+}
+
+// candidateID identifies this replica in the lease, for debugging.
+func candidateID() string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	suffix := make([]byte, 4)
+	_, _ = rand.Read(suffix)
+	return fmt.Sprintf("%s-%d-%s", host, os.Getpid(), hex.EncodeToString(suffix))
+}
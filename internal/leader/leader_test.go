@@ -0,0 +1,183 @@
+package leader
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+)
+
+const testTTL = 3 * time.Second
+
+func newTestElectors(t *testing.T, n int) (*miniredis.Miniredis, []*Elector) {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+
+	electors := make([]*Elector, n)
+	for i := range electors {
+		electors[i] = NewElector(client, "prequeuer", testTTL)
+	}
+	return mr, electors
+}
+
+func leaseHolder(t *testing.T, mr *miniredis.Miniredis) string {
+	t.Helper()
+	if !mr.Exists("leader:prequeuer") {
+		return ""
+	}
+	holder, err := mr.Get("leader:prequeuer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return holder
+}
+
+func TestElectorElectsOneLeader(t *testing.T) {
+	ctx := context.Background()
+	mr, electors := newTestElectors(t, 2)
+	first, second := electors[0], electors[1]
+
+	first.campaign(ctx)
+	second.campaign(ctx)
+	if !first.IsLeader() || second.IsLeader() {
+		t.Fatalf("leaders: %v, %v; want only the first", first.IsLeader(), second.IsLeader())
+	}
+	if holder := leaseHolder(t, mr); holder != first.id {
+		t.Errorf("lease held by %q, want %q", holder, first.id)
+	}
+	if ttl := mr.TTL("leader:prequeuer"); ttl != testTTL {
+		t.Errorf("lease TTL = %s, want %s", ttl, testTTL)
+	}
+
+	// Renewing keeps the lease alive past its original expiry.
+	mr.FastForward(testTTL / 2)
+	first.campaign(ctx)
+	second.campaign(ctx)
+	mr.FastForward(testTTL / 2)
+	second.campaign(ctx)
+	if !first.IsLeader() || second.IsLeader() {
+		t.Errorf("leaders after renewal: %v, %v; want only the first", first.IsLeader(), second.IsLeader())
+	}
+}
+
+func TestElectorTakesOverExpiredLease(t *testing.T) {
+	ctx := context.Background()
+	mr, electors := newTestElectors(t, 2)
+	first, second := electors[0], electors[1]
+
+	first.campaign(ctx)
+	// The first replica dies without releasing the lease.
+	mr.FastForward(testTTL)
+	second.campaign(ctx)
+	if !second.IsLeader() {
+		t.Fatal("second replica did not take over the expired lease")
+	}
+
+	// The first replica comes back: its renewal fails and it steps down,
+	// leaving the lease with the new leader.
+	first.campaign(ctx)
+	if first.IsLeader() {
+		t.Error("first replica still leads after losing its lease")
+	}
+	if holder := leaseHolder(t, mr); holder != second.id {
+		t.Errorf("lease held by %q, want %q", holder, second.id)
+	}
+}
+
+func TestElectorReleasesLeaseOnStop(t *testing.T) {
+	mr, electors := newTestElectors(t, 2)
+	first, second := electors[0], electors[1]
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		first.Run(ctx)
+		close(done)
+	}()
+	deadline := time.Now().Add(time.Second)
+	for !first.IsLeader() && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if !first.IsLeader() {
+		t.Fatal("first replica did not become leader")
+	}
+
+	cancel()
+	<-done
+	if first.IsLeader() {
+		t.Error("stopped replica still leads")
+	}
+	if holder := leaseHolder(t, mr); holder != "" {
+		t.Errorf("lease still held by %q after stopping", holder)
+	}
+	second.campaign(context.Background())
+	if !second.IsLeader() {
+		t.Error("second replica did not take over right away")
+	}
+}
+
+func TestElectorDeletesLeaseWhenRenewalFails(t *testing.T) {
+	mr, electors := newTestElectors(t, 2)
+	first, second := electors[0], electors[1]
+	first.campaign(context.Background())
+
+	// The renewal times out, but Redis is still there.
+	failed, cancel := context.WithCancel(context.Background())
+	cancel()
+	first.campaign(failed)
+	if first.IsLeader() {
+		t.Fatal("replica still leads after a failed renewal")
+	}
+	if holder := leaseHolder(t, mr); holder != "" {
+		t.Errorf("lease still held by %q after stepping down", holder)
+	}
+	second.campaign(context.Background())
+	if !second.IsLeader() {
+		t.Error("second replica did not take over right away")
+	}
+}
+
+func TestElectorKeepsLeaseOfOtherReplicaWhenRenewalFails(t *testing.T) {
+	mr, electors := newTestElectors(t, 2)
+	first, second := electors[0], electors[1]
+	first.campaign(context.Background())
+	// The lease expired and was taken over while the first one was stalled.
+	mr.FastForward(testTTL)
+	second.campaign(context.Background())
+
+	failed, cancel := context.WithCancel(context.Background())
+	cancel()
+	first.campaign(failed)
+	if first.IsLeader() {
+		t.Fatal("replica still leads after a failed renewal")
+	}
+	if holder := leaseHolder(t, mr); holder != second.id {
+		t.Errorf("lease held by %q, want %q", holder, second.id)
+	}
+}
+
+func TestElectorWithoutRedisAlwaysLeads(t *testing.T) {
+	e := NewElector(nil, "prequeuer", testTTL)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		e.Run(ctx)
+		close(done)
+	}()
+	deadline := time.Now().Add(time.Second)
+	for !e.IsLeader() && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if !e.IsLeader() {
+		t.Fatal("single replica does not lead")
+	}
+	cancel()
+	<-done
+	if e.IsLeader() {
+		t.Error("stopped replica still leads")
+	}
+}
@@ -3,15 +3,42 @@ package models
 import "time"
 
 type StatusEntry struct {
-	Time    time.Time `bson:"time"`
-	Status  string    `bson:"status"`
-	Message string    `bson:"message"`
+	Time       time.Time `bson:"time" json:"time"`
+	Status     string    `bson:"status" json:"status"`
+	Message    string    `bson:"message" json:"message"`
+	StatusCode int       `bson:"status_code,omitempty" json:"status_code,omitempty"`
+	Attempt    int       `bson:"attempt,omitempty" json:"attempt,omitempty"`
+}
+
+// AttemptRecord describes one callback attempt and the response it got. Only
+// selected response headers and the start of the body are kept.
+type AttemptRecord struct {
+	Attempt       int               `bson:"attempt" json:"attempt"`
+	StartedAt     time.Time         `bson:"started_at" json:"started_at"`
+	LatencyMs     int64             `bson:"latency_ms" json:"latency_ms"`
+	StatusCode    int               `bson:"status_code,omitempty" json:"status_code,omitempty"`
+	Headers       map[string]string `bson:"headers,omitempty" json:"headers,omitempty"`
+	Body          string            `bson:"body,omitempty" json:"body,omitempty"`
+	BodyTruncated bool              `bson:"body_truncated,omitempty" json:"body_truncated,omitempty"`
+	Error         string            `bson:"error,omitempty" json:"error,omitempty"`
+}
+
+// CallbackOverrides replaces parts of the schedule's callback request for a
+// single event. Headers are merged into the schedule's headers.
+type CallbackOverrides struct {
+	Headers map[string]string `bson:"headers,omitempty" json:"headers,omitempty"`
+	Body    *string           `bson:"body,omitempty" json:"body,omitempty"`
 }
 
 type Event struct {
-	ID         string        `bson:"_id,omitempty"`
-	ScheduleID string        `bson:"schedule_id"`
-	RunTime    time.Time     `bson:"run_time"`
-	Status     []StatusEntry `bson:"status"`
-	CreatedAt  time.Time     `bson:"created_at,omitempty"`
+	ID           string             `bson:"_id,omitempty" json:"id,omitempty"`
+	ScheduleID   string             `bson:"schedule_id" json:"schedule_id"`
+	RunTime      time.Time          `bson:"run_time" json:"run_time"`
+	Status       []StatusEntry      `bson:"status" json:"status"`
+	Attempts     []AttemptRecord    `bson:"attempts,omitempty" json:"attempts,omitempty"`
+	AttemptCount int                `bson:"attempt_count,omitempty" json:"attempt_count,omitempty"`
+	RetryAt      *time.Time         `bson:"retry_at,omitempty" json:"retry_at,omitempty"`
+	Manual       bool               `bson:"manual" json:"manual,omitempty"`
+	Overrides    *CallbackOverrides `bson:"overrides,omitempty" json:"overrides,omitempty"`
+	CreatedAt    time.Time          `bson:"created_at,omitempty" json:"created_at,omitempty"`
 }
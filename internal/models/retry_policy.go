@@ -0,0 +1,71 @@
+package models
+
+import (
+	"errors"
+	"math/rand/v2"
+	"time"
+)
+
+// RetryPolicy controls how often and how fast a failed callback is retried.
+// Zero fields fall back to the worker's configured defaults.
+type RetryPolicy struct {
+	MaxAttempts      int `bson:"max_attempts,omitempty" json:"max_attempts,omitempty"`
+	BaseDelaySeconds int `bson:"base_delay_seconds,omitempty" json:"base_delay_seconds,omitempty"`
+	MaxDelaySeconds  int `bson:"max_delay_seconds,omitempty" json:"max_delay_seconds,omitempty"`
+}
+
+// Validate checks that the policy values are usable.
+func (p *RetryPolicy) Validate() error {
+	if p == nil {
+		return nil
+	}
+	if p.MaxAttempts < 0 || p.BaseDelaySeconds < 0 || p.MaxDelaySeconds < 0 {
+		return errors.New("retry policy values cannot be negative")
+	}
+	if p.MaxAttempts > 100 {
+		return errors.New("max_attempts cannot exceed 100")
+	}
+	if p.BaseDelaySeconds > 0 && p.MaxDelaySeconds > 0 && p.BaseDelaySeconds > p.MaxDelaySeconds {
+		return errors.New("base_delay_seconds cannot exceed max_delay_seconds")
+	}
+	return nil
+}
+
+// WithDefaults returns the policy with every unset field taken from defaults.
+func (p *RetryPolicy) WithDefaults(defaults RetryPolicy) RetryPolicy {
+	if p == nil {
+		return defaults
+	}
+	merged := *p
+	if merged.MaxAttempts == 0 {
+		merged.MaxAttempts = defaults.MaxAttempts
+	}
+	if merged.BaseDelaySeconds == 0 {
+		merged.BaseDelaySeconds = defaults.BaseDelaySeconds
+	}
+	if merged.MaxDelaySeconds == 0 {
+		merged.MaxDelaySeconds = defaults.MaxDelaySeconds
+	}
+	return merged
+}
+
+// Delay returns how long to wait before the attempt following the given
+// failed one: the base delay doubled per attempt, capped at the max delay,
+// with the upper half randomised so that retries of many events spread out.
+func (p RetryPolicy) Delay(failedAttempt int) time.Duration {
+	base := time.Duration(p.BaseDelaySeconds) * time.Second
+	maxDelay := time.Duration(p.MaxDelaySeconds) * time.Second
+	if base <= 0 {
+		return 0
+	}
+
+	delay := base
+	for i := 1; i < failedAttempt && (maxDelay <= 0 || delay < maxDelay); i++ {
+		delay *= 2
+	}
+	if maxDelay > 0 && delay > maxDelay {
+		delay = maxDelay
+	}
+	half := delay / 2
+	return half + rand.N(delay-half+1)
+}
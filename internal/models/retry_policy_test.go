@@ -0,0 +1,104 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRetryPolicyDelay(t *testing.T) {
+	tests := []struct {
+		name          string
+		policy        RetryPolicy
+		failedAttempt int
+		want          time.Duration
+	}{
+		{name: "no base delay", policy: RetryPolicy{MaxDelaySeconds: 60}, failedAttempt: 3, want: 0},
+		{name: "first attempt", policy: RetryPolicy{BaseDelaySeconds: 10}, failedAttempt: 1, want: 10 * time.Second},
+		{name: "attempt zero", policy: RetryPolicy{BaseDelaySeconds: 10}, failedAttempt: 0, want: 10 * time.Second},
+		{name: "second attempt", policy: RetryPolicy{BaseDelaySeconds: 10}, failedAttempt: 2, want: 20 * time.Second},
+		{name: "fifth attempt", policy: RetryPolicy{BaseDelaySeconds: 10}, failedAttempt: 5, want: 160 * time.Second},
+		{name: "capped", policy: RetryPolicy{BaseDelaySeconds: 10, MaxDelaySeconds: 60}, failedAttempt: 4, want: 60 * time.Second},
+		{name: "capped far out", policy: RetryPolicy{BaseDelaySeconds: 10, MaxDelaySeconds: 60}, failedAttempt: 1000, want: 60 * time.Second},
+		{name: "base above cap", policy: RetryPolicy{BaseDelaySeconds: 120, MaxDelaySeconds: 60}, failedAttempt: 1, want: 60 * time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// The upper half of the delay is random, so sample it repeatedly
+			// and check every draw stays within [want/2, want].
+			for i := 0; i < 200; i++ {
+				got := tt.policy.Delay(tt.failedAttempt)
+				if got < tt.want/2 || got > tt.want {
+					t.Fatalf("Delay(%d) = %v, want within [%v, %v]", tt.failedAttempt, got, tt.want/2, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestRetryPolicyDelaySpreadsRetries(t *testing.T) {
+	policy := RetryPolicy{BaseDelaySeconds: 10}
+	seen := make(map[time.Duration]bool)
+	for i := 0; i < 50; i++ {
+		seen[policy.Delay(1)] = true
+	}
+	if len(seen) < 2 {
+		t.Errorf("50 delays took %d distinct values, want jitter", len(seen))
+	}
+}
+
+func TestRetryPolicyValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  *RetryPolicy
+		wantErr string
+	}{
+		{name: "nil"},
+		{name: "zero", policy: &RetryPolicy{}},
+		{name: "valid", policy: &RetryPolicy{MaxAttempts: 5, BaseDelaySeconds: 10, MaxDelaySeconds: 300}},
+		{name: "base without max", policy: &RetryPolicy{BaseDelaySeconds: 600}},
+		{name: "max attempts at limit", policy: &RetryPolicy{MaxAttempts: 100}},
+		{name: "negative max attempts", policy: &RetryPolicy{MaxAttempts: -1}, wantErr: "retry policy values cannot be negative"},
+		{name: "negative base delay", policy: &RetryPolicy{BaseDelaySeconds: -1}, wantErr: "retry policy values cannot be negative"},
+		{name: "negative max delay", policy: &RetryPolicy{MaxDelaySeconds: -1}, wantErr: "retry policy values cannot be negative"},
+		{name: "too many attempts", policy: &RetryPolicy{MaxAttempts: 101}, wantErr: "max_attempts cannot exceed 100"},
+		{name: "base above max", policy: &RetryPolicy{BaseDelaySeconds: 61, MaxDelaySeconds: 60}, wantErr: "base_delay_seconds cannot exceed max_delay_seconds"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.policy.Validate()
+			if tt.wantErr == "" && err != nil {
+				t.Fatalf("Validate: %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || err.Error() != tt.wantErr) {
+				t.Fatalf("Validate error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRetryPolicyWithDefaults(t *testing.T) {
+	defaults := RetryPolicy{MaxAttempts: 3, BaseDelaySeconds: 5, MaxDelaySeconds: 300}
+	tests := []struct {
+		name   string
+		policy *RetryPolicy
+		want   RetryPolicy
+	}{
+		{name: "nil", want: defaults},
+		{name: "zero", policy: &RetryPolicy{}, want: defaults},
+		{name: "partial", policy: &RetryPolicy{MaxAttempts: 10}, want: RetryPolicy{MaxAttempts: 10, BaseDelaySeconds: 5, MaxDelaySeconds: 300}},
+		{name: "full", policy: &RetryPolicy{MaxAttempts: 1, BaseDelaySeconds: 2, MaxDelaySeconds: 4}, want: RetryPolicy{MaxAttempts: 1, BaseDelaySeconds: 2, MaxDelaySeconds: 4}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.policy.WithDefaults(defaults); got != tt.want {
+				t.Errorf("WithDefaults = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+
+	policy := &RetryPolicy{MaxAttempts: 10}
+	policy.WithDefaults(defaults)
+	if *policy != (RetryPolicy{MaxAttempts: 10}) {
+		t.Errorf("WithDefaults modified the policy: %+v", *policy)
+	}
+}
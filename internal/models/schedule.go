@@ -1,14 +1,64 @@
 package models
 
-import "time"
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	ScheduleStateActive = "active"
+	ScheduleStatePaused = "paused"
+)
+
+// Misfire policies decide what happens to occurrences that were missed, e.g.
+// while the prequeuer was down. Skip is the default.
+const (
+	MisfirePolicySkip     = "skip"
+	MisfirePolicyFireOnce = "fire_once"
+	MisfirePolicyFireAll  = "fire_all"
+)
+
+// Concurrency policies decide what happens when an event is due while an
+// earlier event of the same schedule is still running. Allow is the default.
+const (
+	ConcurrencyPolicyAllow   = "allow"
+	ConcurrencyPolicyForbid  = "forbid"
+	ConcurrencyPolicyQueue   = "queue"
+	ConcurrencyPolicyReplace = "replace"
+)
 
 type Schedule struct {
-	ID          string            `bson:"_id,omitempty" json:"id,omitempty"`
-	Name        string            `bson:"name" json:"name"`
-	RRule       string            `bson:"rrule" json:"rrule"`
-	CallbackURL string            `bson:"callback_url" json:"callback_url"`
-	Method      string            `bson:"method,omitempty" json:"method,omitempty"`
-	Headers     map[string]string `bson:"headers,omitempty" json:"headers,omitempty"`
-	Body        string            `bson:"body,omitempty" json:"body,omitempty"`
-	CreatedAt   time.Time         `bson:"created_at,omitempty" json:"created_at,omitempty"`
+	ID                string            `bson:"_id,omitempty" json:"id,omitempty"`
+	Name              string            `bson:"name" json:"name"`
+	RRule             string            `bson:"rrule" json:"rrule"`
+	Timezone          string            `bson:"timezone,omitempty" json:"timezone,omitempty"`
+	CallbackURL       string            `bson:"callback_url" json:"callback_url"`
+	Method            string            `bson:"method,omitempty" json:"method,omitempty"`
+	Headers           map[string]string `bson:"headers,omitempty" json:"headers,omitempty"`
+	Body              string            `bson:"body,omitempty" json:"body,omitempty"`
+	Labels            map[string]string `bson:"labels,omitempty" json:"labels,omitempty"`
+	SuccessCriteria   *SuccessCriteria  `bson:"success_criteria,omitempty" json:"success_criteria,omitempty"`
+	RetryPolicy       *RetryPolicy      `bson:"retry_policy,omitempty" json:"retry_policy,omitempty"`
+	MisfirePolicy     string            `bson:"misfire_policy,omitempty" json:"misfire_policy,omitempty"`
+	ConcurrencyPolicy string            `bson:"concurrency_policy,omitempty" json:"concurrency_policy,omitempty"`
+	State             string            `bson:"state,omitempty" json:"state,omitempty"`
+	PausedAt          *time.Time        `bson:"paused_at,omitempty" json:"paused_at,omitempty"`
+	ExcludedRunTimes  []time.Time       `bson:"excluded_run_times,omitempty" json:"excluded_run_times,omitempty"`
+	NextRunTime       *time.Time        `bson:"next_run_time,omitempty" json:"next_run_time,omitempty"`
+	LastEventTime     *time.Time        `bson:"last_event_time,omitempty" json:"last_event_time,omitempty"`
+	CreatedAt         time.Time         `bson:"created_at,omitempty" json:"created_at,omitempty"`
+}
+
+// RecurrenceStart returns the time an RRULE without DTSTART starts at: the
+// schedule's creation time, or for schedules stored without one, the time in
+// their ObjectID. It never changes, so neither do the occurrences.
+func (s *Schedule) RecurrenceStart() time.Time {
+	if !s.CreatedAt.IsZero() {
+		return s.CreatedAt
+	}
+	if id, err := primitive.ObjectIDFromHex(s.ID); err == nil {
+		return id.Timestamp()
+	}
+	return time.Time{}
 }
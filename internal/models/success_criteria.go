@@ -0,0 +1,92 @@
+package models
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// SuccessCriteria decides whether a callback response counts as a success.
+// Without criteria, any 2xx response is a success.
+type SuccessCriteria struct {
+	// StatusCodes accepts exact codes ("204"), classes ("2xx") and ranges ("200-299").
+	StatusCodes []string `bson:"status_codes,omitempty" json:"status_codes,omitempty"`
+	// BodyMatch is an optional regular expression the response body must match.
+	BodyMatch string `bson:"body_match,omitempty" json:"body_match,omitempty"`
+}
+
+// Validate checks that every status code spec and the body pattern parse.
+func (c *SuccessCriteria) Validate() error {
+	if c == nil {
+		return nil
+	}
+	for _, spec := range c.StatusCodes {
+		if _, _, err := parseStatusCodeSpec(spec); err != nil {
+			return err
+		}
+	}
+	if c.BodyMatch != "" {
+		if _, err := regexp.Compile(c.BodyMatch); err != nil {
+			return fmt.Errorf("invalid body_match pattern: %w", err)
+		}
+	}
+	return nil
+}
+
+// AcceptsStatus reports whether the HTTP status code is accepted.
+func (c *SuccessCriteria) AcceptsStatus(statusCode int) bool {
+	if c == nil || len(c.StatusCodes) == 0 {
+		return statusCode >= 200 && statusCode <= 299
+	}
+	for _, spec := range c.StatusCodes {
+		low, high, err := parseStatusCodeSpec(spec)
+		if err == nil && statusCode >= low && statusCode <= high {
+			return true
+		}
+	}
+	return false
+}
+
+// AcceptsBody reports whether the response body satisfies BodyMatch.
+func (c *SuccessCriteria) AcceptsBody(body []byte) bool {
+	if c == nil || c.BodyMatch == "" {
+		return true
+	}
+	re, err := regexp.Compile(c.BodyMatch)
+	if err != nil {
+		return false
+	}
+	return re.Match(body)
+}
+
+// NeedsBody reports whether the response body has to be read to decide.
+func (c *SuccessCriteria) NeedsBody() bool {
+	return c != nil && c.BodyMatch != ""
+}
+
+func parseStatusCodeSpec(spec string) (int, int, error) {
+	spec = strings.ToLower(strings.TrimSpace(spec))
+	invalid := fmt.Errorf("invalid status code %q, expected e.g. 200, 2xx or 200-299", spec)
+
+	if len(spec) == 3 && strings.HasSuffix(spec, "xx") {
+		class, err := strconv.Atoi(spec[:1])
+		if err != nil || class < 1 || class > 5 {
+			return 0, 0, invalid
+		}
+		return class * 100, class*100 + 99, nil
+	}
+	if lowStr, highStr, ok := strings.Cut(spec, "-"); ok {
+		low, errLow := strconv.Atoi(lowStr)
+		high, errHigh := strconv.Atoi(highStr)
+		if errLow != nil || errHigh != nil || low < 100 || high > 599 || low > high {
+			return 0, 0, invalid
+		}
+		return low, high, nil
+	}
+	code, err := strconv.Atoi(spec)
+	if err != nil || code < 100 || code > 599 {
+		return 0, 0, invalid
+	}
+	return code, code, nil
+}
@@ -0,0 +1,160 @@
+package models
+
+import "testing"
+
+func TestParseStatusCodeSpec(t *testing.T) {
+	tests := []struct {
+		spec      string
+		low, high int
+		wantErr   bool
+	}{
+		{spec: "204", low: 204, high: 204},
+		{spec: " 418 ", low: 418, high: 418},
+		{spec: "2xx", low: 200, high: 299},
+		{spec: "5XX", low: 500, high: 599},
+		{spec: "200-299", low: 200, high: 299},
+		{spec: "301-301", low: 301, high: 301},
+		{spec: "", wantErr: true},
+		{spec: "ok", wantErr: true},
+		{spec: "99", wantErr: true},
+		{spec: "600", wantErr: true},
+		{spec: "0xx", wantErr: true},
+		{spec: "6xx", wantErr: true},
+		{spec: "axx", wantErr: true},
+		{spec: "2x", wantErr: true},
+		{spec: "299-200", wantErr: true},
+		{spec: "99-200", wantErr: true},
+		{spec: "200-600", wantErr: true},
+		{spec: "200-", wantErr: true},
+		{spec: "-200", wantErr: true},
+	}
+	for _, tt := range tests {
+		low, high, err := parseStatusCodeSpec(tt.spec)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("parseStatusCodeSpec(%q) = %d, %d; want an error", tt.spec, low, high)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseStatusCodeSpec(%q): %v", tt.spec, err)
+			continue
+		}
+		if low != tt.low || high != tt.high {
+			t.Errorf("parseStatusCodeSpec(%q) = %d, %d; want %d, %d", tt.spec, low, high, tt.low, tt.high)
+		}
+	}
+}
+
+func TestAcceptsStatus(t *testing.T) {
+	tests := []struct {
+		name     string
+		criteria *SuccessCriteria
+		accepted []int
+		rejected []int
+	}{
+		{
+			name:     "nil criteria accept 2xx",
+			accepted: []int{200, 204, 299},
+			rejected: []int{199, 301, 404, 500},
+		},
+		{
+			name:     "empty list accepts 2xx",
+			criteria: &SuccessCriteria{},
+			accepted: []int{200, 299},
+			rejected: []int{300, 500},
+		},
+		{
+			name:     "exact code",
+			criteria: &SuccessCriteria{StatusCodes: []string{"204"}},
+			accepted: []int{204},
+			rejected: []int{200, 205},
+		},
+		{
+			name:     "class",
+			criteria: &SuccessCriteria{StatusCodes: []string{"3xx"}},
+			accepted: []int{300, 302, 399},
+			rejected: []int{200, 400},
+		},
+		{
+			name:     "range",
+			criteria: &SuccessCriteria{StatusCodes: []string{"200-202"}},
+			accepted: []int{200, 201, 202},
+			rejected: []int{199, 203},
+		},
+		{
+			name:     "list",
+			criteria: &SuccessCriteria{StatusCodes: []string{"2xx", "404", "409-410"}},
+			accepted: []int{200, 404, 409, 410},
+			rejected: []int{400, 408, 411, 500},
+		},
+		{
+			name:     "invalid specs are ignored",
+			criteria: &SuccessCriteria{StatusCodes: []string{"bogus", "201"}},
+			accepted: []int{201},
+			rejected: []int{200},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, code := range tt.accepted {
+				if !tt.criteria.AcceptsStatus(code) {
+					t.Errorf("status %d rejected, want accepted", code)
+				}
+			}
+			for _, code := range tt.rejected {
+				if tt.criteria.AcceptsStatus(code) {
+					t.Errorf("status %d accepted, want rejected", code)
+				}
+			}
+		})
+	}
+}
+
+func TestAcceptsBody(t *testing.T) {
+	tests := []struct {
+		name      string
+		criteria  *SuccessCriteria
+		body      string
+		want      bool
+		needsBody bool
+	}{
+		{name: "nil criteria", body: "anything", want: true},
+		{name: "no pattern", criteria: &SuccessCriteria{}, body: "anything", want: true},
+		{name: "match", criteria: &SuccessCriteria{BodyMatch: `"status":\s*"ok"`}, body: `{"status": "ok"}`, want: true, needsBody: true},
+		{name: "no match", criteria: &SuccessCriteria{BodyMatch: `"status":\s*"ok"`}, body: `{"status": "failed"}`, needsBody: true},
+		{name: "anchored", criteria: &SuccessCriteria{BodyMatch: `^OK$`}, body: "NOT OK", needsBody: true},
+		{name: "invalid pattern", criteria: &SuccessCriteria{BodyMatch: `(`}, body: "(", needsBody: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.criteria.AcceptsBody([]byte(tt.body)); got != tt.want {
+				t.Errorf("AcceptsBody(%q) = %v, want %v", tt.body, got, tt.want)
+			}
+			if got := tt.criteria.NeedsBody(); got != tt.needsBody {
+				t.Errorf("NeedsBody() = %v, want %v", got, tt.needsBody)
+			}
+		})
+	}
+}
+
+func TestSuccessCriteriaValidate(t *testing.T) {
+	tests := []struct {
+		name     string
+		criteria *SuccessCriteria
+		wantErr  bool
+	}{
+		{name: "nil"},
+		{name: "empty", criteria: &SuccessCriteria{}},
+		{name: "valid", criteria: &SuccessCriteria{StatusCodes: []string{"2xx", "304", "400-404"}, BodyMatch: "ok"}},
+		{name: "invalid status code", criteria: &SuccessCriteria{StatusCodes: []string{"2xx", "abc"}}, wantErr: true},
+		{name: "invalid body pattern", criteria: &SuccessCriteria{BodyMatch: "[a-"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.criteria.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
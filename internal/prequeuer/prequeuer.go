@@ -2,91 +2,275 @@ package prequeuer
 
 import (
 	"context"
+	"fmt"
 	"time"
 
 	"github.com/cankoe/rrule-scheduler/internal/models"
+	"github.com/cankoe/rrule-scheduler/internal/queue"
+	"github.com/cankoe/rrule-scheduler/internal/recurrence"
+	"github.com/cankoe/rrule-scheduler/internal/store"
 
-	"github.com/go-redis/redis/v8"
 	"github.com/rs/zerolog/log"
-	"github.com/teambition/rrule-go"
-	"go.mongodb.org/mongo-driver/bson"
-	"go.mongodb.org/mongo-driver/bson/primitive"
-	"go.mongodb.org/mongo-driver/mongo"
 )
 
-// GenerateEvents finds schedules that have occurrences in [now, now+timeframe)
-// and creates events in "events" + pushes them into "ready_queue".
+// pendingEvent is an occurrence waiting to be written by the next flush.
+type pendingEvent struct {
+	scheduleID string
+	runTime    time.Time
+	// message overrides the message of the initial status entry.
+	message string
+}
+
+// generator collects the events and schedule watermarks of one tick and
+// writes them in batches.
+type generator struct {
+	scheduleStore store.ScheduleStore
+	eventStore    store.EventStore
+	delayQueue    queue.DelayQueue
+	now           time.Time
+	batchSize     int
+	// maxMisfiredRuns caps how many missed occurrences fire_all runs late.
+	maxMisfiredRuns int
+
+	events     []pendingEvent
+	watermarks []store.Watermark
+	// failed is set once a batch of events could not be written. Watermarks
+	// are no longer advanced then, so the schedules are picked up again.
+	failed bool
+}
+
+// GenerateEvents finds schedules whose next_run_time falls in
+// [now, now+timeframe) and creates events in "events" + pushes them into
+// "ready_queue". Paused schedules are skipped until they are resumed.
+//
+// Every schedule carries a watermark: next_run_time is the first occurrence
+// no event was generated for yet (null once the recurrence is exhausted) and
+// last_event_time the latest one that was. Schedules without a next_run_time,
+// i.e. new, updated or resumed ones, are always looked at.
+//
+// Run times skipped through the API are excluded from the recurrence, and
+// dropped from the schedule once they are in the past.
+//
+// Occurrences between a past next_run_time and now were missed, e.g. because
+// the prequeuer was down; the schedule's misfire policy decides whether they
+// still run, up to maxMisfiredRuns of them.
+//
+// Events are created once per (schedule_id, run_time) in batches of batchSize,
+// and only the events a batch actually created are added to the ready_queue,
+// so concurrent prequeuers never create or enqueue the same occurrence twice.
 func GenerateEvents(ctx context.Context,
-	schedulesCollection, eventsCollection *mongo.Collection,
-	redisClient *redis.Client,
+	scheduleStore store.ScheduleStore,
+	eventStore store.EventStore,
+	delayQueue queue.DelayQueue,
 	eventTimeframe time.Duration,
+	batchSize int,
+	maxMisfiredRuns int,
 ) {
 	now := time.Now().UTC()
 	endTime := now.Add(eventTimeframe)
 
 	log.Info().Time("start", now).Time("end", endTime).Msg("Generating events for timeframe")
-	cursor, err := schedulesCollection.Find(ctx, bson.M{})
+	g := &generator{
+		scheduleStore:   scheduleStore,
+		eventStore:      eventStore,
+		delayQueue:      delayQueue,
+		now:             now,
+		batchSize:       batchSize,
+		maxMisfiredRuns: maxMisfiredRuns,
+	}
+	err := scheduleStore.ForEachDue(ctx, endTime, func(schedule *models.Schedule) error {
+		rule, err := recurrence.Parse(schedule.RRule, schedule.Timezone, schedule.RecurrenceStart())
+		if err != nil {
+			log.Error().Err(err).Str("schedule_id", schedule.ID).Msg("Invalid RRULE")
+			return nil
+		}
+		rule.Exclude(schedule.ExcludedRunTimes...)
+		// Expand from the watermark rather than from the start of the
+		// recurrence, which can be years ago.
+		if schedule.NextRunTime != nil {
+			rule.Rebase(*schedule.NextRunTime)
+		}
+
+		var misfired []pendingEvent
+		if schedule.NextRunTime != nil && !schedule.NextRunTime.After(now) {
+			misfired = misfiredEvents(schedule, rule, *schedule.NextRunTime, now, g.maxMisfiredRuns)
+		}
+		for _, pe := range misfired {
+			g.addEvent(ctx, pe)
+		}
+
+		occurrences := rule.Between(now, endTime, false)
+		for _, occurrence := range occurrences {
+			g.addEvent(ctx, pendingEvent{scheduleID: schedule.ID, runTime: occurrence.UTC()})
+		}
+
+		var nextRun *time.Time
+		if next, ok := rule.After(endTime, true); ok {
+			next = next.UTC()
+			nextRun = &next
+		}
+		lastEvent := schedule.LastEventTime
+		if len(misfired) > 0 {
+			lastEvent = &misfired[len(misfired)-1].runTime
+		}
+		if len(occurrences) > 0 {
+			last := occurrences[len(occurrences)-1].UTC()
+			lastEvent = &last
+		}
+		g.addWatermark(schedule, nextRun, lastEvent)
+		return nil
+	})
 	if err != nil {
 		log.Error().Err(err).Msg("Error fetching schedules")
-		return
 	}
-	defer cursor.Close(ctx)
+	g.flush(ctx)
+}
+
+// misfiredEvents applies the schedule's misfire policy to the occurrences in
+// [from, to] and returns the events that should still run, at most maxRuns.
+func misfiredEvents(schedule *models.Schedule, rule *recurrence.Recurrence, from, to time.Time, maxRuns int) []pendingEvent {
+	keep := 0
+	switch schedule.MisfirePolicy {
+	case models.MisfirePolicyFireOnce:
+		keep = 1
+	case models.MisfirePolicyFireAll:
+		keep = maxRuns
+	}
 
-	for cursor.Next(ctx) {
-		var schedule models.Schedule
-		if err := cursor.Decode(&schedule); err != nil {
-			log.Error().Err(err).Msg("Error decoding schedule")
+	// Keep the latest occurrences only, without holding all of them.
+	var kept []time.Time
+	missed := 0
+	next := rule.Iterator()
+	for dt, ok := next(); ok && !dt.After(to); dt, ok = next() {
+		if dt.Before(from) {
 			continue
 		}
-		rule, err := rrule.StrToRRule(schedule.RRule)
-		if err != nil {
-			log.Error().Err(err).Str("schedule_id", schedule.ID).Msg("Invalid RRULE")
+		missed++
+		if keep == 0 {
 			continue
 		}
-		occurrences := rule.Between(now, endTime, false)
-		if len(occurrences) == 0 {
-			continue
+		if len(kept) == keep {
+			kept = kept[1:]
+		}
+		kept = append(kept, dt.UTC())
+	}
+	if missed == 0 {
+		return nil
+	}
+
+	policy := schedule.MisfirePolicy
+	if policy == "" {
+		policy = models.MisfirePolicySkip
+	}
+	log.Warn().Str("schedule_id", schedule.ID).Int("missed", missed).Int("firing", len(kept)).
+		Str("misfire_policy", policy).Msg("Schedule missed occurrences")
+
+	pending := make([]pendingEvent, 0, len(kept))
+	for _, runTime := range kept {
+		var message string
+		switch policy {
+		case models.MisfirePolicyFireOnce:
+			message = fmt.Sprintf("Running late: %d occurrence(s) since %s were missed, firing the latest once (misfire policy fire_once)",
+				missed, from.UTC().Format(time.RFC3339))
+		default:
+			message = fmt.Sprintf("Running late: occurrence was missed (misfire policy fire_all, %d of %d missed occurrences fired)",
+				len(kept), missed)
 		}
+		pending = append(pending, pendingEvent{scheduleID: schedule.ID, runTime: runTime, message: message})
+	}
+	return pending
+}
 
-		for _, occurrence := range occurrences {
-			existing := eventsCollection.FindOne(ctx, bson.M{
-				"schedule_id": schedule.ID,
-				"run_time":    occurrence,
-			})
-			if existing.Err() == nil {
-				log.Debug().Str("schedule_id", schedule.ID).Time("run_time", occurrence).Msg("Event already exists, skipping")
-				continue
-			}
-
-			event := bson.M{
-				"schedule_id": schedule.ID,
-				"run_time":    occurrence,
-				"status": []bson.M{{
-					"time":    now,
-					"status":  "ready_queue",
-					"message": "Event pre-queued for ready queue",
-				}},
-				"created_at": now,
-			}
-			insertResult, err := eventsCollection.InsertOne(ctx, event)
-			if err != nil {
-				log.Error().Err(err).Str("schedule_id", schedule.ID).Time("occurrence", occurrence).
-					Msg("Failed to insert event")
-				continue
-			}
-
-			// Push into Redis ZSET with score = run_time (epoch)
-			eventID := insertResult.InsertedID.(primitive.ObjectID).Hex()
-			if err := redisClient.ZAdd(ctx, "ready_queue", &redis.Z{
-				Score:  float64(occurrence.Unix()),
-				Member: eventID,
-			}).Err(); err != nil {
-				log.Error().Err(err).Str("event_id", eventID).Msg("Failed to enqueue event in ready_queue")
-				continue
-			}
-
-			log.Info().Str("event_id", eventID).Str("schedule_id", schedule.ID).
-				Time("run_time", occurrence).Msg("Pre-queued event")
+func (g *generator) addEvent(ctx context.Context, pe pendingEvent) {
+	g.events = append(g.events, pe)
+	if len(g.events) >= g.batchSize {
+		g.flush(ctx)
+	}
+}
+
+// addWatermark queues the watermark update of a schedule. It only applies if
+// the recurrence was not changed since the schedule was read; otherwise the
+// API has reset next_run_time and the schedule is recomputed on the next tick.
+func (g *generator) addWatermark(schedule *models.Schedule, nextRun, lastEvent *time.Time) {
+	w := store.Watermark{
+		ScheduleID:      schedule.ID,
+		RRule:           schedule.RRule,
+		Timezone:        schedule.Timezone,
+		PrevNextRunTime: schedule.NextRunTime,
+		NextRunTime:     nextRun,
+		LastEventTime:   lastEvent,
+	}
+	if len(schedule.ExcludedRunTimes) > 0 {
+		w.PruneExcludedBefore = g.now
+	}
+	g.watermarks = append(g.watermarks, w)
+}
+
+// flush writes the pending events, then the watermarks of the schedules whose
+// events are all written.
+func (g *generator) flush(ctx context.Context) {
+	if len(g.events) > 0 {
+		if !flushEvents(ctx, g.eventStore, g.delayQueue, g.events, g.now) {
+			g.failed = true
 		}
+		g.events = g.events[:0]
+	}
+
+	if len(g.watermarks) > 0 && !g.failed {
+		if err := g.scheduleStore.SetWatermarks(ctx, g.watermarks); err != nil {
+			log.Error().Err(err).Int("schedules", len(g.watermarks)).Msg("Failed to update schedule watermarks")
+		}
+	}
+	g.watermarks = g.watermarks[:0]
+}
+
+// flushEvents creates a batch of events and enqueues the newly created ones.
+// It returns false if the events could not be written.
+func flushEvents(ctx context.Context,
+	eventStore store.EventStore,
+	delayQueue queue.DelayQueue,
+	batch []pendingEvent,
+	now time.Time,
+) bool {
+	events := make([]models.Event, 0, len(batch))
+	for _, pe := range batch {
+		message := pe.message
+		if message == "" {
+			message = "Event pre-queued for ready queue"
+		}
+		events = append(events, models.Event{
+			ScheduleID: pe.scheduleID,
+			RunTime:    pe.runTime,
+			Status: []models.StatusEntry{{
+				Time:    now,
+				Status:  "ready_queue",
+				Message: message,
+			}},
+			CreatedAt: now,
+		})
+	}
+
+	ok := true
+	created, err := eventStore.CreateIfAbsent(ctx, events)
+	if err != nil {
+		log.Error().Err(err).Int("events", len(batch)).Msg("Failed to create events")
+		ok = false
+	}
+	if len(created) == 0 {
+		log.Debug().Int("events", len(batch)).Msg("No new events in batch")
+		return ok
+	}
+
+	// Enqueue in the ready_queue, due at the run time
+	items := make([]queue.Item, 0, len(created))
+	for _, event := range created {
+		items = append(items, queue.Item{ID: event.ID, DueAt: event.RunTime})
+		log.Info().Str("event_id", event.ID).Str("schedule_id", event.ScheduleID).
+			Time("run_time", event.RunTime).Msg("Pre-queued event")
+	}
+	if err := delayQueue.Schedule(ctx, items...); err != nil {
+		// The reconciler re-enqueues these events.
+		log.Error().Err(err).Int("events", len(items)).Msg("Failed to enqueue events in ready_queue")
 	}
+	return ok
 }
@@ -0,0 +1,279 @@
+package prequeuer
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cankoe/rrule-scheduler/internal/models"
+	"github.com/cankoe/rrule-scheduler/internal/queue"
+	"github.com/cankoe/rrule-scheduler/internal/store"
+	"github.com/cankoe/rrule-scheduler/internal/store/memstore"
+)
+
+const (
+	testTimeframe       = 10 * time.Minute
+	testMaxMisfiredRuns = 4
+)
+
+type testEnv struct {
+	schedules *memstore.ScheduleStore
+	events    *memstore.EventStore
+	queues    *queue.MemoryQueues
+}
+
+func newTestEnv() *testEnv {
+	return &testEnv{
+		schedules: memstore.NewScheduleStore(),
+		events:    memstore.NewEventStore(),
+		queues:    queue.NewMemoryQueues(),
+	}
+}
+
+func (env *testEnv) generate(t *testing.T) {
+	t.Helper()
+	GenerateEvents(context.Background(), env.schedules, env.events, env.queues, testTimeframe, 3, testMaxMisfiredRuns)
+}
+
+func (env *testEnv) createSchedule(t *testing.T, schedule models.Schedule) *models.Schedule {
+	t.Helper()
+	if schedule.CreatedAt.IsZero() {
+		schedule.CreatedAt = time.Now().UTC()
+	}
+	if _, err := env.schedules.Create(context.Background(), &schedule); err != nil {
+		t.Fatalf("create schedule: %v", err)
+	}
+	return &schedule
+}
+
+func (env *testEnv) pendingEvents(t *testing.T, scheduleID string) []models.Event {
+	t.Helper()
+	events, err := env.events.List(context.Background(), &store.EventFilter{ScheduleID: scheduleID, Ascending: true})
+	if err != nil {
+		t.Fatalf("list events: %v", err)
+	}
+	return events
+}
+
+func (env *testEnv) scheduledIDs(t *testing.T) map[string]bool {
+	t.Helper()
+	ids, err := env.queues.Scheduled(context.Background())
+	if err != nil {
+		t.Fatalf("list ready_queue: %v", err)
+	}
+	set := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		set[id] = true
+	}
+	return set
+}
+
+func TestGenerateEventsIsIdempotent(t *testing.T) {
+	env := newTestEnv()
+	// Without DTSTART, the run times depend on when the schedule was created
+	schedule := env.createSchedule(t, models.Schedule{
+		Name:      "every three minutes",
+		RRule:     "FREQ=MINUTELY;INTERVAL=3",
+		CreatedAt: time.Now().UTC().Add(-time.Hour - 37*time.Second),
+	})
+
+	env.generate(t)
+	first := env.pendingEvents(t, schedule.ID)
+	if len(first) == 0 {
+		t.Fatal("no events were generated")
+	}
+	queued := env.scheduledIDs(t)
+	for _, event := range first {
+		if event.RunTime.Sub(schedule.CreatedAt.Truncate(time.Minute))%(3*time.Minute) != 0 {
+			t.Errorf("event at %s does not follow the schedule's creation time", event.RunTime)
+		}
+		if !queued[event.ID] {
+			t.Errorf("event %s at %s is not in the ready_queue", event.ID, event.RunTime)
+		}
+	}
+
+	// Resuming a schedule resets its watermark, so the window is generated
+	// again from scratch.
+	if err := env.schedules.Resume(context.Background(), schedule.ID); err != nil {
+		t.Fatalf("resume schedule: %v", err)
+	}
+	env.generate(t)
+	env.generate(t)
+
+	second := env.pendingEvents(t, schedule.ID)
+	if len(second) != len(first) {
+		t.Fatalf("got %d events after regenerating, want %d", len(second), len(first))
+	}
+	for i := range first {
+		if second[i].ID != first[i].ID || !second[i].RunTime.Equal(first[i].RunTime) {
+			t.Errorf("event %d changed from %s at %s to %s at %s", i,
+				first[i].ID, first[i].RunTime, second[i].ID, second[i].RunTime)
+		}
+	}
+	if got := len(env.scheduledIDs(t)); got != len(queued) {
+		t.Errorf("got %d events in the ready_queue after regenerating, want %d", got, len(queued))
+	}
+}
+
+func TestGenerateEventsNextToManualEvent(t *testing.T) {
+	env := newTestEnv()
+	schedule := env.createSchedule(t, models.Schedule{
+		Name:  "every five minutes",
+		RRule: "DTSTART:20200101T000000Z\nRRULE:FREQ=MINUTELY;INTERVAL=5",
+	})
+
+	// A manual run at the time of the next occurrence
+	runTime := time.Now().UTC().Truncate(5 * time.Minute).Add(5 * time.Minute)
+	manual := &models.Event{ScheduleID: schedule.ID, RunTime: runTime, Manual: true, CreatedAt: time.Now().UTC()}
+	if _, err := env.events.Insert(context.Background(), manual); err != nil {
+		t.Fatalf("insert manual event: %v", err)
+	}
+
+	env.generate(t)
+
+	scheduled, err := env.events.GetByRunTime(context.Background(), schedule.ID, runTime)
+	if err != nil {
+		t.Fatalf("occurrence at %s was not generated: %v", runTime, err)
+	}
+	if scheduled.ID == manual.ID || scheduled.Manual {
+		t.Errorf("got the manual event for the occurrence at %s", runTime)
+	}
+	if _, err := env.events.Get(context.Background(), manual.ID); err != nil {
+		t.Errorf("manual event is gone: %v", err)
+	}
+}
+
+func TestGenerateEventsMisfirePolicies(t *testing.T) {
+	tests := []struct {
+		policy   string
+		wantLate int
+	}{
+		{policy: "", wantLate: 0},
+		{policy: models.MisfirePolicySkip, wantLate: 0},
+		{policy: models.MisfirePolicyFireOnce, wantLate: 1},
+		{policy: models.MisfirePolicyFireAll, wantLate: testMaxMisfiredRuns},
+	}
+	for _, tt := range tests {
+		t.Run("policy "+tt.policy, func(t *testing.T) {
+			env := newTestEnv()
+			// The prequeuer last ran half an hour ago
+			nextRun := time.Now().UTC().Truncate(time.Minute).Add(-30 * time.Minute)
+			schedule := env.createSchedule(t, models.Schedule{
+				Name:          "every minute",
+				RRule:         "FREQ=MINUTELY",
+				MisfirePolicy: tt.policy,
+				NextRunTime:   &nextRun,
+				CreatedAt:     time.Now().UTC().Add(-2 * time.Hour),
+			})
+
+			start := time.Now()
+			env.generate(t)
+
+			var late []models.Event
+			for _, event := range env.pendingEvents(t, schedule.ID) {
+				if event.RunTime.Before(nextRun) {
+					t.Errorf("event at %s is before the missed occurrences", event.RunTime)
+				}
+				if !event.RunTime.After(start) {
+					late = append(late, event)
+				}
+			}
+			if len(late) != tt.wantLate {
+				t.Fatalf("got %d late events, want %d", len(late), tt.wantLate)
+			}
+			for i, event := range late {
+				// The latest missed occurrences run, one minute apart
+				if want := start.Truncate(time.Minute).Add(time.Duration(i-len(late)+1) * time.Minute); !event.RunTime.Equal(want) {
+					t.Errorf("late event %d runs at %s, want %s", i, event.RunTime, want)
+				}
+				if message := event.Status[0].Message; !strings.HasPrefix(message, "Running late") {
+					t.Errorf("late event %d has status message %q", i, message)
+				}
+			}
+		})
+	}
+}
+
+func TestGenerateEventsAdvancesWatermarks(t *testing.T) {
+	env := newTestEnv()
+	schedule := env.createSchedule(t, models.Schedule{
+		Name:  "every four minutes",
+		RRule: "DTSTART:20200101T000000Z\nRRULE:FREQ=MINUTELY;INTERVAL=4",
+	})
+
+	start := time.Now().UTC()
+	env.generate(t)
+	end := time.Now().UTC().Add(testTimeframe)
+
+	events := env.pendingEvents(t, schedule.ID)
+	if len(events) == 0 {
+		t.Fatal("no events were generated")
+	}
+	stored, err := env.schedules.Get(context.Background(), schedule.ID)
+	if err != nil {
+		t.Fatalf("get schedule: %v", err)
+	}
+	if stored.LastEventTime == nil || !stored.LastEventTime.Equal(events[len(events)-1].RunTime) {
+		t.Errorf("got last_event_time %v, want %s", stored.LastEventTime, events[len(events)-1].RunTime)
+	}
+	// next_run_time is the first occurrence after the window
+	if stored.NextRunTime == nil {
+		t.Fatal("next_run_time was not set")
+	}
+	if next := *stored.NextRunTime; next.Sub(*stored.LastEventTime) != 4*time.Minute ||
+		next.Before(start.Add(testTimeframe)) || next.After(end.Add(4*time.Minute)) {
+		t.Errorf("got next_run_time %s after last event %s", next, stored.LastEventTime)
+	}
+
+	// The schedule is not due again until its next run enters the window
+	env.generate(t)
+	if got := len(env.pendingEvents(t, schedule.ID)); got != len(events) {
+		t.Errorf("got %d events after the next tick, want %d", got, len(events))
+	}
+	again, err := env.schedules.Get(context.Background(), schedule.ID)
+	if err != nil {
+		t.Fatalf("get schedule: %v", err)
+	}
+	if !again.NextRunTime.Equal(*stored.NextRunTime) {
+		t.Errorf("next_run_time moved from %s to %s", stored.NextRunTime, again.NextRunTime)
+	}
+}
+
+func TestGenerateEventsCostDoesNotGrowWithAge(t *testing.T) {
+	env := newTestEnv()
+	// Without DTSTART, the recurrence starts when the schedule was created:
+	// over 5 million occurrences ago.
+	schedule := env.createSchedule(t, models.Schedule{
+		Name:      "minutely",
+		RRule:     "FREQ=MINUTELY",
+		Timezone:  "Europe/Berlin",
+		CreatedAt: time.Now().UTC().AddDate(-10, 0, 0),
+	})
+	// The watermark left by the previous tick
+	next := time.Now().UTC().Truncate(time.Minute).Add(time.Minute)
+	if err := env.schedules.SetWatermarks(context.Background(), []store.Watermark{{
+		ScheduleID:  schedule.ID,
+		RRule:       schedule.RRule,
+		Timezone:    schedule.Timezone,
+		NextRunTime: &next,
+	}}); err != nil {
+		t.Fatalf("set watermark: %v", err)
+	}
+
+	start := time.Now()
+	env.generate(t)
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("generating took %s, want it independent of the schedule's age", elapsed)
+	}
+
+	events := env.pendingEvents(t, schedule.ID)
+	if len(events) < 9 || len(events) > 10 || !events[0].RunTime.Equal(next) {
+		t.Fatalf("got %d events starting at %v, want about 10 starting at %s", len(events), events, next)
+	}
+	for i := 1; i < len(events); i++ {
+		if d := events[i].RunTime.Sub(events[i-1].RunTime); d != time.Minute {
+			t.Errorf("events %d and %d are %s apart, want a minute", i-1, i, d)
+		}
+	}
+}
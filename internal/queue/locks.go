@@ -0,0 +1,97 @@
+package queue
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// ScheduleLocker hands out per-schedule locks, so that at most one event of a
+// schedule runs at a time. Locks expire after their TTL unless extended.
+type ScheduleLocker interface {
+	// Acquire tries to make the event the only one of its schedule that is
+	// running. If the lock is taken, it returns false and the ID of the event
+	// holding it.
+	Acquire(ctx context.Context, scheduleID, eventID string, ttl time.Duration) (bool, string, error)
+	// TakeOver gives the lock to the event, whether or not another event holds
+	// it, and returns the previous holder.
+	TakeOver(ctx context.Context, scheduleID, eventID string, ttl time.Duration) (string, error)
+	// Extend extends the lock held by the event. It returns false if the event
+	// no longer holds it, e.g. because a newer event took it over.
+	Extend(ctx context.Context, scheduleID, eventID string, ttl time.Duration) (bool, error)
+	// Release releases the lock if the event still holds it.
+	Release(ctx context.Context, scheduleID, eventID string) error
+}
+
+// RedisScheduleLocker keeps the locks in Redis, under schedule_lock:<id>.
+type RedisScheduleLocker struct {
+	client *redis.Client
+}
+
+func NewRedisScheduleLocker(client *redis.Client) *RedisScheduleLocker {
+	return &RedisScheduleLocker{client: client}
+}
+
+// extendLockScript extends a schedule lock if it is still held by the event.
+var extendLockScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return 0
+`)
+
+// releaseLockScript releases a schedule lock if it is still held by the event.
+var releaseLockScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+
+// takeOverLockScript hands a schedule lock to the event and returns the event
+// that held it before, if any.
+var takeOverLockScript = redis.NewScript(`
+local previous = redis.call("GET", KEYS[1])
+redis.call("SET", KEYS[1], ARGV[1], "PX", ARGV[2])
+return previous
+`)
+
+func scheduleLockKey(scheduleID string) string {
+	return "schedule_lock:" + scheduleID
+}
+
+func (l *RedisScheduleLocker) Acquire(ctx context.Context, scheduleID, eventID string, ttl time.Duration) (bool, string, error) {
+	key := scheduleLockKey(scheduleID)
+	acquired, err := l.client.SetNX(ctx, key, eventID, ttl).Result()
+	if err != nil || acquired {
+		return acquired, "", err
+	}
+	holder, err := l.client.Get(ctx, key).Result()
+	if err == redis.Nil {
+		// Released in the meantime
+		return l.Acquire(ctx, scheduleID, eventID, ttl)
+	}
+	if holder == eventID {
+		// A redelivered event that still holds its own lock
+		return true, "", l.client.PExpire(ctx, key, ttl).Err()
+	}
+	return false, holder, err
+}
+
+func (l *RedisScheduleLocker) TakeOver(ctx context.Context, scheduleID, eventID string, ttl time.Duration) (string, error) {
+	previous, err := takeOverLockScript.Run(ctx, l.client, []string{scheduleLockKey(scheduleID)}, eventID, ttl.Milliseconds()).Text()
+	if err == redis.Nil {
+		return "", nil
+	}
+	return previous, err
+}
+
+func (l *RedisScheduleLocker) Extend(ctx context.Context, scheduleID, eventID string, ttl time.Duration) (bool, error) {
+	extended, err := extendLockScript.Run(ctx, l.client, []string{scheduleLockKey(scheduleID)}, eventID, ttl.Milliseconds()).Int64()
+	return extended == 1, err
+}
+
+func (l *RedisScheduleLocker) Release(ctx context.Context, scheduleID, eventID string) error {
+	return releaseLockScript.Run(ctx, l.client, []string{scheduleLockKey(scheduleID)}, eventID).Err()
+}
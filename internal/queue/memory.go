@@ -0,0 +1,251 @@
+package queue
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MemoryQueues keeps the queues in memory, for running the whole pipeline in
+// a single process. Like their Redis counterparts, due times and lease
+// deadlines have a resolution of one second.
+type MemoryQueues struct {
+	mu      sync.Mutex
+	ready   map[string]int64
+	waiting []string // claimed from the back, like RPOP on the worker_queue
+	leases  map[string]int64
+}
+
+func NewMemoryQueues() *MemoryQueues {
+	return &MemoryQueues{
+		ready:  make(map[string]int64),
+		leases: make(map[string]int64),
+	}
+}
+
+func (q *MemoryQueues) Schedule(ctx context.Context, items ...Item) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for _, item := range items {
+		if _, queued := q.ready[item.ID]; !queued {
+			q.ready[item.ID] = item.DueAt.Unix()
+		}
+	}
+	return nil
+}
+
+func (q *MemoryQueues) Reschedule(ctx context.Context, item Item) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.ready[item.ID] = item.DueAt.Unix()
+	return nil
+}
+
+func (q *MemoryQueues) Remove(ctx context.Context, eventID string) (bool, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	_, queued := q.ready[eventID]
+	delete(q.ready, eventID)
+	return queued, nil
+}
+
+func (q *MemoryQueues) DispatchDue(ctx context.Context, now time.Time, limit int) ([]string, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	due := expired(q.ready, now, limit)
+	for _, eventID := range due {
+		delete(q.ready, eventID)
+		q.waiting = append([]string{eventID}, q.waiting...)
+	}
+	return due, nil
+}
+
+func (q *MemoryQueues) Scheduled(ctx context.Context) ([]string, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return keys(q.ready), nil
+}
+
+func (q *MemoryQueues) Push(ctx context.Context, eventID string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.waiting = append([]string{eventID}, q.waiting...)
+	return nil
+}
+
+func (q *MemoryQueues) Claim(ctx context.Context, visibility time.Duration) (string, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if len(q.waiting) == 0 {
+		return "", ErrEmpty
+	}
+	eventID := q.waiting[len(q.waiting)-1]
+	q.waiting = q.waiting[:len(q.waiting)-1]
+	q.leases[eventID] = time.Now().Add(visibility).Unix()
+	return eventID, nil
+}
+
+func (q *MemoryQueues) ExtendLease(ctx context.Context, eventID string, visibility time.Duration) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if _, leased := q.leases[eventID]; leased {
+		q.leases[eventID] = time.Now().Add(visibility).Unix()
+	}
+	return nil
+}
+
+func (q *MemoryQueues) Ack(ctx context.Context, eventID string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	delete(q.leases, eventID)
+	return nil
+}
+
+func (q *MemoryQueues) ReapExpired(ctx context.Context, now time.Time, limit int) ([]string, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	reaped := expired(q.leases, now, limit)
+	for _, eventID := range reaped {
+		delete(q.leases, eventID)
+		q.waiting = append(q.waiting, eventID)
+	}
+	return reaped, nil
+}
+
+func (q *MemoryQueues) Waiting(ctx context.Context) ([]string, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return append([]string(nil), q.waiting...), nil
+}
+
+func (q *MemoryQueues) Leased(ctx context.Context) ([]string, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return keys(q.leases), nil
+}
+
+func (q *MemoryQueues) Purge(ctx context.Context, eventID string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	delete(q.ready, eventID)
+	delete(q.leases, eventID)
+	waiting := q.waiting[:0]
+	for _, id := range q.waiting {
+		if id != eventID {
+			waiting = append(waiting, id)
+		}
+	}
+	q.waiting = waiting
+	return nil
+}
+
+// expired returns up to limit members scored at or before now, ordered by
+// score and then ID, like ZRANGEBYSCORE.
+func expired(scores map[string]int64, now time.Time, limit int) []string {
+	var ids []string
+	for id, score := range scores {
+		if score <= now.Unix() {
+			ids = append(ids, id)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		if scores[ids[i]] != scores[ids[j]] {
+			return scores[ids[i]] < scores[ids[j]]
+		}
+		return ids[i] < ids[j]
+	})
+	if limit > 0 && len(ids) > limit {
+		ids = ids[:limit]
+	}
+	return ids
+}
+
+func keys(scores map[string]int64) []string {
+	ids := make([]string, 0, len(scores))
+	for id := range scores {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// MemoryScheduleLocker keeps the schedule locks in memory.
+type MemoryScheduleLocker struct {
+	mu    sync.Mutex
+	locks map[string]memoryLock
+}
+
+type memoryLock struct {
+	eventID   string
+	expiresAt time.Time
+}
+
+func NewMemoryScheduleLocker() *MemoryScheduleLocker {
+	return &MemoryScheduleLocker{locks: make(map[string]memoryLock)}
+}
+
+// holder returns the event holding the schedule's lock, if it has not expired.
+func (l *MemoryScheduleLocker) holder(scheduleID string) string {
+	lock, ok := l.locks[scheduleID]
+	if !ok || !time.Now().Before(lock.expiresAt) {
+		delete(l.locks, scheduleID)
+		return ""
+	}
+	return lock.eventID
+}
+
+func (l *MemoryScheduleLocker) Acquire(ctx context.Context, scheduleID, eventID string, ttl time.Duration) (bool, string, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// A redelivered event that still holds its own lock acquires it again
+	if holder := l.holder(scheduleID); holder != "" && holder != eventID {
+		return false, holder, nil
+	}
+	l.locks[scheduleID] = memoryLock{eventID: eventID, expiresAt: time.Now().Add(ttl)}
+	return true, "", nil
+}
+
+func (l *MemoryScheduleLocker) TakeOver(ctx context.Context, scheduleID, eventID string, ttl time.Duration) (string, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	previous := l.holder(scheduleID)
+	l.locks[scheduleID] = memoryLock{eventID: eventID, expiresAt: time.Now().Add(ttl)}
+	return previous, nil
+}
+
+func (l *MemoryScheduleLocker) Extend(ctx context.Context, scheduleID, eventID string, ttl time.Duration) (bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.holder(scheduleID) != eventID {
+		return false, nil
+	}
+	l.locks[scheduleID] = memoryLock{eventID: eventID, expiresAt: time.Now().Add(ttl)}
+	return true, nil
+}
+
+func (l *MemoryScheduleLocker) Release(ctx context.Context, scheduleID, eventID string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.holder(scheduleID) == eventID {
+		delete(l.locks, scheduleID)
+	}
+	return nil
+}
@@ -0,0 +1,161 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestMemoryQueuesDispatchDue(t *testing.T) {
+	ctx := context.Background()
+	q := NewMemoryQueues()
+	now := time.Now()
+
+	if err := q.Schedule(ctx,
+		Item{ID: "later", DueAt: now.Add(time.Hour)},
+		Item{ID: "second", DueAt: now.Add(-time.Minute)},
+		Item{ID: "first", DueAt: now.Add(-time.Hour)},
+	); err != nil {
+		t.Fatalf("schedule: %v", err)
+	}
+	// Scheduling a queued event again keeps its due time
+	if err := q.Schedule(ctx, Item{ID: "later", DueAt: now.Add(-2 * time.Hour)}); err != nil {
+		t.Fatalf("schedule: %v", err)
+	}
+
+	due, err := q.DispatchDue(ctx, now, 10)
+	if err != nil {
+		t.Fatalf("dispatch: %v", err)
+	}
+	if want := []string{"first", "second"}; !reflect.DeepEqual(due, want) {
+		t.Errorf("got due events %v, want %v", due, want)
+	}
+	if scheduled, _ := q.Scheduled(ctx); !reflect.DeepEqual(scheduled, []string{"later"}) {
+		t.Errorf("got scheduled events %v, want [later]", scheduled)
+	}
+
+	// Due events are claimed in the order they became due
+	for _, want := range []string{"first", "second"} {
+		if got, err := q.Claim(ctx, time.Minute); err != nil || got != want {
+			t.Errorf("got claim %q (err %v), want %q", got, err, want)
+		}
+	}
+	if _, err := q.Claim(ctx, time.Minute); !errors.Is(err, ErrEmpty) {
+		t.Errorf("got %v claiming from an empty queue, want ErrEmpty", err)
+	}
+}
+
+func TestMemoryQueuesLeases(t *testing.T) {
+	ctx := context.Background()
+	q := NewMemoryQueues()
+	for _, id := range []string{"acked", "extended", "expired"} {
+		if err := q.Push(ctx, id); err != nil {
+			t.Fatalf("push: %v", err)
+		}
+	}
+	for range 3 {
+		if _, err := q.Claim(ctx, time.Minute); err != nil {
+			t.Fatalf("claim: %v", err)
+		}
+	}
+	if leased, _ := q.Leased(ctx); len(leased) != 3 {
+		t.Fatalf("got leased events %v, want 3", leased)
+	}
+
+	if err := q.Ack(ctx, "acked"); err != nil {
+		t.Fatalf("ack: %v", err)
+	}
+	if err := q.ExtendLease(ctx, "extended", time.Hour); err != nil {
+		t.Fatalf("extend lease: %v", err)
+	}
+
+	if reaped, _ := q.ReapExpired(ctx, time.Now(), 10); len(reaped) != 0 {
+		t.Errorf("reaped %v before the leases expired", reaped)
+	}
+	reaped, err := q.ReapExpired(ctx, time.Now().Add(2*time.Minute), 10)
+	if err != nil {
+		t.Fatalf("reap: %v", err)
+	}
+	if !reflect.DeepEqual(reaped, []string{"expired"}) {
+		t.Errorf("got reaped events %v, want [expired]", reaped)
+	}
+	if leased, _ := q.Leased(ctx); !reflect.DeepEqual(leased, []string{"extended"}) {
+		t.Errorf("got leased events %v, want [extended]", leased)
+	}
+
+	// A reaped event is claimed again
+	if got, err := q.Claim(ctx, time.Minute); err != nil || got != "expired" {
+		t.Errorf("got claim %q (err %v), want the reaped event", got, err)
+	}
+
+	// Extending a lease that was acked does not lease the event again
+	if err := q.ExtendLease(ctx, "acked", time.Hour); err != nil {
+		t.Fatalf("extend lease: %v", err)
+	}
+	if leased, _ := q.Leased(ctx); !reflect.DeepEqual(leased, []string{"expired", "extended"}) {
+		t.Errorf("got leased events %v, want [expired extended]", leased)
+	}
+}
+
+func TestMemoryQueuesPurge(t *testing.T) {
+	ctx := context.Background()
+	q := NewMemoryQueues()
+	if err := q.Schedule(ctx, Item{ID: "scheduled", DueAt: time.Now().Add(time.Hour)}); err != nil {
+		t.Fatalf("schedule: %v", err)
+	}
+	for _, id := range []string{"waiting", "leased"} {
+		if err := q.Push(ctx, id); err != nil {
+			t.Fatalf("push: %v", err)
+		}
+	}
+	if _, err := q.Claim(ctx, time.Minute); err != nil {
+		t.Fatalf("claim: %v", err)
+	}
+
+	for _, id := range []string{"scheduled", "waiting", "leased"} {
+		if err := q.Purge(ctx, id); err != nil {
+			t.Fatalf("purge: %v", err)
+		}
+	}
+	scheduled, _ := q.Scheduled(ctx)
+	waiting, _ := q.Waiting(ctx)
+	leased, _ := q.Leased(ctx)
+	if len(scheduled)+len(waiting)+len(leased) != 0 {
+		t.Errorf("events left after purging: scheduled %v, waiting %v, leased %v", scheduled, waiting, leased)
+	}
+}
+
+func TestMemoryScheduleLocker(t *testing.T) {
+	ctx := context.Background()
+	l := NewMemoryScheduleLocker()
+
+	if ok, _, err := l.Acquire(ctx, "schedule", "first", time.Minute); err != nil || !ok {
+		t.Fatalf("got acquire %v (err %v), want the lock", ok, err)
+	}
+	if ok, holder, _ := l.Acquire(ctx, "schedule", "second", time.Minute); ok || holder != "first" {
+		t.Errorf("got acquire %v held by %q, want it held by first", ok, holder)
+	}
+	if ok, _ := l.Extend(ctx, "schedule", "second", time.Minute); ok {
+		t.Error("extended a lock held by another event")
+	}
+	if previous, _ := l.TakeOver(ctx, "schedule", "second", time.Minute); previous != "first" {
+		t.Errorf("took over from %q, want first", previous)
+	}
+	// Releasing a lock that was taken over leaves it to the new holder
+	if err := l.Release(ctx, "schedule", "first"); err != nil {
+		t.Fatalf("release: %v", err)
+	}
+	if ok, holder, _ := l.Acquire(ctx, "schedule", "third", time.Minute); ok || holder != "second" {
+		t.Errorf("got acquire %v held by %q, want it held by second", ok, holder)
+	}
+
+	// Expired locks are free
+	if _, err := l.TakeOver(ctx, "schedule", "second", -time.Second); err != nil {
+		t.Fatalf("take over: %v", err)
+	}
+	if ok, _, _ := l.Acquire(ctx, "schedule", "third", time.Minute); !ok {
+		t.Error("could not acquire an expired lock")
+	}
+}
@@ -0,0 +1,62 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrEmpty is returned by WorkQueue.Claim when no event is waiting.
+var ErrEmpty = errors.New("queue is empty")
+
+// Item is a queued event ID and the time it is due at.
+type Item struct {
+	ID    string
+	DueAt time.Time
+}
+
+// DelayQueue holds pending events until they are due (the ready_queue).
+// Due times have a resolution of one second.
+type DelayQueue interface {
+	// Schedule adds the items. Items that are already queued keep their due time.
+	Schedule(ctx context.Context, items ...Item) error
+	// Reschedule adds the item, or moves it to its new due time.
+	Reschedule(ctx context.Context, item Item) error
+	// Remove takes an event out of the queue and reports whether it was queued.
+	Remove(ctx context.Context, eventID string) (bool, error)
+	// DispatchDue moves up to limit events that are due at now to the work
+	// queue, atomically, and returns their IDs.
+	DispatchDue(ctx context.Context, now time.Time, limit int) ([]string, error)
+	// Scheduled returns the IDs of all queued events.
+	Scheduled(ctx context.Context) ([]string, error)
+}
+
+// WorkQueue holds due events until a worker claims them (the worker_queue),
+// and the leases of claimed events (worker_leases).
+type WorkQueue interface {
+	// Push adds an event at the back of the queue.
+	Push(ctx context.Context, eventID string) error
+	// Claim takes the next event and leases it for the visibility timeout. It
+	// returns ErrEmpty when no event is waiting.
+	Claim(ctx context.Context, visibility time.Duration) (string, error)
+	// ExtendLease pushes the lease deadline of a claimed event forward. It is
+	// a no-op when the lease is gone, e.g. because it was already reaped.
+	ExtendLease(ctx context.Context, eventID string, visibility time.Duration) error
+	// Ack releases the lease of an event once the worker is done with it.
+	Ack(ctx context.Context, eventID string) error
+	// ReapExpired puts up to limit events whose lease expired before now back
+	// at the front of the queue and returns their IDs.
+	ReapExpired(ctx context.Context, now time.Time, limit int) ([]string, error)
+	// Waiting returns the IDs of the events waiting for a worker.
+	Waiting(ctx context.Context) ([]string, error)
+	// Leased returns the IDs of the events held by a worker.
+	Leased(ctx context.Context) ([]string, error)
+}
+
+// Queues is a queue backend, providing both queues.
+type Queues interface {
+	DelayQueue
+	WorkQueue
+	// Purge removes an event from both queues and drops its lease.
+	Purge(ctx context.Context, eventID string) error
+}
@@ -0,0 +1,98 @@
+package queue
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// RedisQueues keeps the queues in Redis, under ReadyQueueKey, WorkerQueueKey
+// and WorkerLeasesKey, so they are shared by every service.
+type RedisQueues struct {
+	client *redis.Client
+}
+
+func NewRedisQueues(client *redis.Client) *RedisQueues {
+	return &RedisQueues{client: client}
+}
+
+func (q *RedisQueues) Schedule(ctx context.Context, items ...Item) error {
+	if len(items) == 0 {
+		return nil
+	}
+	members := make([]*redis.Z, 0, len(items))
+	for _, item := range items {
+		members = append(members, &redis.Z{
+			Score:  float64(item.DueAt.Unix()),
+			Member: item.ID,
+		})
+	}
+	return q.client.ZAddNX(ctx, ReadyQueueKey, members...).Err()
+}
+
+func (q *RedisQueues) Reschedule(ctx context.Context, item Item) error {
+	return q.client.ZAdd(ctx, ReadyQueueKey, &redis.Z{
+		Score:  float64(item.DueAt.Unix()),
+		Member: item.ID,
+	}).Err()
+}
+
+func (q *RedisQueues) Remove(ctx context.Context, eventID string) (bool, error) {
+	removed, err := q.client.ZRem(ctx, ReadyQueueKey, eventID).Result()
+	return removed > 0, err
+}
+
+func (q *RedisQueues) DispatchDue(ctx context.Context, now time.Time, limit int) ([]string, error) {
+	return dispatchScript.Run(ctx, q.client, []string{ReadyQueueKey, WorkerQueueKey}, now.Unix(), limit).StringSlice()
+}
+
+func (q *RedisQueues) Scheduled(ctx context.Context) ([]string, error) {
+	return q.client.ZRange(ctx, ReadyQueueKey, 0, -1).Result()
+}
+
+func (q *RedisQueues) Push(ctx context.Context, eventID string) error {
+	return q.client.LPush(ctx, WorkerQueueKey, eventID).Err()
+}
+
+func (q *RedisQueues) Claim(ctx context.Context, visibility time.Duration) (string, error) {
+	deadline := time.Now().Add(visibility).Unix()
+	eventID, err := claimScript.Run(ctx, q.client, []string{WorkerQueueKey, WorkerLeasesKey}, deadline).Text()
+	if err == redis.Nil {
+		return "", ErrEmpty
+	}
+	return eventID, err
+}
+
+func (q *RedisQueues) ExtendLease(ctx context.Context, eventID string, visibility time.Duration) error {
+	return q.client.ZAddXX(ctx, WorkerLeasesKey, &redis.Z{
+		Score:  float64(time.Now().Add(visibility).Unix()),
+		Member: eventID,
+	}).Err()
+}
+
+func (q *RedisQueues) Ack(ctx context.Context, eventID string) error {
+	return q.client.ZRem(ctx, WorkerLeasesKey, eventID).Err()
+}
+
+func (q *RedisQueues) ReapExpired(ctx context.Context, now time.Time, limit int) ([]string, error) {
+	return reapScript.Run(ctx, q.client, []string{WorkerLeasesKey, WorkerQueueKey}, now.Unix(), limit).StringSlice()
+}
+
+func (q *RedisQueues) Waiting(ctx context.Context) ([]string, error) {
+	return q.client.LRange(ctx, WorkerQueueKey, 0, -1).Result()
+}
+
+func (q *RedisQueues) Leased(ctx context.Context) ([]string, error) {
+	return q.client.ZRange(ctx, WorkerLeasesKey, 0, -1).Result()
+}
+
+func (q *RedisQueues) Purge(ctx context.Context, eventID string) error {
+	_, err := q.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.ZRem(ctx, ReadyQueueKey, eventID)
+		pipe.LRem(ctx, WorkerQueueKey, 0, eventID)
+		pipe.ZRem(ctx, WorkerLeasesKey, eventID)
+		return nil
+	})
+	return err
+}
@@ -0,0 +1,47 @@
+package queue
+
+import (
+	"github.com/go-redis/redis/v8"
+)
+
+const (
+	// ReadyQueueKey is the sorted set of pending events, scored by run time.
+	ReadyQueueKey = "ready_queue"
+	// WorkerQueueKey is the list of due events waiting for a worker.
+	WorkerQueueKey = "worker_queue"
+	// WorkerLeasesKey is the sorted set of events currently held by a worker,
+	// scored by the unix time at which the lease expires.
+	WorkerLeasesKey = "worker_leases"
+)
+
+// dispatchScript moves due events from the ready queue to the worker queue.
+// Running it as a script means an event is always in exactly one of them.
+var dispatchScript = redis.NewScript(`
+local ids = redis.call("ZRANGEBYSCORE", KEYS[1], "-inf", ARGV[1], "LIMIT", 0, ARGV[2])
+for _, id in ipairs(ids) do
+	redis.call("ZREM", KEYS[1], id)
+	redis.call("LPUSH", KEYS[2], id)
+end
+return ids
+`)
+
+// claimScript pops the next event from the worker queue and leases it in the
+// same step, so an event is never held by a worker without a lease.
+var claimScript = redis.NewScript(`
+local id = redis.call("RPOP", KEYS[1])
+if not id then
+	return false
+end
+redis.call("ZADD", KEYS[2], ARGV[1], id)
+return id
+`)
+
+// reapScript moves expired leases back to the head of the worker queue.
+var reapScript = redis.NewScript(`
+local ids = redis.call("ZRANGEBYSCORE", KEYS[1], "-inf", ARGV[1], "LIMIT", 0, ARGV[2])
+for _, id in ipairs(ids) do
+	redis.call("ZREM", KEYS[1], id)
+	redis.call("RPUSH", KEYS[2], id)
+end
+return ids
+`)
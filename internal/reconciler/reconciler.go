@@ -0,0 +1,155 @@
+package reconciler
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/cankoe/rrule-scheduler/internal/events"
+	"github.com/cankoe/rrule-scheduler/internal/models"
+	"github.com/cankoe/rrule-scheduler/internal/queue"
+	"github.com/cankoe/rrule-scheduler/internal/store"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Report summarises what a reconciliation run found and repaired.
+type Report struct {
+	Scanned    int
+	Reenqueued int
+	Orphans    int
+	Failed     int
+}
+
+// Reconcile compares the pending events in the event store with the queues.
+// Events that are in none of ready_queue, worker_queue and worker_leases are
+// put back into the ready_queue (at their retry or run time), and queue
+// entries without a pending event are removed.
+//
+// Events created less than gracePeriod ago are left alone, since the
+// prequeuer writes to the event store before it writes to the queues.
+func Reconcile(ctx context.Context,
+	queues queue.Queues,
+	eventStore store.EventStore,
+	gracePeriod time.Duration,
+) (Report, error) {
+	var report Report
+	startedAt := time.Now().UTC()
+
+	// Read the queues in the order events move through them, so an event
+	// that moves on while we read is still seen in one of them.
+	queued, err := snapshotQueues(ctx, queues)
+	if err != nil {
+		return report, err
+	}
+
+	pending := make(map[string]bool)
+	var missing []string
+	err = eventStore.ForEach(ctx, func(event *models.Event) error {
+		pending[event.ID] = true
+		report.Scanned++
+
+		if queued[event.ID] == "" && !event.CreatedAt.After(startedAt.Add(-gracePeriod)) {
+			missing = append(missing, event.ID)
+		}
+		return nil
+	})
+	if err != nil {
+		return report, err
+	}
+
+	if len(missing) > 0 {
+		// An event that moved back to the ready_queue while the queues were
+		// read (a retry or a lock wait) can be missed by the snapshot, so an
+		// event only counts as missing if a second snapshot misses it too.
+		requeued, err := snapshotQueues(ctx, queues)
+		if err != nil {
+			return report, err
+		}
+		for _, eventID := range missing {
+			if requeued[eventID] != "" {
+				continue
+			}
+			reenqueued, err := reenqueue(ctx, queues, eventStore, eventID)
+			if err != nil {
+				log.Error().Err(err).Str("event_id", eventID).Msg("Failed to re-enqueue event")
+				report.Failed++
+			} else if reenqueued {
+				report.Reenqueued++
+			}
+		}
+	}
+
+	for eventID, key := range queued {
+		if pending[eventID] {
+			continue
+		}
+		// Remove it from every queue, not only the one it was last seen in
+		if err := queues.Purge(ctx, eventID); err != nil {
+			log.Error().Err(err).Str("event_id", eventID).Str("queue", key).Msg("Failed to remove orphaned queue entry")
+			report.Failed++
+			continue
+		}
+		log.Warn().Str("event_id", eventID).Str("queue", key).Msg("Removed queue entry without a pending event")
+		report.Orphans++
+	}
+	return report, nil
+}
+
+// reenqueue puts a pending event that is missing from the queues back into
+// the ready_queue, at its retry or run time. An event that was archived in
+// the meantime is left alone, and false is returned.
+func reenqueue(ctx context.Context, queues queue.Queues, eventStore store.EventStore, eventID string) (bool, error) {
+	event, err := eventStore.Get(ctx, eventID)
+	if errors.Is(err, store.ErrNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	dueAt := event.RunTime
+	if event.RetryAt != nil {
+		dueAt = *event.RetryAt
+	}
+	// NX: the event may have been enqueued since the snapshot was taken.
+	if err := queues.Schedule(ctx, queue.Item{ID: eventID, DueAt: dueAt}); err != nil {
+		return false, err
+	}
+	if err := events.UpdateEventStatus(ctx, eventStore, eventID, "ready_queue",
+		"Event was missing from the queues and was re-enqueued by the reconciler"); err != nil {
+		log.Error().Err(err).Str("event_id", eventID).Msg("Failed to record re-enqueued event")
+	}
+	log.Warn().Str("event_id", eventID).Time("due_at", dueAt).Msg("Re-enqueued event missing from the queues")
+	return true, nil
+}
+
+// snapshotQueues returns the queue each queued event ID was found in.
+func snapshotQueues(ctx context.Context, queues queue.Queues) (map[string]string, error) {
+	queued := make(map[string]string)
+
+	ready, err := queues.Scheduled(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range ready {
+		queued[id] = queue.ReadyQueueKey
+	}
+
+	waiting, err := queues.Waiting(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range waiting {
+		queued[id] = queue.WorkerQueueKey
+	}
+
+	leased, err := queues.Leased(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range leased {
+		queued[id] = queue.WorkerLeasesKey
+	}
+	return queued, nil
+}
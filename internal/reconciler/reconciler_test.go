@@ -0,0 +1,231 @@
+package reconciler
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/cankoe/rrule-scheduler/internal/models"
+	"github.com/cankoe/rrule-scheduler/internal/queue"
+	"github.com/cankoe/rrule-scheduler/internal/store/memstore"
+)
+
+// insertEvent stores a pending event that was created an hour ago, well past
+// any grace period used here.
+func insertEvent(t *testing.T, eventStore *memstore.EventStore, runTime time.Time, retryAt *time.Time) *models.Event {
+	t.Helper()
+	event := &models.Event{
+		ScheduleID: "schedule",
+		RunTime:    runTime,
+		RetryAt:    retryAt,
+		Status:     []models.StatusEntry{{Time: time.Now().UTC(), Status: "ready_queue"}},
+		CreatedAt:  time.Now().UTC().Add(-time.Hour),
+	}
+	if _, err := eventStore.Insert(context.Background(), event); err != nil {
+		t.Fatalf("insert event: %v", err)
+	}
+	return event
+}
+
+func lastStatus(t *testing.T, eventStore *memstore.EventStore, eventID string) models.StatusEntry {
+	t.Helper()
+	event, err := eventStore.Get(context.Background(), eventID)
+	if err != nil {
+		t.Fatalf("get event: %v", err)
+	}
+	return event.Status[len(event.Status)-1]
+}
+
+// dueBetween returns the IDs of the ready_queue events due within [from, to],
+// moving them to the worker_queue.
+func dueBetween(t *testing.T, queues *queue.MemoryQueues, from, to time.Time) []string {
+	t.Helper()
+	ctx := context.Background()
+	early, err := queues.DispatchDue(ctx, from.Add(-time.Second), 100)
+	if err != nil {
+		t.Fatalf("dispatch: %v", err)
+	}
+	if len(early) > 0 {
+		t.Fatalf("events %v were due before %v", early, from)
+	}
+	due, err := queues.DispatchDue(ctx, to, 100)
+	if err != nil {
+		t.Fatalf("dispatch: %v", err)
+	}
+	return due
+}
+
+func TestReconcileReenqueuesMissingEvents(t *testing.T) {
+	ctx := context.Background()
+	eventStore := memstore.NewEventStore()
+	queues := queue.NewMemoryQueues()
+
+	runTime := time.Now().UTC().Truncate(time.Second).Add(time.Hour)
+	retryAt := runTime.Add(time.Hour)
+	missing := insertEvent(t, eventStore, runTime, nil)
+	retrying := insertEvent(t, eventStore, runTime.Add(time.Minute), &retryAt)
+	queued := insertEvent(t, eventStore, runTime.Add(2*time.Minute), nil)
+	if err := queues.Schedule(ctx, queue.Item{ID: queued.ID, DueAt: queued.RunTime}); err != nil {
+		t.Fatal(err)
+	}
+	leased := insertEvent(t, eventStore, runTime.Add(3*time.Minute), nil)
+	if err := queues.Push(ctx, leased.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := queues.Claim(ctx, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	// Within the grace period: the prequeuer may not have queued it yet.
+	fresh := &models.Event{ScheduleID: "schedule", RunTime: runTime.Add(4 * time.Minute), CreatedAt: time.Now().UTC()}
+	if _, err := eventStore.Insert(ctx, fresh); err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := Reconcile(ctx, queues, eventStore, time.Minute)
+	if err != nil {
+		t.Fatalf("reconcile: %v", err)
+	}
+	if report != (Report{Scanned: 5, Reenqueued: 2}) {
+		t.Errorf("report = %+v, want 5 scanned and 2 re-enqueued", report)
+	}
+
+	for _, event := range []*models.Event{missing, retrying} {
+		if status := lastStatus(t, eventStore, event.ID); status.Status != "ready_queue" ||
+			status.Message != "Event was missing from the queues and was re-enqueued by the reconciler" {
+			t.Errorf("event %s status = %+v, want re-enqueued", event.ID, status)
+		}
+	}
+	for _, event := range []*models.Event{queued, leased} {
+		if status := lastStatus(t, eventStore, event.ID); status.Message != "" {
+			t.Errorf("event %s got status %+v, want it left alone", event.ID, status)
+		}
+	}
+	if stored, _ := eventStore.Get(ctx, fresh.ID); len(stored.Status) != 0 {
+		t.Errorf("fresh event got status %+v, want it left alone", stored.Status)
+	}
+
+	if due := dueBetween(t, queues, runTime, runTime.Add(2*time.Minute)); !slices.Equal(due, []string{missing.ID, queued.ID}) {
+		t.Errorf("due by its run time: %v, want %v", due, []string{missing.ID, queued.ID})
+	}
+	if due := dueBetween(t, queues, retryAt, retryAt); !slices.Equal(due, []string{retrying.ID}) {
+		t.Errorf("due at the retry time: %v, want %v", due, []string{retrying.ID})
+	}
+}
+
+func TestReconcileRemovesOrphanedQueueEntries(t *testing.T) {
+	ctx := context.Background()
+	eventStore := memstore.NewEventStore()
+	queues := queue.NewMemoryQueues()
+
+	event := insertEvent(t, eventStore, time.Now().UTC().Add(time.Hour), nil)
+	if err := queues.Schedule(ctx, queue.Item{ID: event.ID, DueAt: event.RunTime}, queue.Item{ID: "ready-orphan", DueAt: event.RunTime}); err != nil {
+		t.Fatal(err)
+	}
+	if err := queues.Push(ctx, "waiting-orphan"); err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := Reconcile(ctx, queues, eventStore, time.Minute)
+	if err != nil {
+		t.Fatalf("reconcile: %v", err)
+	}
+	if report != (Report{Scanned: 1, Orphans: 2}) {
+		t.Errorf("report = %+v, want 1 scanned and 2 orphans", report)
+	}
+	if ready, _ := queues.Scheduled(ctx); !slices.Equal(ready, []string{event.ID}) {
+		t.Errorf("ready_queue = %v, want only %s", ready, event.ID)
+	}
+	if waiting, _ := queues.Waiting(ctx); len(waiting) != 0 {
+		t.Errorf("worker_queue = %v, want it empty", waiting)
+	}
+}
+
+// retryingQueues moves a leased event back to the ready_queue right after
+// the reconciler has read the ready_queue, the way a worker scheduling a
+// retry does, so that the first snapshot misses it.
+type retryingQueues struct {
+	*queue.MemoryQueues
+	eventID string
+	retryAt time.Time
+	reads   int
+}
+
+func (q *retryingQueues) Waiting(ctx context.Context) ([]string, error) {
+	q.reads++
+	if q.reads == 1 {
+		if err := q.Reschedule(ctx, queue.Item{ID: q.eventID, DueAt: q.retryAt}); err != nil {
+			return nil, err
+		}
+		if err := q.Ack(ctx, q.eventID); err != nil {
+			return nil, err
+		}
+	}
+	return q.MemoryQueues.Waiting(ctx)
+}
+
+func TestReconcileIgnoresEventsMovingBetweenQueues(t *testing.T) {
+	ctx := context.Background()
+	eventStore := memstore.NewEventStore()
+	runTime := time.Now().UTC().Truncate(time.Second).Add(-time.Minute)
+	event := insertEvent(t, eventStore, runTime, nil)
+
+	queues := &retryingQueues{MemoryQueues: queue.NewMemoryQueues(), eventID: event.ID, retryAt: runTime.Add(time.Hour)}
+	if err := queues.Push(ctx, event.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := queues.Claim(ctx, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := Reconcile(ctx, queues, eventStore, time.Minute)
+	if err != nil {
+		t.Fatalf("reconcile: %v", err)
+	}
+	if report != (Report{Scanned: 1}) {
+		t.Errorf("report = %+v, want 1 scanned and nothing re-enqueued", report)
+	}
+	if status := lastStatus(t, eventStore, event.ID); status.Message != "" {
+		t.Errorf("event got status %+v, want none", status)
+	}
+	if due := dueBetween(t, queues.MemoryQueues, queues.retryAt, queues.retryAt); !slices.Equal(due, []string{event.ID}) {
+		t.Errorf("due at the retry time: %v, want %v", due, []string{event.ID})
+	}
+}
+
+func TestReconcileSkipsEventsArchivedMeanwhile(t *testing.T) {
+	ctx := context.Background()
+	eventStore := memstore.NewEventStore()
+	event := insertEvent(t, eventStore, time.Now().UTC().Add(time.Hour), nil)
+	queues := &archivingQueues{MemoryQueues: queue.NewMemoryQueues(), eventStore: eventStore, eventID: event.ID}
+
+	report, err := Reconcile(ctx, queues, eventStore, time.Minute)
+	if err != nil {
+		t.Fatalf("reconcile: %v", err)
+	}
+	if report != (Report{Scanned: 1}) {
+		t.Errorf("report = %+v, want 1 scanned and nothing re-enqueued", report)
+	}
+	if ready, _ := queues.Scheduled(ctx); len(ready) != 0 {
+		t.Errorf("ready_queue = %v, want the archived event left out", ready)
+	}
+}
+
+// archivingQueues archives the event when the queues are read a second time,
+// i.e. between the reconciler finding it missing and re-enqueueing it.
+type archivingQueues struct {
+	*queue.MemoryQueues
+	eventStore *memstore.EventStore
+	eventID    string
+	reads      int
+}
+
+func (q *archivingQueues) Scheduled(ctx context.Context) ([]string, error) {
+	q.reads++
+	if q.reads == 2 {
+		if err := q.eventStore.Archive(ctx, q.eventID, models.StatusEntry{Status: "completed"}); err != nil {
+			return nil, err
+		}
+	}
+	return q.MemoryQueues.Scheduled(ctx)
+}
@@ -0,0 +1,368 @@
+package recurrence
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/teambition/rrule-go"
+)
+
+// Recurrence is a parsed RFC 5545 recurrence set. It accepts a single RRULE
+// (with or without the "RRULE:" prefix) as well as multi-line definitions made
+// of DTSTART, RRULE, RDATE, EXDATE and EXRULE properties.
+//
+// When the recurrence has a time zone, it is expanded on wall-clock time so
+// that "every day at 09:00" stays at 09:00 local time across DST transitions.
+// Wall-clock times that do not exist (spring forward) run at the end of the
+// gap, and wall-clock times that occur twice (fall back) run once, at their
+// first occurrence.
+type Recurrence struct {
+	set      *rrule.Set
+	exrules  []*rrule.RRule
+	loc      *time.Location
+	excluded map[int64]bool
+}
+
+// LoadLocation resolves an IANA time zone name. An empty name means UTC.
+func LoadLocation(timezone string) (*time.Location, error) {
+	if timezone == "Local" {
+		return nil, errors.New("the server's local time zone cannot be used, specify an IANA name")
+	}
+	return time.LoadLocation(timezone)
+}
+
+// Parse converts an RRULE or recurrence set string into a Recurrence that is
+// expanded in the given IANA time zone. Without a time zone, the zone of a
+// DTSTART;TZID=... line is used, and UTC otherwise.
+//
+// Without a DTSTART line, the recurrence starts at start truncated to the
+// minute, or at the current minute if start is zero. A stable start, such as
+// the schedule's creation time, keeps the occurrences of rules like
+// "FREQ=HOURLY" the same every time the rule is parsed, and truncating it
+// makes rules like "FREQ=DAILY;BYHOUR=9;BYMINUTE=0" run on the minute.
+func Parse(ruleStr, timezone string, start time.Time) (*Recurrence, error) {
+	loc, err := LoadLocation(timezone)
+	if err != nil {
+		return nil, fmt.Errorf("invalid time zone: %w", err)
+	}
+
+	var dtstart string
+	var lines, exrules []string
+	rrules := 0
+
+	for _, line := range strings.Split(strings.TrimSpace(ruleStr), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		name := propertyName(line)
+		if name == "" {
+			// A bare rule such as "FREQ=DAILY;INTERVAL=1"
+			line, name = "RRULE:"+line, "RRULE"
+		}
+		switch name {
+		case "DTSTART":
+			if dtstart != "" {
+				return nil, errors.New("only one DTSTART line is allowed")
+			}
+			dtstart = line
+		case "RRULE":
+			rrules++
+			lines = append(lines, line)
+		case "RDATE", "EXDATE":
+			lines = append(lines, line)
+		case "EXRULE":
+			exrules = append(exrules, line[len(name)+1:])
+		default:
+			return nil, fmt.Errorf("unsupported recurrence property %q", name)
+		}
+	}
+	if rrules > 1 {
+		return nil, errors.New("only one RRULE line is supported")
+	}
+	if rrules == 0 && !hasProperty(lines, "RDATE") {
+		return nil, errors.New("recurrence must contain an RRULE or RDATE")
+	}
+
+	// rrule-go would start the recurrence at the current second, which is
+	// carried over to every occurrence whose rule has no BYSECOND.
+	if dtstart == "" {
+		if start.IsZero() {
+			start = time.Now()
+		}
+		dtstart = "DTSTART:" + start.UTC().Truncate(time.Minute).Format("20060102T150405Z")
+	}
+	// DTSTART has to come first so that it applies to every other line.
+	lines = append([]string{dtstart}, lines...)
+	set, err := rrule.StrSliceToRRuleSetInLoc(lines, loc)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &Recurrence{set: set}
+	start = set.GetDTStart()
+	if set.GetRRule() != nil {
+		start = set.GetRRule().GetDTStart()
+	}
+	if timezone == "" && !start.IsZero() {
+		loc = start.Location()
+	}
+	if loc != time.UTC {
+		r.loc = loc
+		r.floatSet(start)
+	}
+
+	for _, exrule := range exrules {
+		opt, err := rrule.StrToROptionInLocation(exrule, loc)
+		if err != nil {
+			return nil, fmt.Errorf("invalid EXRULE: %w", err)
+		}
+		if opt.Dtstart.IsZero() {
+			opt.Dtstart = start
+		}
+		if r.loc != nil {
+			opt.Dtstart = r.floating(opt.Dtstart)
+			if !opt.Until.IsZero() {
+				opt.Until = r.floating(opt.Until)
+			}
+		}
+		rule, err := rrule.NewRRule(*opt)
+		if err != nil {
+			return nil, fmt.Errorf("invalid EXRULE: %w", err)
+		}
+		r.exrules = append(r.exrules, rule)
+	}
+	return r, nil
+}
+
+// Rebase starts the recurrence at the given occurrence instead of at its
+// DTSTART, so that iterating it no longer walks through every occurrence
+// since then: a minutely schedule created a year ago would otherwise go
+// through half a million occurrences on every expansion. Occurrences from at
+// on stay the same, as a rule started at one of its own occurrences keeps
+// its period and the values DTSTART fills in.
+//
+// at has to be an occurrence of the RRULE, such as a schedule's
+// next_run_time. The recurrence is left as is, and false is returned, if the
+// RRULE has a COUNT (it would start counting again), if there are EXRULEs
+// (they are anchored at the original start), if at is an RDATE, or if at is
+// the end of a DST gap (it may stand for a skipped wall-clock time).
+func (r *Recurrence) Rebase(at time.Time) bool {
+	rule := r.set.GetRRule()
+	if rule == nil || rule.OrigOptions.Count != 0 || len(r.exrules) > 0 {
+		return false
+	}
+	wall := at.UTC()
+	if r.loc != nil {
+		if start, _ := at.In(r.loc).ZoneBounds(); at.Equal(start) {
+			return false
+		}
+		wall = r.floating(at)
+	}
+	if !wall.After(rule.GetDTStart()) {
+		return false
+	}
+	for _, rdate := range r.set.GetRDate() {
+		if rdate.Equal(wall) {
+			return false
+		}
+	}
+	r.set.DTStart(wall)
+	return true
+}
+
+// floatSet moves every date of the set onto floating wall-clock time, i.e.
+// the local date and time of r.loc carried in a UTC time.Time.
+func (r *Recurrence) floatSet(start time.Time) {
+	if rule := r.set.GetRRule(); rule != nil && !rule.OrigOptions.Until.IsZero() {
+		rule.Until(r.floating(rule.OrigOptions.Until))
+	}
+	if !start.IsZero() {
+		r.set.DTStart(r.floating(start))
+	}
+	rdates := r.set.GetRDate()
+	for i := range rdates {
+		rdates[i] = r.floating(rdates[i])
+	}
+	r.set.SetRDates(rdates)
+	exdates := r.set.GetExDate()
+	for i := range exdates {
+		exdates[i] = r.floating(exdates[i])
+	}
+	r.set.SetExDates(exdates)
+}
+
+// floating returns the wall-clock time of t in r.loc, carried in UTC.
+func (r *Recurrence) floating(t time.Time) time.Time {
+	local := t.In(r.loc)
+	return time.Date(local.Year(), local.Month(), local.Day(),
+		local.Hour(), local.Minute(), local.Second(), local.Nanosecond(), time.UTC)
+}
+
+// resolve maps a floating wall-clock time back to an instant in r.loc.
+func (r *Recurrence) resolve(wall time.Time) time.Time {
+	// DST transitions are never closer together than a couple of days, so the
+	// offsets a day before and after cover both sides of any transition.
+	_, earlyOffset := wall.Add(-24 * time.Hour).In(r.loc).Zone()
+	_, lateOffset := wall.Add(24 * time.Hour).In(r.loc).Zone()
+	early := wall.Add(-time.Duration(earlyOffset) * time.Second)
+	late := wall.Add(-time.Duration(lateOffset) * time.Second)
+
+	earlyValid := r.floating(early).Equal(wall)
+	lateValid := r.floating(late).Equal(wall)
+	switch {
+	case earlyValid && lateValid:
+		// Repeated wall-clock time: run at the first one.
+		if late.Before(early) {
+			return late.In(r.loc)
+		}
+		return early.In(r.loc)
+	case earlyValid:
+		return early.In(r.loc)
+	case lateValid:
+		return late.In(r.loc)
+	}
+
+	// Skipped wall-clock time: run when the gap ends.
+	candidate := early
+	if late.Before(early) {
+		candidate = late
+	}
+	_, gapEnd := candidate.In(r.loc).ZoneBounds()
+	return gapEnd.In(r.loc)
+}
+
+// Exclude leaves the given run times out of the occurrences, e.g. run times
+// that were skipped through the API. Unlike EXDATE, they are matched against
+// the run times after the DST policy was applied.
+func (r *Recurrence) Exclude(times ...time.Time) {
+	if r.excluded == nil {
+		r.excluded = make(map[int64]bool, len(times))
+	}
+	for _, t := range times {
+		r.excluded[t.Unix()] = true
+	}
+}
+
+// Iterator returns the occurrences of the set in chronological order, with
+// every date matched by an EXRULE or passed to Exclude left out.
+func (r *Recurrence) Iterator() rrule.Next {
+	type exclusion struct {
+		dt   time.Time
+		ok   bool
+		next rrule.Next
+	}
+	exclusions := make([]*exclusion, 0, len(r.exrules))
+	for _, rule := range r.exrules {
+		ex := &exclusion{next: rule.Iterator()}
+		ex.dt, ex.ok = ex.next()
+		exclusions = append(exclusions, ex)
+	}
+
+	next := r.set.Iterator()
+	var last time.Time
+	return func() (time.Time, bool) {
+	occurrences:
+		for dt, ok := next(); ok; dt, ok = next() {
+			for _, ex := range exclusions {
+				for ex.ok && ex.dt.Before(dt) {
+					ex.dt, ex.ok = ex.next()
+				}
+				if ex.ok && ex.dt.Equal(dt) {
+					continue occurrences
+				}
+			}
+			if r.loc != nil {
+				dt = r.resolve(dt)
+				// Several skipped wall-clock times can end up on the same instant.
+				if dt.Equal(last) {
+					continue
+				}
+				last = dt
+			}
+			if r.excluded[dt.Unix()] {
+				continue
+			}
+			return dt, true
+		}
+		return time.Time{}, false
+	}
+}
+
+// Between returns the occurrences between after and before. With inc set,
+// occurrences equal to either bound are included.
+func (r *Recurrence) Between(after, before time.Time, inc bool) []time.Time {
+	result := []time.Time{}
+	next := r.Iterator()
+	for dt, ok := next(); ok; dt, ok = next() {
+		if inc && dt.After(before) || !inc && !dt.Before(before) {
+			break
+		}
+		if inc && !dt.Before(after) || !inc && dt.After(after) {
+			result = append(result, dt)
+		}
+	}
+	return result
+}
+
+// After returns the first occurrence after t (or equal to t, with inc set).
+// The second result is false when the recurrence has no such occurrence.
+func (r *Recurrence) After(t time.Time, inc bool) (time.Time, bool) {
+	next := r.Iterator()
+	for dt, ok := next(); ok; dt, ok = next() {
+		if dt.After(t) || inc && dt.Equal(t) {
+			return dt, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// Occurrences expands the set and returns at most limit run times that fall
+// in [from, to]. A zero `to` means the expansion is only bounded by limit.
+// Run times are returned in the recurrence's time zone.
+func (r *Recurrence) Occurrences(from, to time.Time, limit int) []time.Time {
+	occurrences := []time.Time{}
+	if limit <= 0 {
+		return occurrences
+	}
+
+	next := r.Iterator()
+	for dt, ok := next(); ok; dt, ok = next() {
+		if dt.Before(from) {
+			continue
+		}
+		if !to.IsZero() && dt.After(to) {
+			break
+		}
+		occurrences = append(occurrences, dt)
+		if len(occurrences) >= limit {
+			break
+		}
+	}
+	return occurrences
+}
+
+// propertyName returns the upper-cased property name of a content line, or an
+// empty string when the line is a bare rule without a property name.
+func propertyName(line string) string {
+	end := strings.IndexAny(line, ";:")
+	if end <= 0 {
+		return ""
+	}
+	name := strings.ToUpper(line[:end])
+	if strings.Contains(name, "=") {
+		return ""
+	}
+	return name
+}
+
+func hasProperty(lines []string, name string) bool {
+	for _, line := range lines {
+		if propertyName(line) == name {
+			return true
+		}
+	}
+	return false
+}
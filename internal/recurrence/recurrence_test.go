@@ -0,0 +1,426 @@
+package recurrence
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseWithoutDTStartRunsOnTheMinute(t *testing.T) {
+	for _, timezone := range []string{"", "Europe/Berlin", "America/New_York"} {
+		rule, err := Parse("FREQ=DAILY;BYHOUR=9;BYMINUTE=0", timezone, time.Time{})
+		if err != nil {
+			t.Fatalf("Parse(%q): %v", timezone, err)
+		}
+		occurrences := rule.Occurrences(time.Now(), time.Time{}, 3)
+		if len(occurrences) != 3 {
+			t.Fatalf("timezone %q: got %d occurrences, want 3", timezone, len(occurrences))
+		}
+		for _, dt := range occurrences {
+			if dt.Hour() != 9 || dt.Minute() != 0 || dt.Second() != 0 || dt.Nanosecond() != 0 {
+				t.Errorf("timezone %q: occurrence %s is not at 09:00:00", timezone, dt)
+			}
+		}
+	}
+}
+
+func TestParseWithoutDTStartStartsAtStart(t *testing.T) {
+	start := time.Date(2026, 3, 1, 10, 17, 42, 0, time.UTC)
+	want := []time.Time{
+		time.Date(2026, 3, 1, 10, 17, 0, 0, time.UTC),
+		time.Date(2026, 3, 1, 17, 17, 0, 0, time.UTC),
+		time.Date(2026, 3, 2, 0, 17, 0, 0, time.UTC),
+	}
+	for i := 0; i < 2; i++ {
+		rule, err := Parse("FREQ=HOURLY;INTERVAL=7", "Europe/Berlin", start)
+		if err != nil {
+			t.Fatalf("Parse: %v", err)
+		}
+		got := rule.Occurrences(start.Add(-time.Hour), time.Time{}, len(want))
+		if len(got) != len(want) {
+			t.Fatalf("got %d occurrences, want %d", len(got), len(want))
+		}
+		for j := range want {
+			if !got[j].Equal(want[j]) {
+				t.Errorf("occurrence %d: got %s, want %s", j, got[j], want[j])
+			}
+		}
+	}
+}
+
+func utc(s string) time.Time {
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		panic(err)
+	}
+	return t.UTC()
+}
+
+func TestParseRecurrenceSets(t *testing.T) {
+	tests := []struct {
+		name     string
+		rule     string
+		timezone string
+		want     []string
+	}{
+		{
+			name: "RDATE in UTC",
+			rule: "DTSTART:20250101T090000Z\nRRULE:FREQ=DAILY;COUNT=2\nRDATE:20250110T120000Z",
+			want: []string{"2025-01-01T09:00:00Z", "2025-01-02T09:00:00Z", "2025-01-10T12:00:00Z"},
+		},
+		{
+			name: "RDATE only",
+			rule: "RDATE:20250110T120000Z,20250111T120000Z",
+			want: []string{"2025-01-10T12:00:00Z", "2025-01-11T12:00:00Z"},
+		},
+		{
+			name: "RDATE with TZID",
+			rule: "DTSTART:20250101T090000Z\nRRULE:FREQ=DAILY;COUNT=1\nRDATE;TZID=Europe/Berlin:20250110T120000",
+			want: []string{"2025-01-01T09:00:00Z", "2025-01-10T11:00:00Z"},
+		},
+		{
+			name:     "RDATE in the schedule's time zone",
+			rule:     "DTSTART:20250101T090000\nRRULE:FREQ=DAILY;COUNT=1\nRDATE:20250710T120000",
+			timezone: "Europe/Berlin",
+			want:     []string{"2025-01-01T08:00:00Z", "2025-07-10T10:00:00Z"},
+		},
+		{
+			name: "EXDATE removes an occurrence",
+			rule: "DTSTART:20250101T090000Z\nRRULE:FREQ=DAILY;COUNT=3\nEXDATE:20250102T090000Z",
+			want: []string{"2025-01-01T09:00:00Z", "2025-01-03T09:00:00Z"},
+		},
+		{
+			name: "EXRULE removes the weekend",
+			rule: "DTSTART:20250101T090000Z\nRRULE:FREQ=DAILY;COUNT=7\nEXRULE:FREQ=WEEKLY;BYDAY=SA,SU",
+			want: []string{
+				"2025-01-01T09:00:00Z", "2025-01-02T09:00:00Z", "2025-01-03T09:00:00Z",
+				"2025-01-06T09:00:00Z", "2025-01-07T09:00:00Z",
+			},
+		},
+		{
+			name: "bare rule with lower-case property names",
+			rule: "dtstart:20250101T090000Z\nrrule:FREQ=HOURLY;COUNT=2",
+			want: []string{"2025-01-01T09:00:00Z", "2025-01-01T10:00:00Z"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule, err := Parse(tt.rule, tt.timezone, time.Time{})
+			if err != nil {
+				t.Fatalf("Parse: %v", err)
+			}
+			got := rule.Occurrences(utc("2024-12-01T00:00:00Z"), time.Time{}, 100)
+			if len(got) != len(tt.want) {
+				t.Fatalf("got occurrences %v, want %v", got, tt.want)
+			}
+			for i, want := range tt.want {
+				if !got[i].Equal(utc(want)) {
+					t.Errorf("occurrence %d: got %s, want %s", i, got[i].UTC().Format(time.RFC3339), want)
+				}
+			}
+		})
+	}
+}
+
+func TestExclude(t *testing.T) {
+	rule, err := Parse("DTSTART:20250101T090000Z\nRRULE:FREQ=DAILY;COUNT=3", "", time.Time{})
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	rule.Exclude(utc("2025-01-02T09:00:00Z"), utc("2025-02-01T09:00:00Z"))
+
+	got := rule.Between(utc("2025-01-01T00:00:00Z"), utc("2025-01-31T00:00:00Z"), false)
+	want := []time.Time{utc("2025-01-01T09:00:00Z"), utc("2025-01-03T09:00:00Z")}
+	if len(got) != len(want) {
+		t.Fatalf("got occurrences %v, want %v", got, want)
+	}
+	for i := range want {
+		if !got[i].Equal(want[i]) {
+			t.Errorf("occurrence %d: got %s, want %s", i, got[i], want[i])
+		}
+	}
+	if next, ok := rule.After(utc("2025-01-01T09:00:00Z"), false); !ok || !next.Equal(want[1]) {
+		t.Errorf("got next occurrence %s, want %s", next, want[1])
+	}
+}
+
+func TestParseRejectsMalformedRules(t *testing.T) {
+	tests := []struct {
+		name     string
+		rule     string
+		timezone string
+	}{
+		{name: "unknown property", rule: "FOO:BAR"},
+		{name: "two RRULEs", rule: "RRULE:FREQ=DAILY\nRRULE:FREQ=WEEKLY"},
+		{name: "two DTSTARTs", rule: "DTSTART:20250101T090000Z\nDTSTART:20250102T090000Z\nRRULE:FREQ=DAILY"},
+		{name: "no RRULE or RDATE", rule: "DTSTART:20250101T090000Z\nEXDATE:20250102T090000Z"},
+		{name: "empty", rule: "  \n "},
+		{name: "invalid frequency", rule: "FREQ=SOMETIMES"},
+		{name: "invalid RDATE", rule: "RDATE:tomorrow"},
+		{name: "invalid EXDATE", rule: "RRULE:FREQ=DAILY\nEXDATE:yesterday"},
+		{name: "invalid EXRULE", rule: "RRULE:FREQ=DAILY\nEXRULE:FREQ=NEVER"},
+		{name: "invalid time zone", rule: "FREQ=DAILY", timezone: "Mars/Olympus_Mons"},
+		{name: "server time zone", rule: "FREQ=DAILY", timezone: "Local"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Parse(tt.rule, tt.timezone, time.Time{}); err == nil {
+				t.Errorf("Parse(%q, %q) succeeded, want an error", tt.rule, tt.timezone)
+			}
+		})
+	}
+}
+
+func TestDSTTransitions(t *testing.T) {
+	tests := []struct {
+		name     string
+		rule     string
+		timezone string
+		from     string
+		want     []string
+	}{
+		{
+			// 02:30 does not exist on March 30th and runs at 03:00 CEST
+			name:     "Berlin spring forward",
+			rule:     "DTSTART:20250328T023000\nRRULE:FREQ=DAILY;COUNT=4",
+			timezone: "Europe/Berlin",
+			from:     "2025-03-28T00:00:00Z",
+			want:     []string{"2025-03-28T01:30:00Z", "2025-03-29T01:30:00Z", "2025-03-30T01:00:00Z", "2025-03-31T00:30:00Z"},
+		},
+		{
+			// 02:30 happens twice on October 26th and runs at the first one
+			name:     "Berlin fall back",
+			rule:     "DTSTART:20251025T023000\nRRULE:FREQ=DAILY;COUNT=3",
+			timezone: "Europe/Berlin",
+			from:     "2025-10-25T00:00:00Z",
+			want:     []string{"2025-10-25T00:30:00Z", "2025-10-26T00:30:00Z", "2025-10-27T01:30:00Z"},
+		},
+		{
+			name:     "Berlin BYHOUR and BYMINUTE across both transitions",
+			rule:     "DTSTART:20250101T000000Z\nRRULE:FREQ=DAILY;BYHOUR=2;BYMINUTE=30",
+			timezone: "Europe/Berlin",
+			from:     "2025-03-29T00:00:00Z",
+			want:     []string{"2025-03-29T01:30:00Z", "2025-03-30T01:00:00Z", "2025-03-31T00:30:00Z"},
+		},
+		{
+			name:     "Berlin BYHOUR and BYMINUTE on fall back",
+			rule:     "DTSTART:20250101T000000Z\nRRULE:FREQ=DAILY;BYHOUR=2;BYMINUTE=30",
+			timezone: "Europe/Berlin",
+			from:     "2025-10-25T00:00:00Z",
+			want:     []string{"2025-10-25T00:30:00Z", "2025-10-26T00:30:00Z", "2025-10-27T01:30:00Z"},
+		},
+		{
+			// Hourly runs follow the wall clock: the repeated 02:00 runs once
+			name:     "Berlin hourly on fall back",
+			rule:     "DTSTART:20251026T010000\nRRULE:FREQ=HOURLY;COUNT=3",
+			timezone: "Europe/Berlin",
+			from:     "2025-10-25T00:00:00Z",
+			want:     []string{"2025-10-25T23:00:00Z", "2025-10-26T00:00:00Z", "2025-10-26T02:00:00Z"},
+		},
+		{
+			name:     "New York spring forward",
+			rule:     "DTSTART:20250308T023000\nRRULE:FREQ=DAILY;COUNT=3",
+			timezone: "America/New_York",
+			from:     "2025-03-08T00:00:00Z",
+			want:     []string{"2025-03-08T07:30:00Z", "2025-03-09T07:00:00Z", "2025-03-10T06:30:00Z"},
+		},
+		{
+			name:     "New York fall back",
+			rule:     "DTSTART:20251101T013000\nRRULE:FREQ=DAILY;COUNT=3",
+			timezone: "America/New_York",
+			from:     "2025-11-01T00:00:00Z",
+			want:     []string{"2025-11-01T05:30:00Z", "2025-11-02T05:30:00Z", "2025-11-03T06:30:00Z"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule, err := Parse(tt.rule, tt.timezone, time.Time{})
+			if err != nil {
+				t.Fatalf("Parse: %v", err)
+			}
+			got := rule.Occurrences(utc(tt.from), time.Time{}, len(tt.want))
+			if len(got) != len(tt.want) {
+				t.Fatalf("got occurrences %v, want %v", got, tt.want)
+			}
+			for i, want := range tt.want {
+				if !got[i].Equal(utc(want)) {
+					t.Errorf("occurrence %d: got %s, want %s", i, got[i].UTC().Format(time.RFC3339), want)
+				}
+				if got[i].Location().String() != tt.timezone {
+					t.Errorf("occurrence %d is in %s, want %s", i, got[i].Location(), tt.timezone)
+				}
+			}
+		})
+	}
+}
+
+func TestBetweenAndAfterAcrossDSTTransitions(t *testing.T) {
+	rule, err := Parse("DTSTART:20250101T000000Z\nRRULE:FREQ=DAILY;BYHOUR=2;BYMINUTE=30", "Europe/Berlin", time.Time{})
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+
+	tests := []struct {
+		name          string
+		after, before string
+		inc           bool
+		want          []string
+	}{
+		{
+			name:  "spring forward",
+			after: "2025-03-29T01:30:00Z", before: "2025-03-31T00:30:00Z",
+			want: []string{"2025-03-30T01:00:00Z"},
+		},
+		{
+			name:  "spring forward inclusive",
+			after: "2025-03-29T01:30:00Z", before: "2025-03-31T00:30:00Z", inc: true,
+			want: []string{"2025-03-29T01:30:00Z", "2025-03-30T01:00:00Z", "2025-03-31T00:30:00Z"},
+		},
+		{
+			// The second 02:30 of October 26th (01:30 UTC) is not an occurrence
+			name:  "fall back",
+			after: "2025-10-26T00:00:00Z", before: "2025-10-27T00:00:00Z",
+			want: []string{"2025-10-26T00:30:00Z"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := rule.Between(utc(tt.after), utc(tt.before), tt.inc)
+			if len(got) != len(tt.want) {
+				t.Fatalf("got occurrences %v, want %v", got, tt.want)
+			}
+			for i, want := range tt.want {
+				if !got[i].Equal(utc(want)) {
+					t.Errorf("occurrence %d: got %s, want %s", i, got[i].UTC().Format(time.RFC3339), want)
+				}
+			}
+		})
+	}
+
+	afterTests := []struct {
+		t    string
+		inc  bool
+		want string
+	}{
+		{t: "2025-03-29T01:30:00Z", want: "2025-03-30T01:00:00Z"},
+		{t: "2025-03-30T01:00:00Z", inc: true, want: "2025-03-30T01:00:00Z"},
+		{t: "2025-03-30T01:00:00Z", want: "2025-03-31T00:30:00Z"},
+		{t: "2025-10-26T00:30:00Z", want: "2025-10-27T01:30:00Z"},
+		{t: "2025-10-26T01:00:00Z", want: "2025-10-27T01:30:00Z"},
+	}
+	for _, tt := range afterTests {
+		got, ok := rule.After(utc(tt.t), tt.inc)
+		if !ok || !got.Equal(utc(tt.want)) {
+			t.Errorf("After(%s, %v) = %s, want %s", tt.t, tt.inc, got.UTC().Format(time.RFC3339), tt.want)
+		}
+	}
+}
+
+func TestRebaseKeepsOccurrences(t *testing.T) {
+	start := utc("2023-01-15T10:17:00Z")
+	tests := []struct {
+		rule     string
+		timezone string
+	}{
+		{rule: "FREQ=MINUTELY"},
+		{rule: "FREQ=MINUTELY;INTERVAL=7;BYHOUR=9,10"},
+		{rule: "FREQ=SECONDLY;INTERVAL=3601"},
+		{rule: "FREQ=HOURLY;INTERVAL=5"},
+		{rule: "FREQ=HOURLY;INTERVAL=5", timezone: "Europe/Berlin"},
+		{rule: "FREQ=DAILY"},
+		{rule: "FREQ=DAILY;BYHOUR=2;BYMINUTE=30", timezone: "Europe/Berlin"},
+		{rule: "FREQ=DAILY;BYHOUR=1;BYMINUTE=30", timezone: "America/New_York"},
+		{rule: "FREQ=WEEKLY;INTERVAL=2;BYDAY=MO,FR"},
+		{rule: "FREQ=WEEKLY;INTERVAL=3;WKST=SU;BYDAY=SU,SA"},
+		{rule: "FREQ=MONTHLY"},
+		{rule: "FREQ=MONTHLY;INTERVAL=5;BYDAY=-1FR"},
+		{rule: "FREQ=MONTHLY;BYDAY=MO,TU,WE,TH,FR;BYSETPOS=-1"},
+		{rule: "FREQ=YEARLY;BYWEEKNO=20;BYDAY=WE"},
+		{rule: "FREQ=DAILY;UNTIL=20250101T000000Z"},
+		{rule: "DTSTART;TZID=Europe/Berlin:20220301T083000\nRRULE:FREQ=WEEKLY;INTERVAL=2;BYDAY=TU,TH\nEXDATE;TZID=Europe/Berlin:20240305T083000"},
+		{rule: "RRULE:FREQ=DAILY;INTERVAL=3\nRDATE:20240101T000000Z"},
+	}
+	for _, tt := range tests {
+		original, err := Parse(tt.rule, tt.timezone, start)
+		if err != nil {
+			t.Fatalf("Parse(%q): %v", tt.rule, err)
+		}
+		want := original.Occurrences(start, time.Time{}, 3000)
+		for _, anchor := range []int{1, 17, 1000, 2500} {
+			if anchor >= len(want) {
+				continue
+			}
+			rebased, _ := Parse(tt.rule, tt.timezone, start)
+			if !rebased.Rebase(want[anchor]) {
+				t.Errorf("%q: Rebase(%s) = false, want true", tt.rule, want[anchor])
+				continue
+			}
+			got := rebased.Occurrences(want[anchor], time.Time{}, len(want)-anchor)
+			if len(got) != len(want)-anchor {
+				t.Errorf("%q rebased at %s: got %d occurrences, want %d", tt.rule, want[anchor], len(got), len(want)-anchor)
+				continue
+			}
+			for i := range got {
+				if !got[i].Equal(want[anchor+i]) {
+					t.Errorf("%q rebased at %s: occurrence %d is %s, want %s", tt.rule, want[anchor], i, got[i], want[anchor+i])
+					break
+				}
+			}
+		}
+	}
+}
+
+func TestRebaseSkipsRulesItCannotMove(t *testing.T) {
+	start := utc("2024-01-01T00:00:00Z")
+	tests := []struct {
+		name     string
+		rule     string
+		timezone string
+		at       time.Time
+	}{
+		{name: "count", rule: "FREQ=DAILY;COUNT=100", at: utc("2024-02-01T00:00:00Z")},
+		{name: "exrule", rule: "RRULE:FREQ=DAILY\nEXRULE:FREQ=WEEKLY", at: utc("2024-02-01T00:00:00Z")},
+		{name: "rdate", rule: "RRULE:FREQ=DAILY;BYHOUR=9\nRDATE:20240201T120000Z", at: utc("2024-02-01T12:00:00Z")},
+		{name: "rdate only", rule: "RDATE:20240201T120000Z,20240301T120000Z", at: utc("2024-02-01T12:00:00Z")},
+		{name: "dst gap end", rule: "FREQ=DAILY;BYHOUR=2;BYMINUTE=30", timezone: "Europe/Berlin", at: utc("2024-03-31T01:00:00Z")},
+		{name: "at the start", rule: "FREQ=DAILY", at: start},
+		{name: "before the start", rule: "FREQ=DAILY", at: start.AddDate(0, 0, -1)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule, err := Parse(tt.rule, tt.timezone, start)
+			if err != nil {
+				t.Fatalf("Parse: %v", err)
+			}
+			want := rule.Occurrences(start.AddDate(0, 0, -1), time.Time{}, 3)
+			if rule.Rebase(tt.at) {
+				t.Fatalf("Rebase(%s) = true, want false", tt.at)
+			}
+			got := rule.Occurrences(start.AddDate(0, 0, -1), time.Time{}, 3)
+			if len(got) != len(want) || len(got) > 0 && !got[0].Equal(want[0]) {
+				t.Errorf("occurrences changed to %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestRebaseStartsIterationAtTheOccurrence(t *testing.T) {
+	// A minutely rule started ten years ago: iterating it from its start
+	// takes several seconds.
+	rule, err := Parse("FREQ=MINUTELY", "Europe/Berlin", time.Now().AddDate(-10, 0, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	at := time.Now().UTC().Truncate(time.Minute)
+	if !rule.Rebase(at) {
+		t.Fatalf("Rebase(%s) = false, want true", at)
+	}
+
+	deadline := time.Now().Add(time.Second)
+	next, ok := rule.After(at.Add(time.Hour), false)
+	if !ok || !next.Equal(at.Add(time.Hour+time.Minute)) {
+		t.Errorf("After = %s, %v; want %s", next, ok, at.Add(time.Hour+time.Minute))
+	}
+	if time.Now().After(deadline) {
+		t.Errorf("After took more than a second")
+	}
+}
@@ -0,0 +1,253 @@
+// Package roles runs the parts of the scheduler: the API, PreQueuer,
+// Dispatcher, Worker and Reconciler. Each has its own binary under cmd/, and
+// cmd/scheduler runs any subset of them in one process on shared clients.
+package roles
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/cankoe/rrule-scheduler/internal/api"
+	"github.com/cankoe/rrule-scheduler/internal/dispatcher"
+	"github.com/cankoe/rrule-scheduler/internal/helpers"
+	"github.com/cankoe/rrule-scheduler/internal/leader"
+	"github.com/cankoe/rrule-scheduler/internal/models"
+	"github.com/cankoe/rrule-scheduler/internal/prequeuer"
+	"github.com/cankoe/rrule-scheduler/internal/reconciler"
+	"github.com/cankoe/rrule-scheduler/internal/worker"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+)
+
+// Role starts a part of the scheduler. Its goroutines are tracked by wg and
+// stop once ctx is cancelled; an error means the role could not start.
+type Role func(ctx context.Context, wg *sync.WaitGroup, components *helpers.AppComponents) error
+
+// Names lists the roles in the order they are started.
+var Names = []string{"prequeuer", "dispatcher", "worker", "reconciler", "api"}
+
+// ByName maps the role names to the roles.
+var ByName = map[string]Role{
+	"api":        API,
+	"prequeuer":  PreQueuer,
+	"dispatcher": Dispatcher,
+	"worker":     Worker,
+	"reconciler": Reconciler,
+}
+
+// API serves the HTTP API on port 8080 and shuts the server down gracefully.
+// The port is bound before API returns, so a port in use fails the start.
+func API(ctx context.Context, wg *sync.WaitGroup, components *helpers.AppComponents) error {
+	r := gin.Default()
+	api.RegisterRoutes(r, components.Schedules, components.Events, components.Queues)
+
+	srv := &http.Server{
+		Addr:    ":8080",
+		Handler: r,
+	}
+	listener, err := net.Listen("tcp", srv.Addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", srv.Addr, err)
+	}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		log.Info().Msg("API server started on port 8080")
+		if err := srv.Serve(listener); !errors.Is(err, http.ErrServerClosed) {
+			log.Error().Err(err).Msg("API server stopped")
+		}
+	}()
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		<-ctx.Done()
+
+		shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer shutdownCancel()
+
+		log.Info().Msg("Shutting down API server...")
+		if err := srv.Shutdown(shutdownCtx); err != nil {
+			log.Error().Err(err).Msg("API server forced to shutdown")
+		}
+	}()
+	return nil
+}
+
+// PreQueuer generates the upcoming events of the schedules on every tick.
+func PreQueuer(ctx context.Context, wg *sync.WaitGroup, components *helpers.AppComponents) error {
+	cfg := components.Config
+	if err := components.Events.EnsureIndexes(ctx); err != nil {
+		return fmt.Errorf("failed to create necessary indexes: %w", err)
+	}
+	if err := components.Schedules.EnsureIndexes(ctx); err != nil {
+		return fmt.Errorf("failed to create necessary indexes: %w", err)
+	}
+
+	tickerInterval := time.Duration(cfg.PreQueuer.TickerIntervalSeconds) * time.Second
+	eventTimeframe := time.Duration(cfg.PreQueuer.EventTimeframeMinutes) * time.Minute
+	batchSize := cfg.PreQueuer.BatchSize
+	maxMisfiredRuns := cfg.PreQueuer.MaxMisfiredRuns
+
+	elector := startElector(ctx, wg, components, "prequeuer")
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(tickerInterval)
+		defer ticker.Stop()
+
+		log.Info().Msg("Prequeuer started. Generating events...")
+		for {
+			select {
+			case <-ctx.Done():
+				log.Info().Msg("Prequeuer is shutting down...")
+				return
+			case <-ticker.C:
+				if !elector.IsLeader() {
+					log.Debug().Msg("Not the leader, skipping tick")
+					continue
+				}
+				prequeuer.GenerateEvents(ctx, components.Schedules, components.Events, components.Queues,
+					eventTimeframe, batchSize, maxMisfiredRuns)
+			}
+		}
+	}()
+	return nil
+}
+
+// Dispatcher moves due events to the worker_queue every second.
+func Dispatcher(ctx context.Context, wg *sync.WaitGroup, components *helpers.AppComponents) error {
+	batchSize := components.Config.Dispatcher.BatchSize
+
+	elector := startElector(ctx, wg, components, "dispatcher")
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(1 * time.Second)
+		defer ticker.Stop()
+
+		log.Info().Msg("Dispatcher started.")
+		for {
+			select {
+			case <-ctx.Done():
+				log.Info().Msg("Dispatcher is shutting down...")
+				return
+			case <-ticker.C:
+				if !elector.IsLeader() {
+					log.Debug().Msg("Not the leader, skipping tick")
+					continue
+				}
+				dispatcher.DispatchDueEvents(ctx, components.Queues, components.Events, batchSize)
+			}
+		}
+	}()
+	return nil
+}
+
+// Worker runs the worker goroutines, which perform the callbacks, and the
+// LeaseReaper.
+func Worker(ctx context.Context, wg *sync.WaitGroup, components *helpers.AppComponents) error {
+	if err := components.Events.EnsureIndexes(ctx); err != nil {
+		return fmt.Errorf("failed to create necessary indexes: %w", err)
+	}
+
+	workerCfg := components.Config.Worker
+	retryDefaults := models.RetryPolicy{
+		MaxAttempts:      workerCfg.MaxRetries,
+		BaseDelaySeconds: workerCfg.RetryBaseDelaySeconds,
+		MaxDelaySeconds:  workerCfg.RetryMaxDelaySeconds,
+	}
+
+	workerCount := workerCfg.Count
+	log.Info().Int("workers", workerCount).Msg("Spawning worker goroutines")
+
+	visibilityTimeout := time.Duration(workerCfg.VisibilityTimeoutSeconds) * time.Second
+
+	for i := 0; i < workerCount; i++ {
+		wg.Add(1)
+		go worker.EventWorker(ctx, wg, components.Queues, components.Locker,
+			components.Schedules, components.Events, i+1, retryDefaults, visibilityTimeout)
+	}
+
+	// Re-enqueue events held by workers that died without releasing them
+	wg.Add(1)
+	go worker.LeaseReaper(ctx, wg, components.Queues, components.Events, visibilityTimeout/2)
+	return nil
+}
+
+// Reconciler repairs drift between the pending events and the queues on
+// every tick.
+func Reconciler(ctx context.Context, wg *sync.WaitGroup, components *helpers.AppComponents) error {
+	cfg := components.Config
+
+	interval := time.Duration(cfg.Reconciler.IntervalSeconds) * time.Second
+	gracePeriod := time.Duration(cfg.Reconciler.GracePeriodSeconds) * time.Second
+
+	reconcile := func(gracePeriod time.Duration) {
+		report, err := reconciler.Reconcile(ctx, components.Queues, components.Events, gracePeriod)
+		if err != nil {
+			log.Error().Err(err).Msg("Reconciliation failed")
+			return
+		}
+		log.Info().
+			Int("scanned", report.Scanned).
+			Int("reenqueued", report.Reenqueued).
+			Int("orphans_removed", report.Orphans).
+			Int("failed", report.Failed).
+			Msg("Reconciliation finished")
+	}
+
+	elector := startElector(ctx, wg, components, "reconciler")
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		log.Info().Msg("Reconciler started.")
+		if cfg.Queue.Backend == "memory" {
+			// The in-process queues start out empty, so the pending events
+			// of a previous run are queued again right away. The other roles
+			// already run and may queue some of them too; the queues add an
+			// event only once, and workers only run the events they claim.
+			reconcile(0)
+		}
+		for {
+			select {
+			case <-ctx.Done():
+				log.Info().Msg("Reconciler is shutting down...")
+				return
+			case <-ticker.C:
+				if !elector.IsLeader() {
+					log.Debug().Msg("Not the leader, skipping tick")
+					continue
+				}
+				reconcile(gracePeriod)
+			}
+		}
+	}()
+	return nil
+}
+
+// startElector campaigns for the service's leader lease until ctx is
+// cancelled. Only one replica does the work at a time, the others stand by.
+func startElector(ctx context.Context, wg *sync.WaitGroup, components *helpers.AppComponents, service string) *leader.Elector {
+	elector := leader.NewElector(components.RedisClient, service,
+		time.Duration(components.Config.Leader.TTLSeconds)*time.Second)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		elector.Run(ctx)
+	}()
+	return elector
+}
@@ -0,0 +1,413 @@
+package schedules
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/cankoe/rrule-scheduler/internal/events"
+	"github.com/cankoe/rrule-scheduler/internal/models"
+	"github.com/cankoe/rrule-scheduler/internal/queue"
+	"github.com/cankoe/rrule-scheduler/internal/store"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	defaultReplayLimit = 100
+	maxReplayLimit     = 1000
+
+	defaultSearchLimit = 50
+	maxSearchLimit     = 500
+	// maxSearchSkip bounds how deep the search pages, since pending and
+	// archived events are merged in memory.
+	maxSearchSkip = 10000
+)
+
+// finalStatuses are the statuses an event is archived with; every other
+// status belongs to a pending event.
+var finalStatuses = map[string]bool{
+	"completed": true,
+	"error":     true,
+	"cancelled": true,
+	"skipped":   true,
+}
+
+// retryableStatuses are the final statuses an archived event can be replayed
+// from. Completed events are not replayed; trigger the schedule instead.
+var retryableStatuses = map[string]bool{
+	"error":     true,
+	"cancelled": true,
+	"skipped":   true,
+}
+
+// replayRequest selects archived events to move back into the pipeline by
+// schedule, run time range and final status (error by default).
+type replayRequest struct {
+	ScheduleID string     `json:"schedule_id,omitempty"`
+	Status     string     `json:"status,omitempty"`
+	From       *time.Time `json:"from,omitempty"`
+	To         *time.Time `json:"to,omitempty"`
+	Limit      int        `json:"limit,omitempty"`
+}
+
+// eventResponse is an event as returned by the event endpoints, which look
+// among both the pending and the archived events.
+type eventResponse struct {
+	models.Event
+	Archived bool `json:"archived"`
+}
+
+// searchQuery filters events across schedules by latest status and run time.
+type searchQuery struct {
+	ScheduleID string
+	Status     string
+	From       time.Time
+	To         time.Time
+	Skip       int
+	Limit      int
+}
+
+// RegisterEventRoutes defines HTTP routes that act on events directly rather
+// than through their schedule.
+func RegisterEventRoutes(r *gin.Engine, eventStore store.EventStore, queues queue.Queues) {
+	group := r.Group("/api")
+
+	group.GET("/events", func(c *gin.Context) {
+		query, err := getSearchQueryParams(c)
+		if err != nil {
+			statusCode, apiErr := mapErrorToStatusCode(err)
+			c.JSON(statusCode, gin.H{"error": apiErr})
+			return
+		}
+		found, err := searchEvents(c.Request.Context(), eventStore, query)
+		if err != nil {
+			statusCode, apiErr := mapErrorToStatusCode(err)
+			c.JSON(statusCode, gin.H{"error": apiErr})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"events": found, "count": len(found), "skip": query.Skip, "limit": query.Limit})
+	})
+
+	group.GET("/events/:id", func(c *gin.Context) {
+		event, err := getEventByID(c.Request.Context(), eventStore, c.Param("id"))
+		if err != nil {
+			statusCode, apiErr := mapErrorToStatusCode(err)
+			c.JSON(statusCode, gin.H{"error": apiErr})
+			return
+		}
+		c.JSON(http.StatusOK, event)
+	})
+
+	group.POST("/events/:id/retry", func(c *gin.Context) {
+		eventID := c.Param("id")
+		if err := retryArchivedEvent(c.Request.Context(), eventStore, queues, eventID); err != nil {
+			statusCode, apiErr := mapErrorToStatusCode(err)
+			c.JSON(statusCode, gin.H{"error": apiErr})
+			return
+		}
+		c.JSON(http.StatusAccepted, gin.H{"message": "Event queued for retry.", "event_id": eventID})
+	})
+
+	group.POST("/events/replay", func(c *gin.Context) {
+		var req replayRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+			return
+		}
+		replayed, failed, err := replayArchivedEvents(c.Request.Context(), eventStore, queues, &req)
+		if err != nil {
+			statusCode, apiErr := mapErrorToStatusCode(err)
+			c.JSON(statusCode, gin.H{"error": apiErr})
+			return
+		}
+		c.JSON(http.StatusAccepted, gin.H{
+			"replayed":  len(replayed),
+			"failed":    failed,
+			"event_ids": replayed,
+		})
+	})
+}
+
+func getSearchQueryParams(c *gin.Context) (*searchQuery, error) {
+	q := &searchQuery{
+		ScheduleID: c.Query("schedule_id"),
+		Status:     c.Query("status"),
+		Limit:      defaultSearchLimit,
+	}
+
+	var err error
+	if v := c.Query("from"); v != "" {
+		if q.From, err = time.Parse(time.RFC3339, v); err != nil {
+			return nil, &ApiError{
+				Code:    ErrCodeInvalidRequest,
+				Message: "Invalid 'from' timestamp, expected RFC 3339",
+			}
+		}
+	}
+	if v := c.Query("to"); v != "" {
+		if q.To, err = time.Parse(time.RFC3339, v); err != nil {
+			return nil, &ApiError{
+				Code:    ErrCodeInvalidRequest,
+				Message: "Invalid 'to' timestamp, expected RFC 3339",
+			}
+		}
+	}
+	if !q.From.IsZero() && !q.To.IsZero() && q.To.Before(q.From) {
+		return nil, &ApiError{
+			Code:    ErrCodeInvalidRequest,
+			Message: "'to' must not be before 'from'",
+		}
+	}
+	if v := c.Query("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit <= 0 {
+			return nil, &ApiError{
+				Code:    ErrCodeInvalidRequest,
+				Message: "Invalid 'limit', expected a positive integer",
+			}
+		}
+		q.Limit = min(limit, maxSearchLimit)
+	}
+	if v := c.Query("skip"); v != "" {
+		skip, err := strconv.Atoi(v)
+		if err != nil || skip < 0 || skip > maxSearchSkip {
+			return nil, &ApiError{
+				Code:    ErrCodeInvalidRequest,
+				Message: "Invalid 'skip', expected an integer between 0 and " + strconv.Itoa(maxSearchSkip),
+			}
+		}
+		q.Skip = skip
+	}
+	return q, nil
+}
+
+// getEventByID looks an event up among the pending events first, then in the
+// archive.
+func getEventByID(ctx context.Context, eventStore store.EventStore, eventHexID string) (*eventResponse, error) {
+	if _, err := primitive.ObjectIDFromHex(eventHexID); err != nil {
+		return nil, &ApiError{
+			Code:    ErrCodeInvalidRequest,
+			Message: "Invalid event ID format",
+		}
+	}
+
+	for _, archived := range []bool{false, true} {
+		get := eventStore.Get
+		if archived {
+			get = eventStore.GetArchived
+		}
+		event, err := get(ctx, eventHexID)
+		if errors.Is(err, store.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, &ApiError{
+				Code:    ErrCodeDatabaseError,
+				Message: "Failed to retrieve event",
+			}
+		}
+		return &eventResponse{Event: *event, Archived: archived}, nil
+	}
+	return nil, &ApiError{
+		Code:    ErrCodeNotFound,
+		Message: "Event not found",
+	}
+}
+
+// searchEvents returns the latest events (by run time) matching the query.
+// A final status is only looked up in the archive and any other status only
+// among the pending events; without a status, both are searched.
+func searchEvents(ctx context.Context, eventStore store.EventStore, q *searchQuery) ([]eventResponse, error) {
+	filter := &store.EventFilter{
+		ScheduleID: q.ScheduleID,
+		Status:     q.Status,
+		From:       q.From,
+		To:         q.To,
+		// The page can start in either list, so each one is read up to its end
+		Limit: q.Skip + q.Limit,
+	}
+	searchPending := q.Status == "" || !finalStatuses[q.Status]
+	searchArchived := q.Status == "" || finalStatuses[q.Status]
+
+	found := []eventResponse{}
+	for _, archived := range []bool{false, true} {
+		if (archived && !searchArchived) || (!archived && !searchPending) {
+			continue
+		}
+		list := eventStore.List
+		if archived {
+			list = eventStore.ListArchived
+		}
+		matches, err := list(ctx, filter)
+		if err != nil {
+			return nil, &ApiError{
+				Code:    ErrCodeDatabaseError,
+				Message: "Failed to fetch events",
+			}
+		}
+		for _, event := range matches {
+			found = append(found, eventResponse{Event: event, Archived: archived})
+		}
+	}
+
+	// Merge pending and archived events and page through the latest run times
+	// overall
+	sort.SliceStable(found, func(i, j int) bool {
+		return found[i].RunTime.After(found[j].RunTime)
+	})
+	if q.Skip >= len(found) {
+		return []eventResponse{}, nil
+	}
+	found = found[q.Skip:]
+	if len(found) > q.Limit {
+		found = found[:q.Limit]
+	}
+	return found, nil
+}
+
+// retryArchivedEvent moves one archived event back into the pipeline.
+func retryArchivedEvent(ctx context.Context,
+	eventStore store.EventStore,
+	queues queue.Queues,
+	eventHexID string,
+) error {
+	if _, err := primitive.ObjectIDFromHex(eventHexID); err != nil {
+		return &ApiError{
+			Code:    ErrCodeInvalidRequest,
+			Message: "Invalid event ID format",
+		}
+	}
+
+	event, err := eventStore.GetArchived(ctx, eventHexID)
+	if err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			return &ApiError{
+				Code:    ErrCodeNotFound,
+				Message: "Archived event not found",
+			}
+		}
+		return &ApiError{
+			Code:    ErrCodeDatabaseError,
+			Message: "Failed to fetch archived event",
+		}
+	}
+	last := ""
+	if n := len(event.Status); n > 0 {
+		last = event.Status[n-1].Status
+	}
+	if !retryableStatuses[last] {
+		return &ApiError{
+			Code:    ErrCodeConflict,
+			Message: fmt.Sprintf("Event ended with status '%s' and cannot be retried", last),
+		}
+	}
+	return requeueArchivedEvent(ctx, eventStore, queues, eventHexID, last)
+}
+
+// replayArchivedEvents moves the archived events matching the request back
+// into the pipeline and returns the IDs of the replayed events and the number
+// of events that could not be replayed.
+func replayArchivedEvents(ctx context.Context,
+	eventStore store.EventStore,
+	queues queue.Queues,
+	req *replayRequest,
+) ([]string, int, error) {
+	if req.Status == "" {
+		req.Status = "error"
+	}
+	if !retryableStatuses[req.Status] {
+		return nil, 0, &ApiError{
+			Code:    ErrCodeInvalidRequest,
+			Message: "Invalid 'status', expected one of error, cancelled, skipped",
+		}
+	}
+	if req.From != nil && req.To != nil && req.To.Before(*req.From) {
+		return nil, 0, &ApiError{
+			Code:    ErrCodeInvalidRequest,
+			Message: "'to' must not be before 'from'",
+		}
+	}
+	if req.Limit <= 0 {
+		req.Limit = defaultReplayLimit
+	}
+	if req.Limit > maxReplayLimit {
+		req.Limit = maxReplayLimit
+	}
+
+	filter := &store.EventFilter{
+		ScheduleID: req.ScheduleID,
+		Status:     req.Status,
+		Ascending:  true,
+		Limit:      req.Limit,
+	}
+	if req.From != nil {
+		filter.From = *req.From
+	}
+	if req.To != nil {
+		filter.To = *req.To
+	}
+	matches, err := eventStore.ListArchived(ctx, filter)
+	if err != nil {
+		return nil, 0, &ApiError{
+			Code:    ErrCodeDatabaseError,
+			Message: "Failed to fetch archived events",
+		}
+	}
+
+	replayed := []string{}
+	failed := 0
+	for _, match := range matches {
+		if err := requeueArchivedEvent(ctx, eventStore, queues, match.ID, req.Status); err != nil {
+			failed++
+			continue
+		}
+		replayed = append(replayed, match.ID)
+	}
+	return replayed, failed, nil
+}
+
+// requeueArchivedEvent restores an archived event and hands it to the workers.
+func requeueArchivedEvent(ctx context.Context,
+	eventStore store.EventStore,
+	queues queue.Queues,
+	eventID, previousStatus string,
+) error {
+	err := events.RestoreEvent(ctx, eventStore, eventID, models.StatusEntry{
+		Status:  "worker_queue",
+		Message: fmt.Sprintf("Event replayed from the archive after status '%s'", previousStatus),
+	})
+	if errors.Is(err, store.ErrDuplicate) {
+		return &ApiError{
+			Code:    ErrCodeConflict,
+			Message: "Event is already pending",
+		}
+	}
+	if errors.Is(err, store.ErrNotFound) {
+		return &ApiError{
+			Code:    ErrCodeNotFound,
+			Message: "Archived event not found",
+		}
+	}
+	if err != nil {
+		return &ApiError{
+			Code:    ErrCodeDatabaseError,
+			Message: "Failed to restore event",
+		}
+	}
+
+	if err := queues.Push(ctx, eventID); err != nil {
+		events.RecordErrorStatus(ctx, eventStore, eventID,
+			"Failed to push to worker_queue: "+err.Error())
+		return &ApiError{
+			Code:    ErrCodeDatabaseError,
+			Message: "Failed to queue event",
+		}
+	}
+	return nil
+}
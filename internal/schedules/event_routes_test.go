@@ -0,0 +1,53 @@
+package schedules
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/cankoe/rrule-scheduler/internal/models"
+	"github.com/cankoe/rrule-scheduler/internal/store/memstore"
+)
+
+func TestSearchEventsPagesAcrossPendingAndArchived(t *testing.T) {
+	ctx := context.Background()
+	eventStore := memstore.NewEventStore()
+
+	// Run times alternate between archived and pending events, latest first
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	var want []string
+	for i := 0; i < 6; i++ {
+		event := &models.Event{ScheduleID: "schedule", RunTime: start.Add(time.Duration(-i) * time.Hour)}
+		if _, err := eventStore.Insert(ctx, event); err != nil {
+			t.Fatalf("insert event: %v", err)
+		}
+		if i%2 == 0 {
+			if err := eventStore.Archive(ctx, event.ID, models.StatusEntry{Status: "completed"}); err != nil {
+				t.Fatalf("archive event: %v", err)
+			}
+		}
+		want = append(want, event.ID)
+	}
+
+	var got []string
+	for skip := 0; skip < len(want)+2; skip += 4 {
+		page, err := searchEvents(ctx, eventStore, &searchQuery{Skip: skip, Limit: 4})
+		if err != nil {
+			t.Fatalf("search events: %v", err)
+		}
+		for _, event := range page {
+			if event.Archived != (len(got)%2 == 0) {
+				t.Errorf("event %d: got archived %v", len(got), event.Archived)
+			}
+			got = append(got, event.ID)
+		}
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %d events, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("event %d: got %s, want %s", i, got[i], want[i])
+		}
+	}
+}
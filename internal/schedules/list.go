@@ -0,0 +1,178 @@
+package schedules
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cankoe/rrule-scheduler/internal/models"
+	"github.com/cankoe/rrule-scheduler/internal/store"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	defaultListLimit = 20
+	maxListLimit     = 100
+)
+
+// sortFields maps the public sort keys to the sort orders of the store.
+var sortFields = map[string]string{
+	"created_at":   store.SortCreatedAt,
+	"name":         store.SortName,
+	"callback_url": store.SortCallbackURL,
+}
+
+// listCursor points just past the last schedule of the previous page. It is
+// handed to clients as an opaque base64 string.
+type listCursor struct {
+	Sort  string `json:"s"`
+	Value string `json:"v,omitempty"`
+	ID    string `json:"id"`
+}
+
+func getListQueryParams(c *gin.Context) (*store.ScheduleQuery, error) {
+	q := &store.ScheduleQuery{
+		NamePrefix:   c.Query("name"),
+		CallbackHost: c.Query("callback_host"),
+		Method:       strings.ToUpper(c.Query("method")),
+		State:        c.Query("state"),
+		Labels:       map[string]string{},
+		Sort:         store.SortCreatedAt,
+	}
+
+	if q.State != "" && q.State != models.ScheduleStateActive && q.State != models.ScheduleStatePaused {
+		return nil, &ApiError{
+			Code:    ErrCodeInvalidRequest,
+			Message: "Invalid 'state', expected 'active' or 'paused'",
+		}
+	}
+
+	for _, label := range c.QueryArray("label") {
+		key, value, ok := strings.Cut(label, ":")
+		if !ok || key == "" {
+			return nil, &ApiError{
+				Code:    ErrCodeInvalidRequest,
+				Message: "Invalid 'label' filter, expected key:value",
+			}
+		}
+		q.Labels[key] = value
+	}
+
+	var err error
+	if v := c.Query("created_after"); v != "" {
+		if q.CreatedAfter, err = time.Parse(time.RFC3339, v); err != nil {
+			return nil, &ApiError{
+				Code:    ErrCodeInvalidRequest,
+				Message: "Invalid 'created_after' timestamp, expected RFC 3339",
+			}
+		}
+	}
+	if v := c.Query("created_before"); v != "" {
+		if q.CreatedBefore, err = time.Parse(time.RFC3339, v); err != nil {
+			return nil, &ApiError{
+				Code:    ErrCodeInvalidRequest,
+				Message: "Invalid 'created_before' timestamp, expected RFC 3339",
+			}
+		}
+	}
+
+	if sort := c.Query("sort"); sort != "" {
+		q.Descending = strings.HasPrefix(sort, "-")
+		field, ok := sortFields[strings.TrimPrefix(sort, "-")]
+		if !ok {
+			return nil, &ApiError{
+				Code:    ErrCodeInvalidRequest,
+				Message: "Invalid 'sort', expected one of created_at, name, callback_url (prefix with '-' for descending)",
+			}
+		}
+		q.Sort = field
+	}
+
+	if v := c.Query("cursor"); v != "" {
+		cur, err := decodeListCursor(v)
+		if err != nil || cur.Sort != sortKey(q) || !primitive.IsValidObjectID(cur.ID) {
+			return nil, &ApiError{
+				Code:    ErrCodeInvalidRequest,
+				Message: "Invalid cursor for this sort order",
+			}
+		}
+		q.After = &store.ScheduleCursor{Value: cur.Value, ID: cur.ID}
+	}
+
+	q.Limit, err = strconv.Atoi(c.Query("limit"))
+	if err != nil || q.Limit <= 0 {
+		q.Limit = defaultListLimit
+	}
+	if q.Limit > maxListLimit {
+		q.Limit = maxListLimit
+	}
+	return q, nil
+}
+
+// listSchedules returns one page of schedules matching the query, plus the
+// cursor of the next page (empty on the last page).
+func listSchedules(ctx context.Context, scheduleStore store.ScheduleStore, q *store.ScheduleQuery) ([]models.Schedule, string, error) {
+	// Ask for one more schedule to find out whether there is a next page
+	page := *q
+	page.Limit = q.Limit + 1
+	schedules, err := scheduleStore.List(ctx, &page)
+	if err != nil {
+		return nil, "", &ApiError{
+			Code:    ErrCodeDatabaseError,
+			Message: "Failed to list schedules",
+		}
+	}
+	if len(schedules) <= q.Limit {
+		return schedules, "", nil
+	}
+
+	schedules = schedules[:q.Limit]
+	last := schedules[len(schedules)-1]
+	next, err := encodeListCursor(q, &last)
+	if err != nil {
+		return nil, "", err
+	}
+	return schedules, next, nil
+}
+
+func sortKey(q *store.ScheduleQuery) string {
+	if q.Descending {
+		return "-" + q.Sort
+	}
+	return q.Sort
+}
+
+func encodeListCursor(q *store.ScheduleQuery, last *models.Schedule) (string, error) {
+	cur := listCursor{Sort: sortKey(q), ID: last.ID}
+	switch q.Sort {
+	case store.SortName:
+		cur.Value = last.Name
+	case store.SortCallbackURL:
+		cur.Value = last.CallbackURL
+	}
+	raw, err := json.Marshal(cur)
+	if err != nil {
+		return "", &ApiError{
+			Code:    ErrCodeDatabaseError,
+			Message: "Failed to build pagination cursor",
+		}
+	}
+	return base64.RawURLEncoding.EncodeToString(raw), nil
+}
+
+func decodeListCursor(s string) (*listCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	var cur listCursor
+	if err := json.Unmarshal(raw, &cur); err != nil {
+		return nil, err
+	}
+	return &cur, nil
+}
@@ -0,0 +1,222 @@
+package schedules
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/cankoe/rrule-scheduler/internal/models"
+	"github.com/cankoe/rrule-scheduler/internal/queue"
+	"github.com/cankoe/rrule-scheduler/internal/store/memstore"
+
+	"github.com/gin-gonic/gin"
+)
+
+type listResponse struct {
+	Schedules  []models.Schedule `json:"schedules"`
+	NextCursor string            `json:"next_cursor"`
+	Limit      int               `json:"limit"`
+}
+
+// newListRouter serves the schedule routes on top of the given schedules.
+func newListRouter(t *testing.T, schedules []models.Schedule) (*gin.Engine, []models.Schedule) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	scheduleStore := memstore.NewScheduleStore()
+	for i := range schedules {
+		if schedules[i].State == "" {
+			schedules[i].State = models.ScheduleStateActive
+		}
+		if _, err := scheduleStore.Create(context.Background(), &schedules[i]); err != nil {
+			t.Fatalf("create schedule: %v", err)
+		}
+	}
+	r := gin.New()
+	RegisterScheduleRoutes(r, scheduleStore, memstore.NewEventStore(), queue.NewMemoryQueues())
+	return r, schedules
+}
+
+func getList(t *testing.T, r *gin.Engine, query url.Values) (int, *listResponse) {
+	t.Helper()
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/schedules?"+query.Encode(), nil))
+	var resp listResponse
+	if w.Code == http.StatusOK {
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("decode response: %v", err)
+		}
+	}
+	return w.Code, &resp
+}
+
+func scheduleIDs(schedules []models.Schedule) []string {
+	ids := make([]string, 0, len(schedules))
+	for _, schedule := range schedules {
+		ids = append(ids, schedule.ID)
+	}
+	return ids
+}
+
+func TestListSchedulesPagesAcrossTies(t *testing.T) {
+	r, schedules := newListRouter(t, []models.Schedule{
+		{Name: "beta", CallbackURL: "https://b.example.com/"},
+		{Name: "alpha", CallbackURL: "https://a.example.com/"},
+		{Name: "beta", CallbackURL: "https://a.example.com/"},
+		{Name: "alpha", CallbackURL: "https://b.example.com/"},
+		{Name: "gamma", CallbackURL: "https://a.example.com/"},
+		{Name: "alpha", CallbackURL: "https://a.example.com/"},
+		{Name: "beta", CallbackURL: "https://c.example.com/"},
+	})
+
+	for _, sortParam := range []string{"name", "-name", "callback_url", "-callback_url", "created_at", "-created_at"} {
+		t.Run(sortParam, func(t *testing.T) {
+			field := sortParam
+			descending := field[0] == '-'
+			if descending {
+				field = field[1:]
+			}
+			value := func(s *models.Schedule) string {
+				switch field {
+				case "name":
+					return s.Name
+				case "callback_url":
+					return s.CallbackURL
+				}
+				return ""
+			}
+			want := append([]models.Schedule(nil), schedules...)
+			sort.Slice(want, func(i, j int) bool {
+				a, b := &want[i], &want[j]
+				if value(a) != value(b) {
+					return value(a) < value(b) != descending
+				}
+				return a.ID < b.ID != descending
+			})
+
+			var got []string
+			query := url.Values{"sort": {sortParam}, "limit": {"2"}}
+			for pages := 0; ; pages++ {
+				if pages > len(schedules) {
+					t.Fatal("paging does not end")
+				}
+				status, resp := getList(t, r, query)
+				if status != http.StatusOK {
+					t.Fatalf("got status %d", status)
+				}
+				got = append(got, scheduleIDs(resp.Schedules)...)
+				if resp.NextCursor == "" {
+					break
+				}
+				query.Set("cursor", resp.NextCursor)
+			}
+
+			wantIDs := scheduleIDs(want)
+			if len(got) != len(wantIDs) {
+				t.Fatalf("got %d schedules, want %d", len(got), len(wantIDs))
+			}
+			for i := range wantIDs {
+				if got[i] != wantIDs[i] {
+					t.Errorf("schedule %d: got %s, want %s", i, got[i], wantIDs[i])
+				}
+			}
+		})
+	}
+}
+
+func TestListSchedulesFilters(t *testing.T) {
+	r, schedules := newListRouter(t, []models.Schedule{
+		{Name: "report-daily", CallbackURL: "https://example.com/hook", Labels: map[string]string{"env": "prod", "team": "a"}},
+		{Name: "report-weekly", CallbackURL: "http://user@example.com:8080", Method: "post", Labels: map[string]string{"env": "dev"}},
+		{Name: "cleanup", CallbackURL: "https://notexample.com/hook", Method: "GET", State: models.ScheduleStatePaused},
+		{Name: "Report-upper", CallbackURL: "https://example.com.evil.io/hook", Method: "DELETE", Labels: map[string]string{"env": "prod"}},
+	})
+	now := time.Now().UTC()
+
+	tests := []struct {
+		name  string
+		query url.Values
+		want  []int
+	}{
+		{name: "no filters", query: url.Values{}, want: []int{0, 1, 2, 3}},
+		{name: "name prefix is case-sensitive", query: url.Values{"name": {"report"}}, want: []int{0, 1}},
+		{name: "callback host", query: url.Values{"callback_host": {"example.com"}}, want: []int{0, 1}},
+		{name: "callback host is case-insensitive", query: url.Values{"callback_host": {"EXAMPLE.com"}}, want: []int{0, 1}},
+		{name: "empty method means GET", query: url.Values{"method": {"get"}}, want: []int{0, 2}},
+		{name: "method", query: url.Values{"method": {"POST"}}, want: []int{1}},
+		{name: "paused", query: url.Values{"state": {"paused"}}, want: []int{2}},
+		{name: "active", query: url.Values{"state": {"active"}}, want: []int{0, 1, 3}},
+		{name: "label", query: url.Values{"label": {"env:prod"}}, want: []int{0, 3}},
+		{name: "labels", query: url.Values{"label": {"env:prod", "team:a"}}, want: []int{0}},
+		{name: "created in range", query: url.Values{
+			"created_after":  {now.Add(-time.Hour).Format(time.RFC3339)},
+			"created_before": {now.Add(time.Hour).Format(time.RFC3339)},
+		}, want: []int{0, 1, 2, 3}},
+		{name: "created later", query: url.Values{"created_after": {now.Add(time.Hour).Format(time.RFC3339)}}, want: nil},
+		{name: "created earlier", query: url.Values{"created_before": {now.Add(-time.Hour).Format(time.RFC3339)}}, want: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, resp := getList(t, r, tt.query)
+			if status != http.StatusOK {
+				t.Fatalf("got status %d", status)
+			}
+			got := map[string]bool{}
+			for _, id := range scheduleIDs(resp.Schedules) {
+				got[id] = true
+			}
+			if len(got) != len(tt.want) {
+				t.Errorf("got %d schedules, want %d", len(got), len(tt.want))
+			}
+			for _, i := range tt.want {
+				if !got[schedules[i].ID] {
+					t.Errorf("schedule %q is missing", schedules[i].Name)
+				}
+			}
+		})
+	}
+}
+
+func TestListSchedulesRejectsInvalidQueries(t *testing.T) {
+	r, _ := newListRouter(t, []models.Schedule{
+		{Name: "a", CallbackURL: "https://example.com/"},
+		{Name: "b", CallbackURL: "https://example.com/"},
+	})
+	_, resp := getList(t, r, url.Values{"sort": {"name"}, "limit": {"1"}})
+	if resp.NextCursor == "" {
+		t.Fatal("got no cursor")
+	}
+	encode := func(v interface{}) string {
+		raw, _ := json.Marshal(v)
+		return base64.RawURLEncoding.EncodeToString(raw)
+	}
+
+	tests := []struct {
+		name  string
+		query url.Values
+	}{
+		{name: "cursor is not base64", query: url.Values{"cursor": {"!!!"}}},
+		{name: "cursor is not JSON", query: url.Values{"cursor": {base64.RawURLEncoding.EncodeToString([]byte("nope"))}}},
+		{name: "cursor of another sort order", query: url.Values{"sort": {"-name"}, "cursor": {resp.NextCursor}}},
+		{name: "cursor of the default sort order", query: url.Values{"cursor": {resp.NextCursor}}},
+		{name: "cursor with an invalid ID", query: url.Values{"sort": {"name"}, "cursor": {encode(listCursor{Sort: "name", Value: "a", ID: "nope"})}}},
+		{name: "unknown sort", query: url.Values{"sort": {"state"}}},
+		{name: "unknown state", query: url.Values{"state": {"deleted"}}},
+		{name: "label without value", query: url.Values{"label": {"env"}}},
+		{name: "label without key", query: url.Values{"label": {":prod"}}},
+		{name: "created_after", query: url.Values{"created_after": {"yesterday"}}},
+		{name: "created_before", query: url.Values{"created_before": {"2025-01-01"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if status, _ := getList(t, r, tt.query); status != http.StatusBadRequest {
+				t.Errorf("got status %d, want 400", status)
+			}
+		})
+	}
+}
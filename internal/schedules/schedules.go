@@ -2,19 +2,24 @@ package schedules
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
+	"io"
 	"net/http"
 	"net/url"
 	"strconv"
+	"strings"
+	"time"
 
+	"github.com/cankoe/rrule-scheduler/internal/events"
 	"github.com/cankoe/rrule-scheduler/internal/models"
+	"github.com/cankoe/rrule-scheduler/internal/queue"
+	"github.com/cankoe/rrule-scheduler/internal/recurrence"
+	"github.com/cankoe/rrule-scheduler/internal/store"
 
 	"github.com/gin-gonic/gin"
-	"github.com/teambition/rrule-go"
-	"go.mongodb.org/mongo-driver/bson"
+	"github.com/rs/zerolog/log"
 	"go.mongodb.org/mongo-driver/bson/primitive"
-	"go.mongodb.org/mongo-driver/mongo"
-	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 const (
@@ -22,8 +27,19 @@ const (
 	ErrCodeNotFound         = "not_found"
 	ErrCodeDatabaseError    = "database_error"
 	ErrCodeValidationFailed = "validation_failed"
+	ErrCodeConflict         = "conflict"
 )
 
+const (
+	defaultPreviewLimit = 10
+	maxPreviewLimit     = 1000
+)
+
+// skipRequest names an occurrence of a schedule that should not run.
+type skipRequest struct {
+	RunTime time.Time `json:"run_time"`
+}
+
 type ApiError struct {
 	Code    string `json:"code"`
 	Message string `json:"message"`
@@ -34,16 +50,32 @@ func (e *ApiError) Error() string {
 }
 
 // RegisterScheduleRoutes defines HTTP routes for schedules & their events.
-func RegisterScheduleRoutes(r *gin.Engine, db *mongo.Database) {
-	schedulesCol := db.Collection("schedules")
-	eventsCol := db.Collection("events")
-	archivedEventsCol := db.Collection("archived_events")
-
+func RegisterScheduleRoutes(r *gin.Engine,
+	scheduleStore store.ScheduleStore,
+	eventStore store.EventStore,
+	queues queue.Queues,
+) {
 	group := r.Group("/api")
 
+	group.GET("/schedules", func(c *gin.Context) {
+		query, err := getListQueryParams(c)
+		if err != nil {
+			statusCode, apiErr := mapErrorToStatusCode(err)
+			c.JSON(statusCode, gin.H{"error": apiErr})
+			return
+		}
+		schedules, nextCursor, err := listSchedules(c.Request.Context(), scheduleStore, query)
+		if err != nil {
+			statusCode, apiErr := mapErrorToStatusCode(err)
+			c.JSON(statusCode, gin.H{"error": apiErr})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"schedules": schedules, "next_cursor": nextCursor, "limit": query.Limit})
+	})
+
 	group.GET("/schedules/:id", func(c *gin.Context) {
 		scheduleID := c.Param("id")
-		schedule, err := getScheduleByID(c.Request.Context(), schedulesCol, scheduleID)
+		schedule, err := getScheduleByID(c.Request.Context(), scheduleStore, scheduleID)
 		if err != nil {
 			statusCode, apiErr := mapErrorToStatusCode(err)
 			c.JSON(statusCode, gin.H{"error": apiErr})
@@ -58,23 +90,23 @@ func RegisterScheduleRoutes(r *gin.Engine, db *mongo.Database) {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
 			return
 		}
-		objID, err := createSchedule(c.Request.Context(), schedulesCol, &schedule)
+		id, err := createSchedule(c.Request.Context(), scheduleStore, &schedule)
 		if err != nil {
 			statusCode, apiErr := mapErrorToStatusCode(err)
 			c.JSON(statusCode, gin.H{"error": apiErr})
 			return
 		}
-		c.JSON(http.StatusCreated, gin.H{"id": objID.Hex()})
+		c.JSON(http.StatusCreated, gin.H{"id": id})
 	})
 
 	group.PUT("/schedules/:id", func(c *gin.Context) {
 		scheduleID := c.Param("id")
-		var updates bson.M
+		var updates map[string]json.RawMessage
 		if err := c.ShouldBindJSON(&updates); err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON body for updates"})
 			return
 		}
-		if err := updateSchedule(c.Request.Context(), schedulesCol, scheduleID, updates); err != nil {
+		if err := updateSchedule(c.Request.Context(), scheduleStore, scheduleID, updates); err != nil {
 			statusCode, apiErr := mapErrorToStatusCode(err)
 			c.JSON(statusCode, gin.H{"error": apiErr})
 			return
@@ -84,7 +116,7 @@ func RegisterScheduleRoutes(r *gin.Engine, db *mongo.Database) {
 
 	group.DELETE("/schedules/:id", func(c *gin.Context) {
 		scheduleID := c.Param("id")
-		if err := deleteScheduleAndEvents(c.Request.Context(), schedulesCol, eventsCol, scheduleID); err != nil {
+		if err := deleteScheduleAndEvents(c.Request.Context(), scheduleStore, eventStore, scheduleID); err != nil {
 			statusCode, apiErr := mapErrorToStatusCode(err)
 			c.JSON(statusCode, gin.H{"error": apiErr})
 			return
@@ -92,12 +124,129 @@ func RegisterScheduleRoutes(r *gin.Engine, db *mongo.Database) {
 		c.JSON(http.StatusOK, gin.H{"message": "Schedule and associated events deleted."})
 	})
 
+	group.POST("/schedules/:id/pause", func(c *gin.Context) {
+		scheduleID := c.Param("id")
+		cancelled, err := pauseSchedule(c.Request.Context(), scheduleStore, eventStore, queues, scheduleID)
+		if err != nil {
+			statusCode, apiErr := mapErrorToStatusCode(err)
+			c.JSON(statusCode, gin.H{"error": apiErr})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "Schedule paused.", "cancelled_events": cancelled})
+	})
+
+	group.POST("/schedules/:id/resume", func(c *gin.Context) {
+		scheduleID := c.Param("id")
+		if err := resumeSchedule(c.Request.Context(), scheduleStore, scheduleID); err != nil {
+			statusCode, apiErr := mapErrorToStatusCode(err)
+			c.JSON(statusCode, gin.H{"error": apiErr})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "Schedule resumed."})
+	})
+
+	// Run a schedule now, outside of its RRULE
+	group.POST("/schedules/:id/trigger", func(c *gin.Context) {
+		scheduleID := c.Param("id")
+		// The body is optional; an empty one, chunked or not, means no overrides.
+		var overrides models.CallbackOverrides
+		if err := c.ShouldBindJSON(&overrides); err != nil && !errors.Is(err, io.EOF) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+			return
+		}
+		eventID, err := triggerSchedule(c.Request.Context(), scheduleStore, eventStore, queues, scheduleID, &overrides)
+		if err != nil {
+			statusCode, apiErr := mapErrorToStatusCode(err)
+			c.JSON(statusCode, gin.H{"error": apiErr})
+			return
+		}
+		c.JSON(http.StatusAccepted, gin.H{"message": "Schedule triggered.", "event_id": eventID})
+	})
+
+	// Skip a single upcoming occurrence, whether or not its event exists yet
+	group.POST("/schedules/:id/skip", func(c *gin.Context) {
+		scheduleID := c.Param("id")
+		var req skipRequest
+		if err := c.ShouldBindJSON(&req); err != nil || req.RunTime.IsZero() {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+			return
+		}
+		eventID, err := skipOccurrence(c.Request.Context(), scheduleStore, eventStore, queues, scheduleID, req.RunTime)
+		if err != nil {
+			statusCode, apiErr := mapErrorToStatusCode(err)
+			c.JSON(statusCode, gin.H{"error": apiErr})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"message":            "Occurrence skipped.",
+			"run_time":           req.RunTime.UTC(),
+			"cancelled_event_id": eventID,
+		})
+	})
+
+	group.GET("/schedules/:id/occurrences", func(c *gin.Context) {
+		scheduleID := c.Param("id")
+		from, to, limit, err := getOccurrenceQueryParams(c)
+		if err != nil {
+			statusCode, apiErr := mapErrorToStatusCode(err)
+			c.JSON(statusCode, gin.H{"error": apiErr})
+			return
+		}
+		schedule, err := getScheduleByID(c.Request.Context(), scheduleStore, scheduleID)
+		if err != nil {
+			statusCode, apiErr := mapErrorToStatusCode(err)
+			c.JSON(statusCode, gin.H{"error": apiErr})
+			return
+		}
+		occurrences, err := previewOccurrences(schedule.RRule, schedule.Timezone, schedule.RecurrenceStart(), from, to, limit, schedule.ExcludedRunTimes...)
+		if err != nil {
+			statusCode, apiErr := mapErrorToStatusCode(err)
+			c.JSON(statusCode, gin.H{"error": apiErr})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"occurrences": occurrences, "count": len(occurrences)})
+	})
+
+	// Stateless preview of an RRULE that has not been saved yet
+	group.POST("/rrule/preview", func(c *gin.Context) {
+		var req previewRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+			return
+		}
+		var from, to time.Time
+		if req.From != nil {
+			from = *req.From
+		}
+		if req.To != nil {
+			to = *req.To
+		}
+		occurrences, err := previewOccurrences(req.RRule, req.Timezone, time.Time{}, from, to, req.Limit)
+		if err != nil {
+			statusCode, apiErr := mapErrorToStatusCode(err)
+			c.JSON(statusCode, gin.H{"error": apiErr})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"occurrences": occurrences, "count": len(occurrences)})
+	})
+
 	// GET events (pending or history)
 	group.GET("/schedules/:id/events/pending", func(c *gin.Context) {
-		handleGetEvents(c, eventsCol)
+		handleGetEvents(c, eventStore.List)
 	})
 	group.GET("/schedules/:id/events/history", func(c *gin.Context) {
-		handleGetEvents(c, archivedEventsCol)
+		handleGetEvents(c, eventStore.ListArchived)
+	})
+
+	group.DELETE("/schedules/:id/events/pending/:eventId", func(c *gin.Context) {
+		scheduleID := c.Param("id")
+		eventID := c.Param("eventId")
+		if err := cancelPendingEvent(c.Request.Context(), scheduleStore, eventStore, queues, scheduleID, eventID); err != nil {
+			statusCode, apiErr := mapErrorToStatusCode(err)
+			c.JSON(statusCode, gin.H{"error": apiErr})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "Event cancelled.", "event_id": eventID})
 	})
 }
 
@@ -105,19 +254,17 @@ func RegisterScheduleRoutes(r *gin.Engine, db *mongo.Database) {
 /*                           DB & Validation                              */
 /**************************************************************************/
 
-func getScheduleByID(ctx context.Context, col *mongo.Collection, scheduleHexID string) (*models.Schedule, error) {
-	oid, err := primitive.ObjectIDFromHex(scheduleHexID)
-	if err != nil {
+func getScheduleByID(ctx context.Context, scheduleStore store.ScheduleStore, scheduleHexID string) (*models.Schedule, error) {
+	if _, err := primitive.ObjectIDFromHex(scheduleHexID); err != nil {
 		return nil, &ApiError{
 			Code:    ErrCodeInvalidRequest,
 			Message: "Invalid schedule ID format",
 		}
 	}
 
-	var schedule models.Schedule
-	err = col.FindOne(ctx, bson.M{"_id": oid}).Decode(&schedule)
+	schedule, err := scheduleStore.Get(ctx, scheduleHexID)
 	if err != nil {
-		if err == mongo.ErrNoDocuments {
+		if errors.Is(err, store.ErrNotFound) {
 			return nil, &ApiError{
 				Code:    ErrCodeNotFound,
 				Message: "Schedule not found",
@@ -128,80 +275,387 @@ func getScheduleByID(ctx context.Context, col *mongo.Collection, scheduleHexID s
 			Message: "Failed to retrieve schedule",
 		}
 	}
-
-	// Convert ObjectID to hex string for response
-	schedule.ID = oid.Hex()
-	return &schedule, nil
+	return schedule, nil
 }
 
-func createSchedule(ctx context.Context, col *mongo.Collection, s *models.Schedule) (primitive.ObjectID, error) {
-	// Clear out any provided ID to let Mongo generate it
+func createSchedule(ctx context.Context, scheduleStore store.ScheduleStore, s *models.Schedule) (string, error) {
+	// Clear out any provided ID to let the store generate it
 	s.ID = ""
+	if s.State == "" {
+		s.State = models.ScheduleStateActive
+	}
+	s.CreatedAt = time.Now().UTC()
 	if err := validateSchedule(s); err != nil {
-		return primitive.NilObjectID, err
+		return "", err
 	}
 
-	res, err := col.InsertOne(ctx, s)
+	id, err := scheduleStore.Create(ctx, s)
 	if err != nil {
-		return primitive.NilObjectID, &ApiError{
+		return "", &ApiError{
 			Code:    ErrCodeDatabaseError,
 			Message: "Failed to create schedule in database",
 		}
 	}
-	oid, ok := res.InsertedID.(primitive.ObjectID)
-	if !ok {
-		return primitive.NilObjectID, errors.New("inserted ID is not an ObjectID")
-	}
-	return oid, nil
+	return id, nil
 }
 
-func updateSchedule(ctx context.Context, col *mongo.Collection, scheduleHexID string, updates bson.M) error {
-	oid, err := primitive.ObjectIDFromHex(scheduleHexID)
-	if err != nil {
+func updateSchedule(ctx context.Context,
+	scheduleStore store.ScheduleStore,
+	scheduleHexID string,
+	updates map[string]json.RawMessage,
+) error {
+	if _, err := primitive.ObjectIDFromHex(scheduleHexID); err != nil {
 		return errors.New("invalid schedule ID format")
 	}
 	stripReadOnlyFields(updates)
-
-	filter := bson.M{"_id": oid}
-	update := bson.M{"$set": updates}
-
-	res, err := col.UpdateOne(ctx, filter, update, options.Update().SetUpsert(false))
+	merged, err := mergeScheduleUpdate(ctx, scheduleStore, scheduleHexID, updates)
 	if err != nil {
-		return errors.New("database error on schedule update")
+		return err
+	}
+
+	// The store also clears next_run_time, as the recurrence may have changed
+	fields := make([]string, 0, len(updates))
+	for field := range updates {
+		fields = append(fields, field)
 	}
-	if res.MatchedCount == 0 {
-		return errors.New("no schedule found with the specified ID")
+	if err := scheduleStore.Update(ctx, merged, fields); err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			return errors.New("no schedule found with the specified ID")
+		}
+		return errors.New("database error on schedule update")
 	}
 	return nil
 }
 
-func deleteScheduleAndEvents(ctx context.Context, schedulesCol, eventsCol *mongo.Collection, scheduleHexID string) error {
-	oid, err := primitive.ObjectIDFromHex(scheduleHexID)
-	if err != nil {
+func deleteScheduleAndEvents(ctx context.Context,
+	scheduleStore store.ScheduleStore,
+	eventStore store.EventStore,
+	scheduleHexID string,
+) error {
+	if _, err := primitive.ObjectIDFromHex(scheduleHexID); err != nil {
 		return errors.New("invalid schedule ID format")
 	}
 
-	filter := bson.M{"_id": oid}
-	res, err := schedulesCol.DeleteOne(ctx, filter)
-	if err != nil {
+	if err := scheduleStore.Delete(ctx, scheduleHexID); err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			return errors.New("no schedule found with the specified ID")
+		}
 		return errors.New("failed to delete schedule from DB")
 	}
-	if res.DeletedCount == 0 {
-		return errors.New("no schedule found with the specified ID")
-	}
 
 	// Remove events that belong to this schedule
-	if _, err := eventsCol.DeleteMany(ctx, bson.M{"schedule_id": scheduleHexID}); err != nil {
+	if err := eventStore.DeleteBySchedule(ctx, scheduleHexID); err != nil {
 		return errors.New("failed to delete associated events")
 	}
 	return nil
 }
 
+// pauseSchedule marks the schedule as paused and cancels its events that are
+// still waiting in the ready_queue. Events already handed to a worker are
+// cancelled by the worker itself once it sees the paused state.
+func pauseSchedule(ctx context.Context,
+	scheduleStore store.ScheduleStore,
+	eventStore store.EventStore,
+	queues queue.Queues,
+	scheduleHexID string,
+) (int, error) {
+	if _, err := primitive.ObjectIDFromHex(scheduleHexID); err != nil {
+		return 0, &ApiError{
+			Code:    ErrCodeInvalidRequest,
+			Message: "Invalid schedule ID format",
+		}
+	}
+
+	if err := scheduleStore.Pause(ctx, scheduleHexID, time.Now().UTC()); err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			return 0, &ApiError{
+				Code:    ErrCodeNotFound,
+				Message: "Schedule not found",
+			}
+		}
+		return 0, &ApiError{
+			Code:    ErrCodeDatabaseError,
+			Message: "Failed to pause schedule",
+		}
+	}
+
+	pending, err := eventStore.List(ctx, &store.EventFilter{ScheduleID: scheduleHexID})
+	if err != nil {
+		return 0, &ApiError{
+			Code:    ErrCodeDatabaseError,
+			Message: "Failed to fetch pending events",
+		}
+	}
+
+	cancelled := 0
+	for _, event := range pending {
+		// Only events we manage to pull out of the ready_queue are ours to cancel.
+		removed, err := queues.Remove(ctx, event.ID)
+		if err != nil || !removed {
+			continue
+		}
+		if err := events.UpdateAndArchiveEvent(ctx, eventStore,
+			event.ID, "cancelled", "Event cancelled because schedule was paused"); err != nil {
+			continue
+		}
+		cancelled++
+	}
+	return cancelled, nil
+}
+
+// triggerSchedule creates a manual event for the schedule and hands it
+// straight to the workers, bypassing the RRULE and the ready_queue.
+func triggerSchedule(ctx context.Context,
+	scheduleStore store.ScheduleStore,
+	eventStore store.EventStore,
+	queues queue.Queues,
+	scheduleHexID string,
+	overrides *models.CallbackOverrides,
+) (string, error) {
+	schedule, err := getScheduleByID(ctx, scheduleStore, scheduleHexID)
+	if err != nil {
+		return "", err
+	}
+	if schedule.State == models.ScheduleStatePaused {
+		return "", &ApiError{
+			Code:    ErrCodeValidationFailed,
+			Message: "Schedule is paused, resume it before triggering it",
+		}
+	}
+
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	event := models.Event{
+		ScheduleID: schedule.ID,
+		RunTime:    now,
+		Status: []models.StatusEntry{{
+			Time:    now,
+			Status:  "worker_queue",
+			Message: "Event triggered manually",
+		}},
+		Manual:    true,
+		CreatedAt: now,
+	}
+	if overrides.Body != nil || len(overrides.Headers) > 0 {
+		event.Overrides = overrides
+	}
+	eventID, err := eventStore.Insert(ctx, &event)
+	if err != nil {
+		return "", &ApiError{
+			Code:    ErrCodeDatabaseError,
+			Message: "Failed to create event",
+		}
+	}
+
+	if err := queues.Push(ctx, eventID); err != nil {
+		events.RecordErrorStatus(ctx, eventStore, eventID,
+			"Failed to push to worker_queue: "+err.Error())
+		return "", &ApiError{
+			Code:    ErrCodeDatabaseError,
+			Message: "Failed to queue event",
+		}
+	}
+	return eventID, nil
+}
+
+// cancelPendingEvent cancels one pending event of the schedule and excludes
+// its run time, so the prequeuer does not generate it again.
+func cancelPendingEvent(ctx context.Context,
+	scheduleStore store.ScheduleStore,
+	eventStore store.EventStore,
+	queues queue.Queues,
+	scheduleHexID, eventHexID string,
+) error {
+	schedule, err := getScheduleByID(ctx, scheduleStore, scheduleHexID)
+	if err != nil {
+		return err
+	}
+	if _, err := primitive.ObjectIDFromHex(eventHexID); err != nil {
+		return &ApiError{
+			Code:    ErrCodeInvalidRequest,
+			Message: "Invalid event ID format",
+		}
+	}
+
+	event, err := eventStore.Get(ctx, eventHexID)
+	if err == nil && event.ScheduleID != schedule.ID {
+		err = store.ErrNotFound
+	}
+	if err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			return &ApiError{
+				Code:    ErrCodeNotFound,
+				Message: "Pending event not found",
+			}
+		}
+		return &ApiError{
+			Code:    ErrCodeDatabaseError,
+			Message: "Failed to fetch pending event",
+		}
+	}
+
+	// Take the event out of the ready_queue first: once a worker has it, it
+	// cannot be cancelled and its run time must not be excluded. The pending
+	// event keeps the prequeuer from generating the occurrence again until it
+	// is excluded.
+	if err := removeQueuedEvent(ctx, queues, event.ID); err != nil {
+		return err
+	}
+	if !event.Manual {
+		if err := excludeRunTime(ctx, scheduleStore, schedule.ID, event.RunTime); err != nil {
+			// Put the event back; failing that, the reconciler does.
+			if err := queues.Schedule(ctx, queue.Item{ID: event.ID, DueAt: event.RunTime}); err != nil {
+				log.Error().Err(err).Str("event_id", event.ID).Msg("Failed to put event back into the ready_queue")
+			}
+			return err
+		}
+	}
+	return archiveCancelledEvent(ctx, eventStore, event.ID, "Event cancelled through the API")
+}
+
+// skipOccurrence excludes a future occurrence of the schedule and cancels its
+// event if the prequeuer already generated it. It returns the ID of the
+// cancelled event, if any.
+func skipOccurrence(ctx context.Context,
+	scheduleStore store.ScheduleStore,
+	eventStore store.EventStore,
+	queues queue.Queues,
+	scheduleHexID string,
+	runTime time.Time,
+) (string, error) {
+	schedule, err := getScheduleByID(ctx, scheduleStore, scheduleHexID)
+	if err != nil {
+		return "", err
+	}
+	runTime = runTime.UTC()
+	if !runTime.After(time.Now()) {
+		return "", &ApiError{
+			Code:    ErrCodeValidationFailed,
+			Message: "'run_time' must be in the future",
+		}
+	}
+	rule, err := recurrence.Parse(schedule.RRule, schedule.Timezone, schedule.RecurrenceStart())
+	if err != nil {
+		return "", &ApiError{
+			Code:    ErrCodeValidationFailed,
+			Message: "Invalid RRULE format: " + err.Error(),
+		}
+	}
+	if next, ok := rule.After(runTime, true); !ok || !next.Equal(runTime) {
+		return "", &ApiError{
+			Code:    ErrCodeValidationFailed,
+			Message: "'run_time' is not an occurrence of the schedule",
+		}
+	}
+
+	// Exclude first, so that the prequeuer stops generating the occurrence.
+	// This also keeps a prequeuer run that read the schedule before from
+	// moving the watermark past it; should that run create the event after
+	// the lookup below, the worker cancels it.
+	if err := excludeRunTime(ctx, scheduleStore, schedule.ID, runTime); err != nil {
+		return "", err
+	}
+
+	event, err := eventStore.GetByRunTime(ctx, schedule.ID, runTime)
+	if errors.Is(err, store.ErrNotFound) {
+		return "", nil
+	}
+	if err != nil {
+		return "", &ApiError{
+			Code:    ErrCodeDatabaseError,
+			Message: "Failed to fetch pending event",
+		}
+	}
+	if err := removeQueuedEvent(ctx, queues, event.ID); err != nil {
+		// The event still runs, so its run time must not stay excluded
+		if err := scheduleStore.IncludeRunTime(ctx, schedule.ID, runTime); err != nil {
+			log.Error().Err(err).Str("schedule_id", schedule.ID).Time("run_time", runTime).
+				Msg("Failed to undo the exclusion of a run time")
+		}
+		return "", err
+	}
+	if err := archiveCancelledEvent(ctx, eventStore, event.ID,
+		"Event cancelled because its occurrence was skipped"); err != nil {
+		return "", err
+	}
+	return event.ID, nil
+}
+
+// excludeRunTime adds a run time to the schedule's excluded_run_times.
+func excludeRunTime(ctx context.Context, scheduleStore store.ScheduleStore, scheduleHexID string, runTime time.Time) error {
+	if err := scheduleStore.ExcludeRunTime(ctx, scheduleHexID, runTime); err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			return &ApiError{
+				Code:    ErrCodeNotFound,
+				Message: "Schedule not found",
+			}
+		}
+		return &ApiError{
+			Code:    ErrCodeDatabaseError,
+			Message: "Failed to exclude run time",
+		}
+	}
+	return nil
+}
+
+// removeQueuedEvent pulls an event out of the ready_queue, or fails with a
+// conflict if the event was already dispatched to a worker.
+func removeQueuedEvent(ctx context.Context, queues queue.Queues, eventID string) error {
+	removed, err := queues.Remove(ctx, eventID)
+	if err != nil {
+		return &ApiError{
+			Code:    ErrCodeDatabaseError,
+			Message: "Failed to remove event from the ready_queue",
+		}
+	}
+	if !removed {
+		return &ApiError{
+			Code:    ErrCodeConflict,
+			Message: "Event was already dispatched to a worker and cannot be cancelled",
+		}
+	}
+	return nil
+}
+
+func archiveCancelledEvent(ctx context.Context, eventStore store.EventStore, eventID, message string) error {
+	if err := events.UpdateAndArchiveEvent(ctx, eventStore, eventID, "cancelled", message); err != nil {
+		return &ApiError{
+			Code:    ErrCodeDatabaseError,
+			Message: "Failed to archive cancelled event",
+		}
+	}
+	return nil
+}
+
+func resumeSchedule(ctx context.Context, scheduleStore store.ScheduleStore, scheduleHexID string) error {
+	if _, err := primitive.ObjectIDFromHex(scheduleHexID); err != nil {
+		return &ApiError{
+			Code:    ErrCodeInvalidRequest,
+			Message: "Invalid schedule ID format",
+		}
+	}
+
+	if err := scheduleStore.Resume(ctx, scheduleHexID); err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			return &ApiError{
+				Code:    ErrCodeNotFound,
+				Message: "Schedule not found",
+			}
+		}
+		return &ApiError{
+			Code:    ErrCodeDatabaseError,
+			Message: "Failed to resume schedule",
+		}
+	}
+	return nil
+}
+
 /**************************************************************************/
 /*                           EVENT LISTING                                */
 /**************************************************************************/
 
-func handleGetEvents(c *gin.Context, col *mongo.Collection) {
+type listEventsFunc func(ctx context.Context, f *store.EventFilter) ([]models.Event, error)
+
+func handleGetEvents(c *gin.Context, list listEventsFunc) {
 	scheduleID := c.Param("id")
 	if scheduleID == "" {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Missing schedule ID in URL path"})
@@ -209,26 +663,73 @@ func handleGetEvents(c *gin.Context, col *mongo.Collection) {
 	}
 	limit, page := getPaginationParams(c)
 
-	ctx := c.Request.Context()
-	filter := bson.M{"schedule_id": scheduleID}
-	opts := options.Find().
-		SetSkip(int64((page - 1) * limit)).
-		SetLimit(int64(limit)).
-		SetSort(bson.M{"run_time": -1})
-
-	cursor, err := col.Find(ctx, filter, opts)
+	events, err := list(c.Request.Context(), &store.EventFilter{
+		ScheduleID: scheduleID,
+		Skip:       (page - 1) * limit,
+		Limit:      limit,
+	})
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch events"})
 		return
 	}
-	defer cursor.Close(ctx)
+	c.JSON(http.StatusOK, gin.H{"events": events, "page": page, "limit": limit})
+}
 
-	var events []bson.M
-	if err := cursor.All(ctx, &events); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to parse events"})
-		return
+/**************************************************************************/
+/*                         OCCURRENCE PREVIEW                             */
+/**************************************************************************/
+
+type previewRequest struct {
+	RRule    string     `json:"rrule"`
+	Timezone string     `json:"timezone,omitempty"`
+	From     *time.Time `json:"from,omitempty"`
+	To       *time.Time `json:"to,omitempty"`
+	Limit    int        `json:"limit,omitempty"`
+}
+
+// previewOccurrences expands an RRULE in [from, to] without touching any
+// collection. A zero `from` defaults to now, a zero `to` leaves the window open.
+// An RRULE without DTSTART starts at start, or now if it is zero. Excluded run
+// times are left out.
+func previewOccurrences(ruleStr, timezone string, start, from, to time.Time, limit int, excluded ...time.Time) ([]time.Time, error) {
+	if ruleStr == "" {
+		return nil, &ApiError{
+			Code:    ErrCodeValidationFailed,
+			Message: "RRULE cannot be empty",
+		}
 	}
-	c.JSON(http.StatusOK, gin.H{"events": events, "page": page, "limit": limit})
+	if _, err := recurrence.LoadLocation(timezone); err != nil {
+		return nil, &ApiError{
+			Code:    ErrCodeValidationFailed,
+			Message: "Invalid timezone, expected an IANA name such as 'Europe/Berlin'",
+		}
+	}
+	rule, err := recurrence.Parse(ruleStr, timezone, start)
+	if err != nil {
+		return nil, &ApiError{
+			Code:    ErrCodeValidationFailed,
+			Message: "Invalid RRULE format: " + err.Error(),
+		}
+	}
+
+	rule.Exclude(excluded...)
+
+	if from.IsZero() {
+		from = time.Now().UTC()
+	}
+	if !to.IsZero() && to.Before(from) {
+		return nil, &ApiError{
+			Code:    ErrCodeInvalidRequest,
+			Message: "'to' must not be before 'from'",
+		}
+	}
+	if limit <= 0 {
+		limit = defaultPreviewLimit
+	}
+	if limit > maxPreviewLimit {
+		limit = maxPreviewLimit
+	}
+	return rule.Occurrences(from, to, limit), nil
 }
 
 /**************************************************************************/
@@ -248,10 +749,16 @@ func validateSchedule(s *models.Schedule) error {
 			Message: "RRULE cannot be empty",
 		}
 	}
-	if _, err := rrule.StrToRRule(s.RRule); err != nil {
+	if _, err := recurrence.LoadLocation(s.Timezone); err != nil {
+		return &ApiError{
+			Code:    ErrCodeValidationFailed,
+			Message: "Invalid timezone, expected an IANA name such as 'Europe/Berlin'",
+		}
+	}
+	if _, err := recurrence.Parse(s.RRule, s.Timezone, s.RecurrenceStart()); err != nil {
 		return &ApiError{
 			Code:    ErrCodeValidationFailed,
-			Message: "Invalid RRULE format",
+			Message: "Invalid RRULE format: " + err.Error(),
 		}
 	}
 	if _, err := url.ParseRequestURI(s.CallbackURL); err != nil {
@@ -260,12 +767,114 @@ func validateSchedule(s *models.Schedule) error {
 			Message: "Invalid callback URL format",
 		}
 	}
+	if err := s.SuccessCriteria.Validate(); err != nil {
+		return &ApiError{
+			Code:    ErrCodeValidationFailed,
+			Message: "Invalid success criteria: " + err.Error(),
+		}
+	}
+	if err := s.RetryPolicy.Validate(); err != nil {
+		return &ApiError{
+			Code:    ErrCodeValidationFailed,
+			Message: "Invalid retry policy: " + err.Error(),
+		}
+	}
+	switch s.MisfirePolicy {
+	case "", models.MisfirePolicySkip, models.MisfirePolicyFireOnce, models.MisfirePolicyFireAll:
+	default:
+		return &ApiError{
+			Code:    ErrCodeValidationFailed,
+			Message: "Misfire policy must be one of 'skip', 'fire_once' or 'fire_all'",
+		}
+	}
+	switch s.ConcurrencyPolicy {
+	case "", models.ConcurrencyPolicyAllow, models.ConcurrencyPolicyForbid,
+		models.ConcurrencyPolicyQueue, models.ConcurrencyPolicyReplace:
+	default:
+		return &ApiError{
+			Code:    ErrCodeValidationFailed,
+			Message: "Concurrency policy must be one of 'allow', 'forbid', 'queue' or 'replace'",
+		}
+	}
+	for key := range s.Labels {
+		if key == "" || strings.ContainsAny(key, ".$:") {
+			return &ApiError{
+				Code:    ErrCodeValidationFailed,
+				Message: "Label keys must be non-empty and cannot contain '.', '$' or ':'",
+			}
+		}
+	}
+	if s.State != "" && s.State != models.ScheduleStateActive && s.State != models.ScheduleStatePaused {
+		return &ApiError{
+			Code:    ErrCodeValidationFailed,
+			Message: "Schedule state must be either 'active' or 'paused'",
+		}
+	}
 	return nil
 }
 
-func stripReadOnlyFields(updates bson.M) {
+// mergeScheduleUpdate applies the updates to the stored schedule and
+// validates the result, so partial updates are held to the same rules as
+// newly created schedules. Updated fields are replaced as a whole.
+func mergeScheduleUpdate(ctx context.Context,
+	scheduleStore store.ScheduleStore,
+	scheduleHexID string,
+	updates map[string]json.RawMessage,
+) (*models.Schedule, error) {
+	stored, err := getScheduleByID(ctx, scheduleStore, scheduleHexID)
+	if err != nil {
+		return nil, err
+	}
+	raw, err := json.Marshal(stored)
+	if err != nil {
+		return nil, &ApiError{
+			Code:    ErrCodeInvalidRequest,
+			Message: "Invalid update fields",
+		}
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		return nil, &ApiError{
+			Code:    ErrCodeInvalidRequest,
+			Message: "Invalid update fields",
+		}
+	}
+	for field, value := range updates {
+		fields[field] = value
+	}
+	if raw, err = json.Marshal(fields); err != nil {
+		return nil, &ApiError{
+			Code:    ErrCodeInvalidRequest,
+			Message: "Invalid update fields",
+		}
+	}
+	var merged models.Schedule
+	if err := json.Unmarshal(raw, &merged); err != nil {
+		return nil, &ApiError{
+			Code:    ErrCodeValidationFailed,
+			Message: "Invalid field types in update",
+		}
+	}
+	merged.ID = stored.ID
+	if err := validateSchedule(&merged); err != nil {
+		return nil, err
+	}
+	return &merged, nil
+}
+
+// stripReadOnlyFields removes fields that cannot be changed through a plain
+// update. The state is only changed through the pause/resume endpoints so that
+// pending events are handled consistently, excluded run times through the
+// cancel/skip endpoints, and the watermarks are maintained by the prequeuer.
+func stripReadOnlyFields(updates map[string]json.RawMessage) {
 	delete(updates, "_id")
+	delete(updates, "id")
 	delete(updates, "created_at")
+	delete(updates, "state")
+	delete(updates, "paused_at")
+	delete(updates, "next_run_time")
+	delete(updates, "last_event_time")
+	delete(updates, "excluded_run_times")
 }
 
 func getPaginationParams(c *gin.Context) (int, int) {
@@ -283,6 +892,34 @@ func getPaginationParams(c *gin.Context) (int, int) {
 	return limit, page
 }
 
+// getOccurrenceQueryParams reads the optional from/to (RFC 3339) and limit
+// query parameters of the occurrences endpoint.
+func getOccurrenceQueryParams(c *gin.Context) (time.Time, time.Time, int, error) {
+	var from, to time.Time
+	var err error
+	if v := c.Query("from"); v != "" {
+		if from, err = time.Parse(time.RFC3339, v); err != nil {
+			return from, to, 0, &ApiError{
+				Code:    ErrCodeInvalidRequest,
+				Message: "Invalid 'from' timestamp, expected RFC 3339",
+			}
+		}
+	}
+	if v := c.Query("to"); v != "" {
+		if to, err = time.Parse(time.RFC3339, v); err != nil {
+			return from, to, 0, &ApiError{
+				Code:    ErrCodeInvalidRequest,
+				Message: "Invalid 'to' timestamp, expected RFC 3339",
+			}
+		}
+	}
+	limit, err := strconv.Atoi(c.Query("limit"))
+	if err != nil || limit <= 0 {
+		limit = defaultPreviewLimit
+	}
+	return from, to, limit, nil
+}
+
 func mapErrorToStatusCode(err error) (int, *ApiError) {
 	var apiErr *ApiError
 	if errors.As(err, &apiErr) {
@@ -293,6 +930,8 @@ func mapErrorToStatusCode(err error) (int, *ApiError) {
 			return http.StatusNotFound, apiErr
 		case ErrCodeValidationFailed:
 			return http.StatusUnprocessableEntity, apiErr
+		case ErrCodeConflict:
+			return http.StatusConflict, apiErr
 		case ErrCodeDatabaseError:
 			return http.StatusInternalServerError, apiErr
 		}
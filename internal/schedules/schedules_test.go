@@ -0,0 +1,274 @@
+package schedules
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cankoe/rrule-scheduler/internal/models"
+	"github.com/cankoe/rrule-scheduler/internal/queue"
+	"github.com/cankoe/rrule-scheduler/internal/store"
+	"github.com/cankoe/rrule-scheduler/internal/store/memstore"
+
+	"github.com/gin-gonic/gin"
+)
+
+// newPendingEvent stores a schedule with one pending event at runTime. The
+// event is only in the ready_queue if queued is set.
+func newPendingEvent(t *testing.T, queued bool) (*memstore.ScheduleStore, *memstore.EventStore, *queue.MemoryQueues, *models.Event) {
+	t.Helper()
+	ctx := context.Background()
+	scheduleStore := memstore.NewScheduleStore()
+	eventStore := memstore.NewEventStore()
+	queues := queue.NewMemoryQueues()
+
+	schedule := &models.Schedule{Name: "hourly", RRule: "FREQ=HOURLY", CreatedAt: time.Now().UTC()}
+	if _, err := scheduleStore.Create(ctx, schedule); err != nil {
+		t.Fatalf("create schedule: %v", err)
+	}
+	event := &models.Event{
+		ScheduleID: schedule.ID,
+		RunTime:    time.Now().UTC().Truncate(time.Minute).Add(time.Hour),
+		Status:     []models.StatusEntry{{Time: time.Now().UTC(), Status: "ready_queue"}},
+		CreatedAt:  time.Now().UTC(),
+	}
+	if _, err := eventStore.Insert(ctx, event); err != nil {
+		t.Fatalf("insert event: %v", err)
+	}
+	if queued {
+		if err := queues.Schedule(ctx, queue.Item{ID: event.ID, DueAt: event.RunTime}); err != nil {
+			t.Fatalf("queue event: %v", err)
+		}
+	}
+	return scheduleStore, eventStore, queues, event
+}
+
+func TestCancelPendingEvent(t *testing.T) {
+	ctx := context.Background()
+	scheduleStore, eventStore, queues, event := newPendingEvent(t, true)
+
+	if err := cancelPendingEvent(ctx, scheduleStore, eventStore, queues, event.ScheduleID, event.ID); err != nil {
+		t.Fatalf("cancel event: %v", err)
+	}
+
+	schedule, err := scheduleStore.Get(ctx, event.ScheduleID)
+	if err != nil {
+		t.Fatalf("get schedule: %v", err)
+	}
+	if len(schedule.ExcludedRunTimes) != 1 || !schedule.ExcludedRunTimes[0].Equal(event.RunTime) {
+		t.Errorf("got excluded run times %v, want [%s]", schedule.ExcludedRunTimes, event.RunTime)
+	}
+	archived, err := eventStore.GetArchived(ctx, event.ID)
+	if err != nil {
+		t.Fatalf("cancelled event was not archived: %v", err)
+	}
+	if status := archived.Status[len(archived.Status)-1].Status; status != "cancelled" {
+		t.Errorf("got status %q, want cancelled", status)
+	}
+}
+
+func TestCancelPendingEventAlreadyDispatched(t *testing.T) {
+	ctx := context.Background()
+	scheduleStore, eventStore, queues, event := newPendingEvent(t, false)
+
+	err := cancelPendingEvent(ctx, scheduleStore, eventStore, queues, event.ScheduleID, event.ID)
+	var apiErr *ApiError
+	if !errors.As(err, &apiErr) || apiErr.Code != ErrCodeConflict {
+		t.Fatalf("got %v, want a conflict", err)
+	}
+
+	// The event still runs, so its run time must not be excluded
+	schedule, err := scheduleStore.Get(ctx, event.ScheduleID)
+	if err != nil {
+		t.Fatalf("get schedule: %v", err)
+	}
+	if len(schedule.ExcludedRunTimes) != 0 {
+		t.Errorf("got excluded run times %v, want none", schedule.ExcludedRunTimes)
+	}
+	if _, err := eventStore.Get(ctx, event.ID); errors.Is(err, store.ErrNotFound) {
+		t.Error("dispatched event is no longer pending")
+	}
+}
+
+func TestSkipOccurrence(t *testing.T) {
+	ctx := context.Background()
+	scheduleStore, eventStore, queues, event := newPendingEvent(t, true)
+
+	eventID, err := skipOccurrence(ctx, scheduleStore, eventStore, queues, event.ScheduleID, event.RunTime)
+	if err != nil {
+		t.Fatalf("skip occurrence: %v", err)
+	}
+	if eventID != event.ID {
+		t.Errorf("got cancelled event %q, want %q", eventID, event.ID)
+	}
+
+	schedule, err := scheduleStore.Get(ctx, event.ScheduleID)
+	if err != nil {
+		t.Fatalf("get schedule: %v", err)
+	}
+	if len(schedule.ExcludedRunTimes) != 1 || !schedule.ExcludedRunTimes[0].Equal(event.RunTime) {
+		t.Errorf("got excluded run times %v, want [%s]", schedule.ExcludedRunTimes, event.RunTime)
+	}
+	archived, err := eventStore.GetArchived(ctx, event.ID)
+	if err != nil {
+		t.Fatalf("cancelled event was not archived: %v", err)
+	}
+	if status := archived.Status[len(archived.Status)-1].Status; status != "cancelled" {
+		t.Errorf("got status %q, want cancelled", status)
+	}
+	if ready, _ := queues.Scheduled(ctx); len(ready) != 0 {
+		t.Errorf("ready_queue = %v, want it empty", ready)
+	}
+}
+
+func TestSkipOccurrenceWithoutEvent(t *testing.T) {
+	ctx := context.Background()
+	scheduleStore, eventStore, queues, event := newPendingEvent(t, true)
+	runTime := event.RunTime.Add(time.Hour)
+
+	eventID, err := skipOccurrence(ctx, scheduleStore, eventStore, queues, event.ScheduleID, runTime)
+	if err != nil {
+		t.Fatalf("skip occurrence: %v", err)
+	}
+	if eventID != "" {
+		t.Errorf("got cancelled event %q, want none", eventID)
+	}
+	schedule, err := scheduleStore.Get(ctx, event.ScheduleID)
+	if err != nil {
+		t.Fatalf("get schedule: %v", err)
+	}
+	if len(schedule.ExcludedRunTimes) != 1 || !schedule.ExcludedRunTimes[0].Equal(runTime) {
+		t.Errorf("got excluded run times %v, want [%s]", schedule.ExcludedRunTimes, runTime)
+	}
+	if _, err := eventStore.Get(ctx, event.ID); err != nil {
+		t.Errorf("event of another occurrence is no longer pending: %v", err)
+	}
+}
+
+func TestSkipOccurrenceAlreadyDispatched(t *testing.T) {
+	ctx := context.Background()
+	scheduleStore, eventStore, queues, event := newPendingEvent(t, false)
+
+	_, err := skipOccurrence(ctx, scheduleStore, eventStore, queues, event.ScheduleID, event.RunTime)
+	var apiErr *ApiError
+	if !errors.As(err, &apiErr) || apiErr.Code != ErrCodeConflict {
+		t.Fatalf("got %v, want a conflict", err)
+	}
+
+	// The event still runs, so its run time must not stay excluded
+	schedule, err := scheduleStore.Get(ctx, event.ScheduleID)
+	if err != nil {
+		t.Fatalf("get schedule: %v", err)
+	}
+	if len(schedule.ExcludedRunTimes) != 0 {
+		t.Errorf("got excluded run times %v, want none", schedule.ExcludedRunTimes)
+	}
+	if _, err := eventStore.Get(ctx, event.ID); err != nil {
+		t.Errorf("dispatched event is no longer pending: %v", err)
+	}
+}
+
+func TestSkipOccurrenceDuringPrequeuerRun(t *testing.T) {
+	ctx := context.Background()
+	scheduleStore, eventStore, queues, event := newPendingEvent(t, true)
+	runTime := event.RunTime.Add(time.Hour)
+	next := event.RunTime
+	if err := scheduleStore.SetWatermarks(ctx, []store.Watermark{{
+		ScheduleID: event.ScheduleID, RRule: "FREQ=HOURLY", NextRunTime: &next,
+	}}); err != nil {
+		t.Fatalf("set watermark: %v", err)
+	}
+	// A prequeuer run reads the schedule before the occurrence is skipped...
+	read, err := scheduleStore.Get(ctx, event.ScheduleID)
+	if err != nil {
+		t.Fatalf("get schedule: %v", err)
+	}
+
+	if _, err := skipOccurrence(ctx, scheduleStore, eventStore, queues, event.ScheduleID, runTime); err != nil {
+		t.Fatalf("skip occurrence: %v", err)
+	}
+
+	// ...and moves its watermark past it afterwards.
+	after := runTime.Add(time.Hour)
+	if err := scheduleStore.SetWatermarks(ctx, []store.Watermark{{
+		ScheduleID:      read.ID,
+		RRule:           read.RRule,
+		Timezone:        read.Timezone,
+		PrevNextRunTime: read.NextRunTime,
+		NextRunTime:     &after,
+		LastEventTime:   &runTime,
+	}}); err != nil {
+		t.Fatalf("set watermark: %v", err)
+	}
+	schedule, err := scheduleStore.Get(ctx, event.ScheduleID)
+	if err != nil {
+		t.Fatalf("get schedule: %v", err)
+	}
+	if schedule.NextRunTime != nil {
+		t.Errorf("got next_run_time %s, want the stale watermark dropped", schedule.NextRunTime)
+	}
+}
+
+func TestTriggerRouteOverrides(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	body := `{"headers":{"X-Run":"manual"},"body":"now"}`
+	tests := []struct {
+		name          string
+		body          io.Reader
+		contentLength int64
+		wantCode      int
+		wantOverrides bool
+	}{
+		{name: "no body", body: http.NoBody, wantCode: http.StatusAccepted},
+		{name: "empty chunked body", body: strings.NewReader(""), contentLength: -1, wantCode: http.StatusAccepted},
+		{name: "overrides", body: strings.NewReader(body), contentLength: int64(len(body)), wantCode: http.StatusAccepted, wantOverrides: true},
+		{name: "chunked overrides", body: strings.NewReader(body), contentLength: -1, wantCode: http.StatusAccepted, wantOverrides: true},
+		{name: "invalid JSON", body: strings.NewReader("{"), contentLength: -1, wantCode: http.StatusBadRequest},
+		{name: "not JSON", body: strings.NewReader("nope"), contentLength: 4, wantCode: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			scheduleStore, eventStore, queues, event := newPendingEvent(t, true)
+			r := gin.New()
+			RegisterScheduleRoutes(r, scheduleStore, eventStore, queues)
+
+			req := httptest.NewRequest(http.MethodPost, "/api/schedules/"+event.ScheduleID+"/trigger", tt.body)
+			req.ContentLength = tt.contentLength
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+			if w.Code != tt.wantCode {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.wantCode, w.Body)
+			}
+			if w.Code != http.StatusAccepted {
+				return
+			}
+
+			var resp struct {
+				EventID string `json:"event_id"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("decode response: %v", err)
+			}
+			triggered, err := eventStore.Get(context.Background(), resp.EventID)
+			if err != nil {
+				t.Fatalf("get triggered event: %v", err)
+			}
+			if !triggered.Manual {
+				t.Error("triggered event is not manual")
+			}
+			hasOverrides := triggered.Overrides != nil && triggered.Overrides.Body != nil
+			if hasOverrides != tt.wantOverrides {
+				t.Fatalf("overrides = %+v, want overrides %v", triggered.Overrides, tt.wantOverrides)
+			}
+			if tt.wantOverrides && (*triggered.Overrides.Body != "now" || triggered.Overrides.Headers["X-Run"] != "manual") {
+				t.Errorf("overrides = %+v, want the request's", triggered.Overrides)
+			}
+		})
+	}
+}
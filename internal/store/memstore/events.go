@@ -0,0 +1,320 @@
+package memstore
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/cankoe/rrule-scheduler/internal/models"
+	"github.com/cankoe/rrule-scheduler/internal/store"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// occurrenceKey identifies a pending scheduled event by schedule and run time;
+// like the unique index in MongoDB, no two pending scheduled events share one.
+// Manual events are not indexed by it.
+type occurrenceKey struct {
+	scheduleID string
+	runTime    int64
+}
+
+func keyOf(e *models.Event) occurrenceKey {
+	return occurrenceKey{scheduleID: e.ScheduleID, runTime: e.RunTime.UnixMilli()}
+}
+
+// EventStore keeps pending and archived events in memory.
+type EventStore struct {
+	mu          sync.RWMutex
+	pending     map[string]*models.Event
+	archived    map[string]*models.Event
+	occurrences map[occurrenceKey]string
+}
+
+func NewEventStore() *EventStore {
+	return &EventStore{
+		pending:     make(map[string]*models.Event),
+		archived:    make(map[string]*models.Event),
+		occurrences: make(map[occurrenceKey]string),
+	}
+}
+
+func (s *EventStore) EnsureIndexes(ctx context.Context) error {
+	return nil
+}
+
+func (s *EventStore) Insert(ctx context.Context, e *models.Event) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.isOccupied(e) {
+		return "", store.ErrDuplicate
+	}
+	e.ID = primitive.NewObjectID().Hex()
+	s.addPending(cloneEvent(e))
+	return e.ID, nil
+}
+
+func (s *EventStore) CreateIfAbsent(ctx context.Context, events []models.Event) ([]models.Event, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var created []models.Event
+	for i := range events {
+		event := events[i]
+		if s.isOccupied(&event) {
+			continue
+		}
+		event.ID = primitive.NewObjectID().Hex()
+		s.addPending(cloneEvent(&event))
+		created = append(created, event)
+	}
+	return created, nil
+}
+
+// isOccupied reports whether e is a scheduled event whose occurrence has a
+// pending event already.
+func (s *EventStore) isOccupied(e *models.Event) bool {
+	if e.Manual {
+		return false
+	}
+	_, exists := s.occurrences[keyOf(e)]
+	return exists
+}
+
+func (s *EventStore) addPending(e *models.Event) {
+	s.pending[e.ID] = e
+	if !e.Manual {
+		s.occurrences[keyOf(e)] = e.ID
+	}
+}
+
+func (s *EventStore) removePending(e *models.Event) {
+	delete(s.pending, e.ID)
+	if !e.Manual && s.occurrences[keyOf(e)] == e.ID {
+		delete(s.occurrences, keyOf(e))
+	}
+}
+
+func (s *EventStore) Get(ctx context.Context, id string) (*models.Event, error) {
+	return s.get(s.pending, id)
+}
+
+func (s *EventStore) GetArchived(ctx context.Context, id string) (*models.Event, error) {
+	return s.get(s.archived, id)
+}
+
+func (s *EventStore) get(events map[string]*models.Event, id string) (*models.Event, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	event, ok := events[id]
+	if !ok {
+		return nil, store.ErrNotFound
+	}
+	return cloneEvent(event), nil
+}
+
+func (s *EventStore) GetByRunTime(ctx context.Context, scheduleID string, runTime time.Time) (*models.Event, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	id, ok := s.occurrences[occurrenceKey{scheduleID: scheduleID, runTime: runTime.UnixMilli()}]
+	if !ok {
+		return nil, store.ErrNotFound
+	}
+	return cloneEvent(s.pending[id]), nil
+}
+
+func (s *EventStore) List(ctx context.Context, f *store.EventFilter) ([]models.Event, error) {
+	return s.list(s.pending, f), nil
+}
+
+func (s *EventStore) ListArchived(ctx context.Context, f *store.EventFilter) ([]models.Event, error) {
+	return s.list(s.archived, f), nil
+}
+
+func (s *EventStore) list(events map[string]*models.Event, f *store.EventFilter) []models.Event {
+	s.mu.RLock()
+	matches := []models.Event{}
+	for _, event := range events {
+		if matchesFilter(event, f) {
+			matches = append(matches, *cloneEvent(event))
+		}
+	}
+	s.mu.RUnlock()
+
+	sort.Slice(matches, func(i, j int) bool {
+		a, b := &matches[i], &matches[j]
+		if !a.RunTime.Equal(b.RunTime) {
+			return a.RunTime.Before(b.RunTime) == f.Ascending
+		}
+		return a.ID < b.ID == f.Ascending
+	})
+	if f.Skip >= len(matches) {
+		return []models.Event{}
+	}
+	matches = matches[f.Skip:]
+	if f.Limit > 0 && len(matches) > f.Limit {
+		matches = matches[:f.Limit]
+	}
+	return matches
+}
+
+func matchesFilter(e *models.Event, f *store.EventFilter) bool {
+	if f.ScheduleID != "" && e.ScheduleID != f.ScheduleID {
+		return false
+	}
+	if f.Status != "" && (len(e.Status) == 0 || e.Status[len(e.Status)-1].Status != f.Status) {
+		return false
+	}
+	if !f.From.IsZero() && e.RunTime.Before(f.From) {
+		return false
+	}
+	if !f.To.IsZero() && e.RunTime.After(f.To) {
+		return false
+	}
+	return true
+}
+
+func (s *EventStore) ForEach(ctx context.Context, fn func(*models.Event) error) error {
+	s.mu.RLock()
+	events := make([]*models.Event, 0, len(s.pending))
+	for _, event := range s.pending {
+		events = append(events, cloneEvent(event))
+	}
+	s.mu.RUnlock()
+
+	for _, event := range events {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := fn(event); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *EventStore) PushStatus(ctx context.Context, id string, entry models.StatusEntry) error {
+	return s.update(id, func(e *models.Event) {
+		e.Status = append(e.Status, entry)
+	})
+}
+
+func (s *EventStore) PushStatusMany(ctx context.Context, ids []string, entry models.StatusEntry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, id := range ids {
+		if event, ok := s.pending[id]; ok {
+			event.Status = append(event.Status, entry)
+		}
+	}
+	return nil
+}
+
+func (s *EventStore) RecordAttempt(ctx context.Context, id string, record models.AttemptRecord) error {
+	return s.update(id, func(e *models.Event) {
+		record.Headers = cloneMap(record.Headers)
+		e.Attempts = append(e.Attempts, record)
+	})
+}
+
+func (s *EventStore) ScheduleRetry(ctx context.Context, id string, attempt int, retryAt time.Time, entry models.StatusEntry) error {
+	return s.update(id, func(e *models.Event) {
+		e.Status = append(e.Status, entry)
+		e.AttemptCount = attempt
+		e.RetryAt = &retryAt
+	})
+}
+
+// update changes a pending event. Like an update in MongoDB that matches
+// nothing, updating a missing event is not an error.
+func (s *EventStore) update(id string, change func(*models.Event)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if event, ok := s.pending[id]; ok {
+		change(event)
+	}
+	return nil
+}
+
+func (s *EventStore) Archive(ctx context.Context, id string, entry models.StatusEntry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	event, ok := s.pending[id]
+	if !ok {
+		return store.ErrNotFound
+	}
+	if _, exists := s.archived[id]; exists {
+		return store.ErrDuplicate
+	}
+	event.Status = append(event.Status, entry)
+	s.removePending(event)
+	s.archived[id] = event
+	return nil
+}
+
+func (s *EventStore) Restore(ctx context.Context, id string, entry models.StatusEntry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	event, ok := s.archived[id]
+	if !ok {
+		return store.ErrNotFound
+	}
+	if _, exists := s.pending[id]; exists {
+		return store.ErrDuplicate
+	}
+	if s.isOccupied(event) {
+		return store.ErrDuplicate
+	}
+	delete(s.archived, id)
+	event.Status = append(event.Status, entry)
+	event.AttemptCount = 0
+	event.RetryAt = nil
+	s.addPending(event)
+	return nil
+}
+
+func (s *EventStore) DeleteBySchedule(ctx context.Context, scheduleID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, event := range s.pending {
+		if event.ScheduleID == scheduleID {
+			s.removePending(event)
+		}
+	}
+	return nil
+}
+
+// cloneEvent deep-copies an event, so callers never share state with the
+// store.
+func cloneEvent(e *models.Event) *models.Event {
+	c := *e
+	c.Status = append([]models.StatusEntry(nil), e.Status...)
+	c.Attempts = make([]models.AttemptRecord, len(e.Attempts))
+	for i, record := range e.Attempts {
+		record.Headers = cloneMap(record.Headers)
+		c.Attempts[i] = record
+	}
+	if e.Attempts == nil {
+		c.Attempts = nil
+	}
+	c.RetryAt = copyTime(e.RetryAt)
+	if e.Overrides != nil {
+		overrides := *e.Overrides
+		overrides.Headers = cloneMap(e.Overrides.Headers)
+		if e.Overrides.Body != nil {
+			body := *e.Overrides.Body
+			overrides.Body = &body
+		}
+		c.Overrides = &overrides
+	}
+	return &c
+}
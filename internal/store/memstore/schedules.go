@@ -0,0 +1,355 @@
+package memstore
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cankoe/rrule-scheduler/internal/models"
+	"github.com/cankoe/rrule-scheduler/internal/store"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ScheduleStore keeps schedules in memory. IDs are ObjectID hex strings, like
+// the ones MongoDB generates, so they sort in creation order.
+type ScheduleStore struct {
+	mu        sync.RWMutex
+	schedules map[string]*models.Schedule
+	// exhausted holds the schedules that have no occurrences left. Their
+	// next_run_time is nil like that of the ones it has to be computed for.
+	exhausted map[string]bool
+}
+
+func NewScheduleStore() *ScheduleStore {
+	return &ScheduleStore{
+		schedules: make(map[string]*models.Schedule),
+		exhausted: make(map[string]bool),
+	}
+}
+
+func (s *ScheduleStore) EnsureIndexes(ctx context.Context) error {
+	return nil
+}
+
+func (s *ScheduleStore) Create(ctx context.Context, schedule *models.Schedule) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	schedule.ID = primitive.NewObjectID().Hex()
+	s.schedules[schedule.ID] = cloneSchedule(schedule)
+	return schedule.ID, nil
+}
+
+func (s *ScheduleStore) Get(ctx context.Context, id string) (*models.Schedule, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	schedule, ok := s.schedules[id]
+	if !ok {
+		return nil, store.ErrNotFound
+	}
+	return cloneSchedule(schedule), nil
+}
+
+func (s *ScheduleStore) List(ctx context.Context, q *store.ScheduleQuery) ([]models.Schedule, error) {
+	var hostPattern *regexp.Regexp
+	if q.CallbackHost != "" {
+		hostPattern = regexp.MustCompile(`(?i)^[a-zA-Z][a-zA-Z0-9+.-]*://([^/@]*@)?` +
+			regexp.QuoteMeta(q.CallbackHost) + `(:[0-9]+)?([/?#]|$)`)
+	}
+	sortValue := func(schedule *models.Schedule) string {
+		switch q.Sort {
+		case store.SortName:
+			return schedule.Name
+		case store.SortCallbackURL:
+			return schedule.CallbackURL
+		}
+		return ""
+	}
+	// less orders by the sort field, then by ID (i.e. creation)
+	less := func(aValue, aID, bValue, bID string) bool {
+		if aValue != bValue {
+			return aValue < bValue != q.Descending
+		}
+		if aID == bID {
+			return false
+		}
+		return aID < bID != q.Descending
+	}
+
+	s.mu.RLock()
+	schedules := []models.Schedule{}
+	for _, schedule := range s.schedules {
+		if !matchesQuery(schedule, q, hostPattern) {
+			continue
+		}
+		if q.After != nil && !less(q.After.Value, q.After.ID, sortValue(schedule), schedule.ID) {
+			continue
+		}
+		schedules = append(schedules, *cloneSchedule(schedule))
+	}
+	s.mu.RUnlock()
+
+	sort.Slice(schedules, func(i, j int) bool {
+		return less(sortValue(&schedules[i]), schedules[i].ID, sortValue(&schedules[j]), schedules[j].ID)
+	})
+	if q.Limit > 0 && len(schedules) > q.Limit {
+		schedules = schedules[:q.Limit]
+	}
+	return schedules, nil
+}
+
+func matchesQuery(schedule *models.Schedule, q *store.ScheduleQuery, hostPattern *regexp.Regexp) bool {
+	if q.NamePrefix != "" && !strings.HasPrefix(schedule.Name, q.NamePrefix) {
+		return false
+	}
+	if hostPattern != nil && !hostPattern.MatchString(schedule.CallbackURL) {
+		return false
+	}
+	if q.Method != "" && !strings.EqualFold(schedule.Method, q.Method) &&
+		!(q.Method == http.MethodGet && schedule.Method == "") {
+		return false
+	}
+	switch q.State {
+	case models.ScheduleStatePaused:
+		if schedule.State != models.ScheduleStatePaused {
+			return false
+		}
+	case models.ScheduleStateActive:
+		if schedule.State == models.ScheduleStatePaused {
+			return false
+		}
+	}
+	for key, value := range q.Labels {
+		if actual, ok := schedule.Labels[key]; !ok || actual != value {
+			return false
+		}
+	}
+	if !q.CreatedAfter.IsZero() && schedule.ID < primitive.NewObjectIDFromTimestamp(q.CreatedAfter).Hex() {
+		return false
+	}
+	if !q.CreatedBefore.IsZero() && schedule.ID >= primitive.NewObjectIDFromTimestamp(q.CreatedBefore).Hex() {
+		return false
+	}
+	return true
+}
+
+func (s *ScheduleStore) Update(ctx context.Context, schedule *models.Schedule, fields []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, ok := s.schedules[schedule.ID]
+	if !ok {
+		return store.ErrNotFound
+	}
+
+	// Copy the fields by their JSON name; fields that are empty now are removed
+	var src, dst map[string]json.RawMessage
+	if err := roundTrip(schedule, &src); err != nil {
+		return err
+	}
+	if err := roundTrip(stored, &dst); err != nil {
+		return err
+	}
+	for _, field := range fields {
+		if value, ok := src[field]; ok {
+			dst[field] = value
+		} else {
+			delete(dst, field)
+		}
+	}
+	var updated models.Schedule
+	if err := roundTrip(dst, &updated); err != nil {
+		return err
+	}
+	updated.ID = stored.ID
+	updated.NextRunTime = nil
+	s.schedules[stored.ID] = &updated
+	delete(s.exhausted, stored.ID)
+	return nil
+}
+
+func roundTrip(from, to interface{}) error {
+	raw, err := json.Marshal(from)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(raw, to)
+}
+
+func (s *ScheduleStore) Delete(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.schedules[id]; !ok {
+		return store.ErrNotFound
+	}
+	delete(s.schedules, id)
+	delete(s.exhausted, id)
+	return nil
+}
+
+func (s *ScheduleStore) Pause(ctx context.Context, id string, at time.Time) error {
+	return s.update(id, func(schedule *models.Schedule) {
+		schedule.State = models.ScheduleStatePaused
+		schedule.PausedAt = &at
+	})
+}
+
+func (s *ScheduleStore) Resume(ctx context.Context, id string) error {
+	return s.update(id, func(schedule *models.Schedule) {
+		schedule.State = models.ScheduleStateActive
+		schedule.PausedAt = nil
+		schedule.NextRunTime = nil
+	})
+}
+
+func (s *ScheduleStore) ExcludeRunTime(ctx context.Context, id string, runTime time.Time) error {
+	return s.update(id, func(schedule *models.Schedule) {
+		for _, excluded := range schedule.ExcludedRunTimes {
+			if excluded.Equal(runTime) {
+				return
+			}
+		}
+		schedule.ExcludedRunTimes = append(schedule.ExcludedRunTimes, runTime.UTC())
+		schedule.NextRunTime = nil
+	})
+}
+
+func (s *ScheduleStore) IncludeRunTime(ctx context.Context, id string, runTime time.Time) error {
+	return s.update(id, func(schedule *models.Schedule) {
+		kept := schedule.ExcludedRunTimes[:0]
+		for _, excluded := range schedule.ExcludedRunTimes {
+			if !excluded.Equal(runTime) {
+				kept = append(kept, excluded)
+			}
+		}
+		schedule.ExcludedRunTimes = kept
+		schedule.NextRunTime = nil
+	})
+}
+
+// update applies change to a schedule. Changed schedules are no longer
+// considered exhausted.
+func (s *ScheduleStore) update(id string, change func(*models.Schedule)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	schedule, ok := s.schedules[id]
+	if !ok {
+		return store.ErrNotFound
+	}
+	change(schedule)
+	delete(s.exhausted, id)
+	return nil
+}
+
+func (s *ScheduleStore) ForEachDue(ctx context.Context, before time.Time, fn func(*models.Schedule) error) error {
+	s.mu.RLock()
+	due := []*models.Schedule{}
+	for _, schedule := range s.schedules {
+		if schedule.State == models.ScheduleStatePaused {
+			continue
+		}
+		if schedule.NextRunTime == nil && !s.exhausted[schedule.ID] ||
+			schedule.NextRunTime != nil && schedule.NextRunTime.Before(before) {
+			due = append(due, cloneSchedule(schedule))
+		}
+	}
+	s.mu.RUnlock()
+
+	sort.Slice(due, func(i, j int) bool { return due[i].ID < due[j].ID })
+	for _, schedule := range due {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := fn(schedule); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *ScheduleStore) SetWatermarks(ctx context.Context, watermarks []store.Watermark) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, w := range watermarks {
+		schedule, ok := s.schedules[w.ScheduleID]
+		if !ok || s.exhausted[w.ScheduleID] || schedule.RRule != w.RRule || schedule.Timezone != w.Timezone ||
+			!sameTime(schedule.NextRunTime, w.PrevNextRunTime) {
+			continue
+		}
+		schedule.NextRunTime = copyTime(w.NextRunTime)
+		if w.NextRunTime == nil {
+			s.exhausted[w.ScheduleID] = true
+		}
+		if w.LastEventTime != nil {
+			schedule.LastEventTime = copyTime(w.LastEventTime)
+		}
+		if !w.PruneExcludedBefore.IsZero() {
+			kept := schedule.ExcludedRunTimes[:0]
+			for _, excluded := range schedule.ExcludedRunTimes {
+				if !excluded.Before(w.PruneExcludedBefore) {
+					kept = append(kept, excluded)
+				}
+			}
+			schedule.ExcludedRunTimes = kept
+		}
+	}
+	return nil
+}
+
+func sameTime(a, b *time.Time) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Equal(*b)
+}
+
+func copyTime(t *time.Time) *time.Time {
+	if t == nil {
+		return nil
+	}
+	c := *t
+	return &c
+}
+
+// cloneSchedule deep-copies a schedule, so callers never share state with
+// the store.
+func cloneSchedule(s *models.Schedule) *models.Schedule {
+	c := *s
+	c.Headers = cloneMap(s.Headers)
+	c.Labels = cloneMap(s.Labels)
+	if s.SuccessCriteria != nil {
+		criteria := *s.SuccessCriteria
+		criteria.StatusCodes = append([]string(nil), s.SuccessCriteria.StatusCodes...)
+		c.SuccessCriteria = &criteria
+	}
+	if s.RetryPolicy != nil {
+		policy := *s.RetryPolicy
+		c.RetryPolicy = &policy
+	}
+	c.ExcludedRunTimes = append([]time.Time(nil), s.ExcludedRunTimes...)
+	c.PausedAt = copyTime(s.PausedAt)
+	c.NextRunTime = copyTime(s.NextRunTime)
+	c.LastEventTime = copyTime(s.LastEventTime)
+	return &c
+}
+
+func cloneMap(m map[string]string) map[string]string {
+	if m == nil {
+		return nil
+	}
+	c := make(map[string]string, len(m))
+	for k, v := range m {
+		c[k] = v
+	}
+	return c
+}
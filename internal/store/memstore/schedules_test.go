@@ -0,0 +1,264 @@
+package memstore
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/cankoe/rrule-scheduler/internal/models"
+	"github.com/cankoe/rrule-scheduler/internal/store"
+)
+
+// watermarkFor returns a watermark for the schedule as it was read.
+func watermarkFor(schedule *models.Schedule, next time.Time) store.Watermark {
+	return store.Watermark{
+		ScheduleID:      schedule.ID,
+		RRule:           schedule.RRule,
+		Timezone:        schedule.Timezone,
+		PrevNextRunTime: schedule.NextRunTime,
+		NextRunTime:     &next,
+		LastEventTime:   &next,
+	}
+}
+
+func TestSetWatermarks(t *testing.T) {
+	ctx := context.Background()
+	schedules := NewScheduleStore()
+	now := time.Now().UTC().Truncate(time.Second)
+	schedule := &models.Schedule{
+		Name:             "hourly",
+		RRule:            "FREQ=HOURLY",
+		ExcludedRunTimes: []time.Time{now.Add(-time.Hour), now.Add(time.Hour)},
+	}
+	if _, err := schedules.Create(ctx, schedule); err != nil {
+		t.Fatalf("create schedule: %v", err)
+	}
+
+	w := watermarkFor(schedule, now.Add(2*time.Hour))
+	w.PruneExcludedBefore = now
+	if err := schedules.SetWatermarks(ctx, []store.Watermark{w}); err != nil {
+		t.Fatalf("set watermarks: %v", err)
+	}
+
+	stored, err := schedules.Get(ctx, schedule.ID)
+	if err != nil {
+		t.Fatalf("get schedule: %v", err)
+	}
+	if stored.NextRunTime == nil || !stored.NextRunTime.Equal(now.Add(2*time.Hour)) {
+		t.Errorf("got next_run_time %v, want %s", stored.NextRunTime, now.Add(2*time.Hour))
+	}
+	if len(stored.ExcludedRunTimes) != 1 || !stored.ExcludedRunTimes[0].Equal(now.Add(time.Hour)) {
+		t.Errorf("got excluded run times %v, want only the future one", stored.ExcludedRunTimes)
+	}
+}
+
+func TestSetWatermarksSkipsChangedSchedules(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now().UTC().Truncate(time.Second)
+
+	tests := []struct {
+		name   string
+		change func(t *testing.T, schedules *ScheduleStore, schedule *models.Schedule)
+	}{
+		{
+			name: "rrule updated",
+			change: func(t *testing.T, schedules *ScheduleStore, schedule *models.Schedule) {
+				updated := *schedule
+				updated.RRule = "FREQ=DAILY"
+				if err := schedules.Update(ctx, &updated, []string{"rrule"}); err != nil {
+					t.Fatalf("update schedule: %v", err)
+				}
+			},
+		},
+		{
+			name: "timezone updated",
+			change: func(t *testing.T, schedules *ScheduleStore, schedule *models.Schedule) {
+				updated := *schedule
+				updated.Timezone = "Europe/Berlin"
+				if err := schedules.Update(ctx, &updated, []string{"timezone"}); err != nil {
+					t.Fatalf("update schedule: %v", err)
+				}
+			},
+		},
+		{
+			name: "resumed",
+			change: func(t *testing.T, schedules *ScheduleStore, schedule *models.Schedule) {
+				if err := schedules.Resume(ctx, schedule.ID); err != nil {
+					t.Fatalf("resume schedule: %v", err)
+				}
+			},
+		},
+		{
+			name: "run time excluded",
+			change: func(t *testing.T, schedules *ScheduleStore, schedule *models.Schedule) {
+				if err := schedules.ExcludeRunTime(ctx, schedule.ID, now.Add(time.Hour)); err != nil {
+					t.Fatalf("exclude run time: %v", err)
+				}
+			},
+		},
+		{
+			name: "advanced by another prequeuer",
+			change: func(t *testing.T, schedules *ScheduleStore, schedule *models.Schedule) {
+				w := watermarkFor(schedule, now.Add(3*time.Hour))
+				if err := schedules.SetWatermarks(ctx, []store.Watermark{w}); err != nil {
+					t.Fatalf("set watermarks: %v", err)
+				}
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			schedules := NewScheduleStore()
+			next := now.Add(time.Hour)
+			schedule := &models.Schedule{Name: "hourly", RRule: "FREQ=HOURLY", NextRunTime: &next}
+			if _, err := schedules.Create(ctx, schedule); err != nil {
+				t.Fatalf("create schedule: %v", err)
+			}
+			read, err := schedules.Get(ctx, schedule.ID)
+			if err != nil {
+				t.Fatalf("get schedule: %v", err)
+			}
+
+			tt.change(t, schedules, read)
+			changed, err := schedules.Get(ctx, schedule.ID)
+			if err != nil {
+				t.Fatalf("get schedule: %v", err)
+			}
+
+			// The watermark computed from the schedule as it was read
+			if err := schedules.SetWatermarks(ctx, []store.Watermark{watermarkFor(read, now.Add(2*time.Hour))}); err != nil {
+				t.Fatalf("set watermarks: %v", err)
+			}
+			stored, err := schedules.Get(ctx, schedule.ID)
+			if err != nil {
+				t.Fatalf("get schedule: %v", err)
+			}
+			if (stored.NextRunTime == nil) != (changed.NextRunTime == nil) ||
+				stored.NextRunTime != nil && !stored.NextRunTime.Equal(*changed.NextRunTime) {
+				t.Errorf("got next_run_time %v, want %v", stored.NextRunTime, changed.NextRunTime)
+			}
+		})
+	}
+}
+
+func TestIncludeRunTimeUndoesExcludeRunTime(t *testing.T) {
+	ctx := context.Background()
+	schedules := NewScheduleStore()
+	now := time.Now().UTC().Truncate(time.Second)
+	next := now.Add(time.Hour)
+	schedule := &models.Schedule{
+		Name:             "hourly",
+		RRule:            "FREQ=HOURLY",
+		NextRunTime:      &next,
+		ExcludedRunTimes: []time.Time{now.Add(3 * time.Hour)},
+	}
+	if _, err := schedules.Create(ctx, schedule); err != nil {
+		t.Fatalf("create schedule: %v", err)
+	}
+
+	if err := schedules.ExcludeRunTime(ctx, schedule.ID, now.Add(2*time.Hour)); err != nil {
+		t.Fatalf("exclude run time: %v", err)
+	}
+	if err := schedules.IncludeRunTime(ctx, schedule.ID, now.Add(2*time.Hour)); err != nil {
+		t.Fatalf("include run time: %v", err)
+	}
+	stored, err := schedules.Get(ctx, schedule.ID)
+	if err != nil {
+		t.Fatalf("get schedule: %v", err)
+	}
+	if len(stored.ExcludedRunTimes) != 1 || !stored.ExcludedRunTimes[0].Equal(now.Add(3*time.Hour)) {
+		t.Errorf("got excluded run times %v, want only the earlier exclusion", stored.ExcludedRunTimes)
+	}
+	if stored.NextRunTime != nil {
+		t.Errorf("got next_run_time %s, want it cleared", stored.NextRunTime)
+	}
+
+	if err := schedules.IncludeRunTime(ctx, "missing", now); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("got %v for a missing schedule, want ErrNotFound", err)
+	}
+}
+
+func TestListContinuesPastCursor(t *testing.T) {
+	ctx := context.Background()
+	schedules := NewScheduleStore()
+	for _, name := range []string{"a", "b", "b", "c"} {
+		if _, err := schedules.Create(ctx, &models.Schedule{Name: name}); err != nil {
+			t.Fatalf("create schedule: %v", err)
+		}
+	}
+
+	for _, descending := range []bool{false, true} {
+		q := &store.ScheduleQuery{Sort: store.SortName, Descending: descending, Limit: 1}
+		seen := map[string]bool{}
+		for page := 0; page < 4; page++ {
+			found, err := schedules.List(ctx, q)
+			if err != nil {
+				t.Fatalf("list: %v", err)
+			}
+			if len(found) != 1 {
+				t.Fatalf("descending %v: got %d schedules on page %d, want 1", descending, len(found), page)
+			}
+			if seen[found[0].ID] {
+				t.Fatalf("descending %v: schedule %s listed twice", descending, found[0].ID)
+			}
+			seen[found[0].ID] = true
+			q.After = &store.ScheduleCursor{Value: found[0].Name, ID: found[0].ID}
+		}
+		if found, _ := schedules.List(ctx, q); len(found) != 0 {
+			t.Errorf("descending %v: got %d schedules past the last one", descending, len(found))
+		}
+	}
+}
+
+func TestForEachDueSkipsExhaustedSchedules(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now().UTC().Truncate(time.Second)
+
+	tests := []struct {
+		name   string
+		change func(s *ScheduleStore, schedule *models.Schedule) error
+		want   bool
+	}{
+		{"unchanged", func(s *ScheduleStore, schedule *models.Schedule) error { return nil }, false},
+		{"updated", func(s *ScheduleStore, schedule *models.Schedule) error {
+			changed := *schedule
+			changed.RRule = "FREQ=HOURLY"
+			return s.Update(ctx, &changed, []string{"rrule"})
+		}, true},
+		{"resumed", func(s *ScheduleStore, schedule *models.Schedule) error {
+			return s.Resume(ctx, schedule.ID)
+		}, true},
+		{"run time excluded", func(s *ScheduleStore, schedule *models.Schedule) error {
+			return s.ExcludeRunTime(ctx, schedule.ID, now)
+		}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			schedules := NewScheduleStore()
+			schedule := &models.Schedule{Name: "once", RRule: "FREQ=HOURLY;COUNT=1"}
+			if _, err := schedules.Create(ctx, schedule); err != nil {
+				t.Fatalf("create schedule: %v", err)
+			}
+			w := watermarkFor(schedule, now)
+			w.NextRunTime = nil
+			if err := schedules.SetWatermarks(ctx, []store.Watermark{w}); err != nil {
+				t.Fatalf("set watermarks: %v", err)
+			}
+			if err := tt.change(schedules, schedule); err != nil {
+				t.Fatalf("change schedule: %v", err)
+			}
+
+			due := false
+			if err := schedules.ForEachDue(ctx, now, func(*models.Schedule) error {
+				due = true
+				return nil
+			}); err != nil {
+				t.Fatalf("for each due: %v", err)
+			}
+			if due != tt.want {
+				t.Errorf("got due %v, want %v", due, tt.want)
+			}
+		})
+	}
+}
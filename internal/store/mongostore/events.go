@@ -0,0 +1,455 @@
+package mongostore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/cankoe/rrule-scheduler/internal/models"
+	"github.com/cankoe/rrule-scheduler/internal/store"
+
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// EventStore keeps pending events in the "events" collection and finished
+// ones in "archived_events".
+type EventStore struct {
+	events, archived *mongo.Collection
+}
+
+func NewEventStore(db *mongo.Database) *EventStore {
+	return &EventStore{
+		events:   db.Collection("events"),
+		archived: db.Collection("archived_events"),
+	}
+}
+
+// scheduledEventsIndex is the unique (schedule_id, run_time) index. It only
+// covers scheduled events, so a manual run at the time of an occurrence does
+// not keep the occurrence from being generated.
+const scheduledEventsIndex = "schedule_id_1_run_time_1_scheduled"
+
+// EnsureIndexes creates the unique (schedule_id, run_time) index that makes
+// event generation idempotent across several prequeuer replicas, and the
+// run_time index used by the listings.
+func (s *EventStore) EnsureIndexes(ctx context.Context) error {
+	if _, err := s.events.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "run_time", Value: 1}},
+	}); err != nil {
+		return fmt.Errorf("failed to create index on events.run_time: %w", err)
+	}
+
+	// Events used to leave manual out when false, and the partial index only
+	// covers events that store it.
+	if _, err := s.events.UpdateMany(ctx,
+		bson.M{"manual": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"manual": false}},
+	); err != nil {
+		return fmt.Errorf("failed to backfill events.manual: %w", err)
+	}
+	indexed, err := s.hasIndex(ctx, scheduledEventsIndex)
+	if err != nil {
+		return fmt.Errorf("failed to list indexes on events: %w", err)
+	}
+	if !indexed {
+		if err := s.archiveDuplicateEvents(ctx); err != nil {
+			return fmt.Errorf("failed to archive duplicate events before creating the unique index: %w", err)
+		}
+	}
+	// Replaced by scheduledEventsIndex, which leaves manual events out
+	if _, err := s.events.Indexes().DropOne(ctx, "schedule_id_1_run_time_1"); err != nil && !isMissingIndex(err) {
+		return fmt.Errorf("failed to drop unique index on events.schedule_id/run_time: %w", err)
+	}
+	if _, err := s.events.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "schedule_id", Value: 1}, {Key: "run_time", Value: 1}},
+		Options: options.Index().
+			SetName(scheduledEventsIndex).
+			SetUnique(true).
+			SetPartialFilterExpression(bson.M{"manual": false}),
+	}); err != nil {
+		return fmt.Errorf("failed to create unique index on events.schedule_id/run_time: %w", err)
+	}
+	return nil
+}
+
+// hasIndex reports whether the events collection has an index by that name.
+func (s *EventStore) hasIndex(ctx context.Context, name string) (bool, error) {
+	specs, err := s.events.Indexes().ListSpecifications(ctx)
+	if err != nil {
+		return false, err
+	}
+	for _, spec := range specs {
+		if spec.Name == name {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// archiveDuplicateEvents archives all but the oldest pending scheduled event
+// of every (schedule_id, run_time), which events created before the unique
+// index existed can share. Creating the index would fail on them otherwise.
+// Their queue entries are removed by the reconciler.
+func (s *EventStore) archiveDuplicateEvents(ctx context.Context) error {
+	cursor, err := s.events.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"manual": false}}},
+		{{Key: "$sort", Value: bson.M{"_id": 1}}},
+		{{Key: "$group", Value: bson.M{
+			"_id":   bson.M{"schedule_id": "$schedule_id", "run_time": "$run_time"},
+			"ids":   bson.M{"$push": "$_id"},
+			"count": bson.M{"$sum": 1},
+		}}},
+		{{Key: "$match", Value: bson.M{"count": bson.M{"$gt": 1}}}},
+	}, options.Aggregate().SetAllowDiskUse(true))
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	var duplicates []primitive.ObjectID
+	var kept []primitive.ObjectID
+	for cursor.Next(ctx) {
+		var group struct {
+			IDs []primitive.ObjectID `bson:"ids"`
+		}
+		if err := cursor.Decode(&group); err != nil {
+			return err
+		}
+		for _, oid := range group.IDs[1:] {
+			duplicates = append(duplicates, oid)
+			kept = append(kept, group.IDs[0])
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return err
+	}
+
+	for i, oid := range duplicates {
+		entry := models.StatusEntry{
+			Time:    time.Now().UTC(),
+			Status:  "cancelled",
+			Message: "Duplicate of event " + kept[i].Hex() + ", archived when the unique index on events was created",
+		}
+		err := moveEvent(ctx, s.events, s.archived, oid, func(eventDoc bson.M) {
+			status, _ := eventDoc["status"].(bson.A)
+			eventDoc["status"] = append(status, entry)
+		})
+		if err != nil && !errors.Is(err, store.ErrNotFound) {
+			return fmt.Errorf("event %s: %w", oid.Hex(), err)
+		}
+	}
+	if len(duplicates) > 0 {
+		log.Warn().Int("count", len(duplicates)).Msg("Archived duplicate scheduled events")
+	}
+	return nil
+}
+
+// isMissingIndex reports whether dropping an index failed because the index
+// or its collection does not exist.
+func isMissingIndex(err error) bool {
+	var cmdErr mongo.CommandError
+	return errors.As(err, &cmdErr) && (cmdErr.Code == 26 || cmdErr.Code == 27)
+}
+
+func (s *EventStore) Insert(ctx context.Context, e *models.Event) (string, error) {
+	e.ID = ""
+	res, err := s.events.InsertOne(ctx, e)
+	if mongo.IsDuplicateKeyError(err) {
+		return "", store.ErrDuplicate
+	}
+	if err != nil {
+		return "", err
+	}
+	oid, ok := res.InsertedID.(primitive.ObjectID)
+	if !ok {
+		return "", errors.New("inserted ID is not an ObjectID")
+	}
+	e.ID = oid.Hex()
+	return e.ID, nil
+}
+
+// CreateIfAbsent upserts the events on (schedule_id, run_time), so concurrent
+// prequeuers never create the same occurrence twice.
+func (s *EventStore) CreateIfAbsent(ctx context.Context, events []models.Event) ([]models.Event, error) {
+	if len(events) == 0 {
+		return nil, nil
+	}
+	writes := make([]mongo.WriteModel, 0, len(events))
+	for i := range events {
+		events[i].ID = ""
+		writes = append(writes, mongo.NewUpdateOneModel().
+			SetFilter(bson.M{
+				"schedule_id": events[i].ScheduleID,
+				"run_time":    events[i].RunTime,
+				"manual":      bson.M{"$ne": true},
+			}).
+			SetUpdate(bson.M{"$setOnInsert": events[i]}).
+			SetUpsert(true))
+	}
+
+	result, err := s.events.BulkWrite(ctx, writes, options.BulkWrite().SetOrdered(false))
+	if err != nil && onlyDuplicateKeyErrors(err) {
+		err = nil
+	}
+	var created []models.Event
+	if result != nil {
+		for index, id := range result.UpsertedIDs {
+			oid, isOID := id.(primitive.ObjectID)
+			if !isOID {
+				continue
+			}
+			event := events[index]
+			event.ID = oid.Hex()
+			created = append(created, event)
+		}
+	}
+	return created, err
+}
+
+// onlyDuplicateKeyErrors reports whether a bulk write only failed because a
+// concurrent prequeuer inserted the same events first.
+func onlyDuplicateKeyErrors(err error) bool {
+	var bulkErr mongo.BulkWriteException
+	if !errors.As(err, &bulkErr) || bulkErr.WriteConcernError != nil {
+		return false
+	}
+	for _, writeErr := range bulkErr.WriteErrors {
+		if writeErr.Code != 11000 {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *EventStore) Get(ctx context.Context, id string) (*models.Event, error) {
+	return findEvent(ctx, s.events, id)
+}
+
+func (s *EventStore) GetArchived(ctx context.Context, id string) (*models.Event, error) {
+	return findEvent(ctx, s.archived, id)
+}
+
+func findEvent(ctx context.Context, col *mongo.Collection, id string) (*models.Event, error) {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, store.ErrNotFound
+	}
+	return findOneEvent(ctx, col, bson.M{"_id": oid})
+}
+
+func findOneEvent(ctx context.Context, col *mongo.Collection, filter bson.M) (*models.Event, error) {
+	var event models.Event
+	if err := col.FindOne(ctx, filter).Decode(&event); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, store.ErrNotFound
+		}
+		return nil, err
+	}
+	return &event, nil
+}
+
+func (s *EventStore) GetByRunTime(ctx context.Context, scheduleID string, runTime time.Time) (*models.Event, error) {
+	return findOneEvent(ctx, s.events, bson.M{
+		"schedule_id": scheduleID,
+		"run_time":    runTime,
+		"manual":      bson.M{"$ne": true},
+	})
+}
+
+func (s *EventStore) List(ctx context.Context, f *store.EventFilter) ([]models.Event, error) {
+	return listEvents(ctx, s.events, f)
+}
+
+func (s *EventStore) ListArchived(ctx context.Context, f *store.EventFilter) ([]models.Event, error) {
+	return listEvents(ctx, s.archived, f)
+}
+
+func listEvents(ctx context.Context, col *mongo.Collection, f *store.EventFilter) ([]models.Event, error) {
+	conditions := bson.A{}
+	if f.ScheduleID != "" {
+		conditions = append(conditions, bson.M{"schedule_id": f.ScheduleID})
+	}
+	if f.Status != "" {
+		// Matches the latest status entry only
+		conditions = append(conditions, bson.M{"$expr": bson.M{"$eq": bson.A{
+			bson.M{"$arrayElemAt": bson.A{"$status.status", -1}},
+			f.Status,
+		}}})
+	}
+	runTime := bson.M{}
+	if !f.From.IsZero() {
+		runTime["$gte"] = f.From.UTC()
+	}
+	if !f.To.IsZero() {
+		runTime["$lte"] = f.To.UTC()
+	}
+	if len(runTime) > 0 {
+		conditions = append(conditions, bson.M{"run_time": runTime})
+	}
+	filter := bson.M{}
+	if len(conditions) > 0 {
+		filter["$and"] = conditions
+	}
+
+	order := -1
+	if f.Ascending {
+		order = 1
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "run_time", Value: order}, {Key: "_id", Value: order}})
+	if f.Skip > 0 {
+		opts.SetSkip(int64(f.Skip))
+	}
+	if f.Limit > 0 {
+		opts.SetLimit(int64(f.Limit))
+	}
+
+	cursor, err := col.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	events := []models.Event{}
+	if err := cursor.All(ctx, &events); err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+func (s *EventStore) ForEach(ctx context.Context, fn func(*models.Event) error) error {
+	cursor, err := s.events.Find(ctx, bson.M{})
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var event models.Event
+		if err := cursor.Decode(&event); err != nil {
+			return err
+		}
+		if err := fn(&event); err != nil {
+			return err
+		}
+	}
+	return cursor.Err()
+}
+
+func (s *EventStore) PushStatus(ctx context.Context, id string, entry models.StatusEntry) error {
+	return s.pushStatusAndSet(ctx, id, entry, nil)
+}
+
+func (s *EventStore) PushStatusMany(ctx context.Context, ids []string, entry models.StatusEntry) error {
+	objectIDs := make([]primitive.ObjectID, 0, len(ids))
+	for _, id := range ids {
+		if oid, err := primitive.ObjectIDFromHex(id); err == nil {
+			objectIDs = append(objectIDs, oid)
+		}
+	}
+	if len(objectIDs) == 0 {
+		return nil
+	}
+	_, err := s.events.UpdateMany(ctx,
+		bson.M{"_id": bson.M{"$in": objectIDs}},
+		bson.M{"$push": bson.M{"status": entry}},
+	)
+	return err
+}
+
+func (s *EventStore) RecordAttempt(ctx context.Context, id string, record models.AttemptRecord) error {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return store.ErrNotFound
+	}
+	_, err = s.events.UpdateOne(ctx,
+		bson.M{"_id": oid},
+		bson.M{"$push": bson.M{"attempts": record}},
+	)
+	return err
+}
+
+func (s *EventStore) ScheduleRetry(ctx context.Context, id string, attempt int, retryAt time.Time, entry models.StatusEntry) error {
+	return s.pushStatusAndSet(ctx, id, entry, bson.M{
+		"attempt_count": attempt,
+		"retry_at":      retryAt,
+	})
+}
+
+func (s *EventStore) pushStatusAndSet(ctx context.Context, id string, entry models.StatusEntry, fields bson.M) error {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return store.ErrNotFound
+	}
+	update := bson.M{"$push": bson.M{"status": entry}}
+	if len(fields) > 0 {
+		update["$set"] = fields
+	}
+	_, err = s.events.UpdateOne(ctx, bson.M{"_id": oid}, update)
+	return err
+}
+
+func (s *EventStore) Archive(ctx context.Context, id string, entry models.StatusEntry) error {
+	if err := s.PushStatus(ctx, id, entry); err != nil {
+		return err
+	}
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return store.ErrNotFound
+	}
+	return moveEvent(ctx, s.events, s.archived, oid, nil)
+}
+
+func (s *EventStore) Restore(ctx context.Context, id string, entry models.StatusEntry) error {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return store.ErrNotFound
+	}
+	return moveEvent(ctx, s.archived, s.events, oid, func(eventDoc bson.M) {
+		status, _ := eventDoc["status"].(bson.A)
+		eventDoc["status"] = append(status, entry)
+		delete(eventDoc, "attempt_count")
+		delete(eventDoc, "retry_at")
+	})
+}
+
+// moveEvent copies the event from one collection to the other, optionally
+// changing it on the way, and deletes the original. The copy is rolled back
+// if the original cannot be deleted.
+func moveEvent(ctx context.Context, from, to *mongo.Collection, oid primitive.ObjectID, change func(bson.M)) error {
+	var eventDoc bson.M
+	if err := from.FindOne(ctx, bson.M{"_id": oid}).Decode(&eventDoc); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return store.ErrNotFound
+		}
+		return err
+	}
+	if change != nil {
+		change(eventDoc)
+	}
+
+	if _, err := to.InsertOne(ctx, eventDoc); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return store.ErrDuplicate
+		}
+		return err
+	}
+
+	if _, err := from.DeleteOne(ctx, bson.M{"_id": oid}); err != nil {
+		// Attempt rollback
+		if _, rbErr := to.DeleteOne(ctx, bson.M{"_id": oid}); rbErr != nil {
+			log.Error().Err(rbErr).Str("event_id", oid.Hex()).Msg("Rollback failed after deleteOne error")
+		}
+		return err
+	}
+	return nil
+}
+
+func (s *EventStore) DeleteBySchedule(ctx context.Context, scheduleID string) error {
+	_, err := s.events.DeleteMany(ctx, bson.M{"schedule_id": scheduleID})
+	return err
+}
@@ -0,0 +1,330 @@
+package mongostore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/cankoe/rrule-scheduler/internal/models"
+	"github.com/cankoe/rrule-scheduler/internal/store"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// sortFields maps the sort keys to document fields. Creation time is sorted
+// by _id, whose ObjectID embeds the creation timestamp.
+var sortFields = map[string]string{
+	store.SortCreatedAt:   "_id",
+	store.SortName:        "name",
+	store.SortCallbackURL: "callback_url",
+}
+
+// ScheduleStore keeps schedules in the "schedules" collection.
+type ScheduleStore struct {
+	col *mongo.Collection
+}
+
+func NewScheduleStore(db *mongo.Database) *ScheduleStore {
+	return &ScheduleStore{col: db.Collection("schedules")}
+}
+
+// EnsureIndexes creates the next_run_time index used to find the schedules
+// that are due.
+func (s *ScheduleStore) EnsureIndexes(ctx context.Context) error {
+	if _, err := s.col.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "next_run_time", Value: 1}},
+	}); err != nil {
+		return fmt.Errorf("failed to create index on schedules.next_run_time: %w", err)
+	}
+	return nil
+}
+
+func (s *ScheduleStore) Create(ctx context.Context, schedule *models.Schedule) (string, error) {
+	schedule.ID = ""
+	res, err := s.col.InsertOne(ctx, schedule)
+	if err != nil {
+		return "", err
+	}
+	oid, ok := res.InsertedID.(primitive.ObjectID)
+	if !ok {
+		return "", errors.New("inserted ID is not an ObjectID")
+	}
+	schedule.ID = oid.Hex()
+	return schedule.ID, nil
+}
+
+func (s *ScheduleStore) Get(ctx context.Context, id string) (*models.Schedule, error) {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, store.ErrNotFound
+	}
+	var schedule models.Schedule
+	if err := s.col.FindOne(ctx, bson.M{"_id": oid}).Decode(&schedule); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, store.ErrNotFound
+		}
+		return nil, err
+	}
+	schedule.ID = oid.Hex()
+	return &schedule, nil
+}
+
+func (s *ScheduleStore) List(ctx context.Context, q *store.ScheduleQuery) ([]models.Schedule, error) {
+	field, ok := sortFields[q.Sort]
+	if !ok {
+		field = "_id"
+	}
+	order := 1
+	if q.Descending {
+		order = -1
+	}
+	sort := bson.D{{Key: field, Value: order}}
+	if field != "_id" {
+		sort = append(sort, bson.E{Key: "_id", Value: order})
+	}
+	opts := options.Find().SetSort(sort)
+	if q.Limit > 0 {
+		opts.SetLimit(int64(q.Limit))
+	}
+
+	filter, err := buildListFilter(q, field)
+	if err != nil {
+		return nil, err
+	}
+	cursor, err := s.col.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	schedules := []models.Schedule{}
+	if err := cursor.All(ctx, &schedules); err != nil {
+		return nil, err
+	}
+	return schedules, nil
+}
+
+func buildListFilter(q *store.ScheduleQuery, field string) (bson.M, error) {
+	conditions := bson.A{}
+
+	if q.NamePrefix != "" {
+		conditions = append(conditions, bson.M{"name": bson.M{"$regex": "^" + regexp.QuoteMeta(q.NamePrefix)}})
+	}
+	if q.CallbackHost != "" {
+		hostPattern := `^[a-zA-Z][a-zA-Z0-9+.-]*://([^/@]*@)?` + regexp.QuoteMeta(q.CallbackHost) + `(:[0-9]+)?([/?#]|$)`
+		conditions = append(conditions, bson.M{"callback_url": bson.M{"$regex": hostPattern, "$options": "i"}})
+	}
+	if q.Method != "" {
+		methodFilter := bson.M{"method": bson.M{"$regex": "^" + regexp.QuoteMeta(q.Method) + "$", "$options": "i"}}
+		if q.Method == http.MethodGet {
+			// Schedules without a method are called with GET
+			methodFilter = bson.M{"$or": bson.A{methodFilter, bson.M{"method": bson.M{"$in": bson.A{nil, ""}}}}}
+		}
+		conditions = append(conditions, methodFilter)
+	}
+	switch q.State {
+	case models.ScheduleStatePaused:
+		conditions = append(conditions, bson.M{"state": models.ScheduleStatePaused})
+	case models.ScheduleStateActive:
+		conditions = append(conditions, bson.M{"state": bson.M{"$ne": models.ScheduleStatePaused}})
+	}
+	for key, value := range q.Labels {
+		conditions = append(conditions, bson.M{"labels." + key: value})
+	}
+	if !q.CreatedAfter.IsZero() {
+		conditions = append(conditions, bson.M{"_id": bson.M{"$gte": primitive.NewObjectIDFromTimestamp(q.CreatedAfter)}})
+	}
+	if !q.CreatedBefore.IsZero() {
+		conditions = append(conditions, bson.M{"_id": bson.M{"$lt": primitive.NewObjectIDFromTimestamp(q.CreatedBefore)}})
+	}
+
+	if q.After != nil {
+		afterID, err := primitive.ObjectIDFromHex(q.After.ID)
+		if err != nil {
+			return nil, fmt.Errorf("invalid cursor ID: %w", err)
+		}
+		op := "$gt"
+		if q.Descending {
+			op = "$lt"
+		}
+		if field == "_id" {
+			conditions = append(conditions, bson.M{"_id": bson.M{op: afterID}})
+		} else {
+			conditions = append(conditions, bson.M{"$or": bson.A{
+				bson.M{field: bson.M{op: q.After.Value}},
+				bson.M{field: q.After.Value, "_id": bson.M{op: afterID}},
+			}})
+		}
+	}
+
+	if len(conditions) == 0 {
+		return bson.M{}, nil
+	}
+	return bson.M{"$and": conditions}, nil
+}
+
+func (s *ScheduleStore) Update(ctx context.Context, schedule *models.Schedule, fields []string) error {
+	oid, err := primitive.ObjectIDFromHex(schedule.ID)
+	if err != nil {
+		return store.ErrNotFound
+	}
+	raw, err := bson.Marshal(schedule)
+	if err != nil {
+		return err
+	}
+	var doc bson.M
+	if err := bson.Unmarshal(raw, &doc); err != nil {
+		return err
+	}
+
+	// Fields that are empty now are removed, like omitempty does on insert
+	set := bson.M{}
+	unset := bson.M{"next_run_time": ""}
+	for _, field := range fields {
+		if value, ok := doc[field]; ok {
+			set[field] = value
+		} else {
+			unset[field] = ""
+		}
+	}
+	update := bson.M{"$unset": unset}
+	if len(set) > 0 {
+		update["$set"] = set
+	}
+
+	res, err := s.col.UpdateOne(ctx, bson.M{"_id": oid}, update)
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return store.ErrNotFound
+	}
+	return nil
+}
+
+func (s *ScheduleStore) Delete(ctx context.Context, id string) error {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return store.ErrNotFound
+	}
+	res, err := s.col.DeleteOne(ctx, bson.M{"_id": oid})
+	if err != nil {
+		return err
+	}
+	if res.DeletedCount == 0 {
+		return store.ErrNotFound
+	}
+	return nil
+}
+
+func (s *ScheduleStore) Pause(ctx context.Context, id string, at time.Time) error {
+	return s.updateOne(ctx, id, bson.M{"$set": bson.M{
+		"state":     models.ScheduleStatePaused,
+		"paused_at": at,
+	}})
+}
+
+func (s *ScheduleStore) Resume(ctx context.Context, id string) error {
+	return s.updateOne(ctx, id, bson.M{
+		"$set":   bson.M{"state": models.ScheduleStateActive},
+		"$unset": bson.M{"paused_at": "", "next_run_time": ""},
+	})
+}
+
+func (s *ScheduleStore) ExcludeRunTime(ctx context.Context, id string, runTime time.Time) error {
+	return s.updateOne(ctx, id, bson.M{
+		"$addToSet": bson.M{"excluded_run_times": runTime.UTC()},
+		"$unset":    bson.M{"next_run_time": ""},
+	})
+}
+
+func (s *ScheduleStore) IncludeRunTime(ctx context.Context, id string, runTime time.Time) error {
+	return s.updateOne(ctx, id, bson.M{
+		"$pull":  bson.M{"excluded_run_times": runTime.UTC()},
+		"$unset": bson.M{"next_run_time": ""},
+	})
+}
+
+func (s *ScheduleStore) updateOne(ctx context.Context, id string, update bson.M) error {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return store.ErrNotFound
+	}
+	res, err := s.col.UpdateOne(ctx, bson.M{"_id": oid}, update)
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return store.ErrNotFound
+	}
+	return nil
+}
+
+func (s *ScheduleStore) ForEachDue(ctx context.Context, before time.Time, fn func(*models.Schedule) error) error {
+	cursor, err := s.col.Find(ctx, bson.M{
+		"state": bson.M{"$ne": models.ScheduleStatePaused},
+		"$or": bson.A{
+			bson.M{"next_run_time": bson.M{"$lt": before}},
+			bson.M{"next_run_time": bson.M{"$exists": false}},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var schedule models.Schedule
+		if err := cursor.Decode(&schedule); err != nil {
+			return err
+		}
+		if err := fn(&schedule); err != nil {
+			return err
+		}
+	}
+	return cursor.Err()
+}
+
+func (s *ScheduleStore) SetWatermarks(ctx context.Context, watermarks []store.Watermark) error {
+	writes := make([]mongo.WriteModel, 0, len(watermarks))
+	for _, w := range watermarks {
+		oid, err := primitive.ObjectIDFromHex(w.ScheduleID)
+		if err != nil {
+			continue
+		}
+		filter := bson.M{"_id": oid, "rrule": w.RRule}
+		if w.Timezone == "" {
+			filter["timezone"] = bson.M{"$in": bson.A{nil, ""}}
+		} else {
+			filter["timezone"] = w.Timezone
+		}
+		if w.PrevNextRunTime == nil {
+			filter["next_run_time"] = bson.M{"$exists": false}
+		} else {
+			filter["next_run_time"] = *w.PrevNextRunTime
+		}
+
+		fields := bson.M{"next_run_time": w.NextRunTime}
+		if w.LastEventTime != nil {
+			fields["last_event_time"] = *w.LastEventTime
+		}
+		update := bson.M{"$set": fields}
+		if !w.PruneExcludedBefore.IsZero() {
+			update["$pull"] = bson.M{"excluded_run_times": bson.M{"$lt": w.PruneExcludedBefore}}
+		}
+		writes = append(writes, mongo.NewUpdateOneModel().
+			SetFilter(filter).
+			SetUpdate(update))
+	}
+	if len(writes) == 0 {
+		return nil
+	}
+	_, err := s.col.BulkWrite(ctx, writes, options.BulkWrite().SetOrdered(false))
+	return err
+}
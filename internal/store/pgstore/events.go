@@ -0,0 +1,364 @@
+package pgstore
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/cankoe/rrule-scheduler/internal/models"
+	"github.com/cankoe/rrule-scheduler/internal/store"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const eventColumns = `id, schedule_id, run_time, status, attempts, attempt_count, retry_at,
+	manual, overrides, created_at`
+
+// maxInsertRows caps the rows per INSERT, which stays well below the limit
+// of 65535 arguments per statement.
+const maxInsertRows = 1000
+
+// EventStore keeps pending events in the "events" table and finished ones in
+// "archived_events".
+type EventStore struct {
+	db *sql.DB
+}
+
+func NewEventStore(db *sql.DB) *EventStore {
+	return &EventStore{db: db}
+}
+
+// EnsureIndexes applies the migrations, which create the tables and the
+// unique (schedule_id, run_time) index that makes event generation
+// idempotent across several prequeuer replicas.
+func (s *EventStore) EnsureIndexes(ctx context.Context) error {
+	return Migrate(ctx, s.db)
+}
+
+// eventValues returns the values of eventColumns plus last_status.
+func eventValues(e *models.Event) ([]interface{}, error) {
+	status, err := json.Marshal(append([]models.StatusEntry{}, e.Status...))
+	if err != nil {
+		return nil, err
+	}
+	attempts, err := json.Marshal(append([]models.AttemptRecord{}, e.Attempts...))
+	if err != nil {
+		return nil, err
+	}
+	overrides, err := jsonValue(e.Overrides)
+	if err != nil {
+		return nil, err
+	}
+	return []interface{}{
+		e.ID, e.ScheduleID, e.RunTime.UTC(), string(status), string(attempts), e.AttemptCount,
+		timeValue(e.RetryAt), e.Manual, overrides, e.CreatedAt.UTC(), lastStatus(e.Status),
+	}, nil
+}
+
+func lastStatus(status []models.StatusEntry) string {
+	if len(status) == 0 {
+		return ""
+	}
+	return status[len(status)-1].Status
+}
+
+func scanEvent(row rowScanner) (*models.Event, error) {
+	var event models.Event
+	var status, attempts, overrides []byte
+	var retryAt sql.NullTime
+	if err := row.Scan(&event.ID, &event.ScheduleID, &event.RunTime, &status, &attempts,
+		&event.AttemptCount, &retryAt, &event.Manual, &overrides, &event.CreatedAt,
+	); err != nil {
+		return nil, err
+	}
+	if err := scanJSON(status, &event.Status); err != nil {
+		return nil, err
+	}
+	if err := scanJSON(attempts, &event.Attempts); err != nil {
+		return nil, err
+	}
+	if err := scanJSON(overrides, &event.Overrides); err != nil {
+		return nil, err
+	}
+	if len(event.Attempts) == 0 {
+		event.Attempts = nil
+	}
+	event.RunTime = event.RunTime.UTC()
+	event.CreatedAt = event.CreatedAt.UTC()
+	event.RetryAt = timePtr(retryAt)
+	return &event, nil
+}
+
+// insertEvents inserts the events in one statement. onConflict is appended
+// to the statement, which returns the IDs of the inserted rows.
+func insertEvents(ctx context.Context, db *sql.DB, events []models.Event, onConflict string) (map[string]bool, error) {
+	b := &builder{}
+	rows := make([]string, 0, len(events))
+	for i := range events {
+		values, err := eventValues(&events[i])
+		if err != nil {
+			return nil, err
+		}
+		placeholders := make([]string, len(values))
+		for j, value := range values {
+			placeholders[j] = b.arg(value)
+		}
+		rows = append(rows, "("+strings.Join(placeholders, ", ")+")")
+	}
+
+	result, err := db.QueryContext(ctx, `INSERT INTO events (`+eventColumns+`, last_status)
+		VALUES `+strings.Join(rows, ", ")+` `+onConflict+` RETURNING id`, b.args...)
+	if err != nil {
+		return nil, err
+	}
+	defer result.Close()
+
+	inserted := make(map[string]bool, len(events))
+	for result.Next() {
+		var id string
+		if err := result.Scan(&id); err != nil {
+			return nil, err
+		}
+		inserted[id] = true
+	}
+	return inserted, result.Err()
+}
+
+func (s *EventStore) Insert(ctx context.Context, e *models.Event) (string, error) {
+	e.ID = primitive.NewObjectID().Hex()
+	if _, err := insertEvents(ctx, s.db, []models.Event{*e}, ""); err != nil {
+		e.ID = ""
+		if isUniqueViolation(err) {
+			return "", store.ErrDuplicate
+		}
+		return "", err
+	}
+	return e.ID, nil
+}
+
+// CreateIfAbsent skips events whose (schedule_id, run_time) exists already,
+// so concurrent prequeuers never create the same occurrence twice.
+func (s *EventStore) CreateIfAbsent(ctx context.Context, events []models.Event) ([]models.Event, error) {
+	var created []models.Event
+	for start := 0; start < len(events); start += maxInsertRows {
+		batch := events[start:min(start+maxInsertRows, len(events))]
+		for i := range batch {
+			batch[i].ID = primitive.NewObjectID().Hex()
+		}
+		inserted, err := insertEvents(ctx, s.db, batch, "ON CONFLICT (schedule_id, run_time) WHERE NOT manual DO NOTHING")
+		if err != nil {
+			return created, err
+		}
+		for _, event := range batch {
+			if inserted[event.ID] {
+				created = append(created, event)
+			}
+		}
+	}
+	return created, nil
+}
+
+func (s *EventStore) Get(ctx context.Context, id string) (*models.Event, error) {
+	return getEvent(ctx, s.db, "events", id)
+}
+
+func (s *EventStore) GetArchived(ctx context.Context, id string) (*models.Event, error) {
+	return getEvent(ctx, s.db, "archived_events", id)
+}
+
+func getEvent(ctx context.Context, db *sql.DB, table, id string) (*models.Event, error) {
+	event, err := scanEvent(db.QueryRowContext(ctx,
+		`SELECT `+eventColumns+` FROM `+table+` WHERE id = $1`, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, store.ErrNotFound
+	}
+	return event, err
+}
+
+func (s *EventStore) GetByRunTime(ctx context.Context, scheduleID string, runTime time.Time) (*models.Event, error) {
+	event, err := scanEvent(s.db.QueryRowContext(ctx, `SELECT `+eventColumns+` FROM events
+		WHERE schedule_id = $1 AND run_time = $2 AND NOT manual`,
+		scheduleID, runTime.UTC()))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, store.ErrNotFound
+	}
+	return event, err
+}
+
+func (s *EventStore) List(ctx context.Context, f *store.EventFilter) ([]models.Event, error) {
+	return listEvents(ctx, s.db, "events", f)
+}
+
+func (s *EventStore) ListArchived(ctx context.Context, f *store.EventFilter) ([]models.Event, error) {
+	return listEvents(ctx, s.db, "archived_events", f)
+}
+
+func listEvents(ctx context.Context, db *sql.DB, table string, f *store.EventFilter) ([]models.Event, error) {
+	b := &builder{}
+	if f.ScheduleID != "" {
+		b.where("schedule_id = " + b.arg(f.ScheduleID))
+	}
+	if f.Status != "" {
+		b.where("last_status = " + b.arg(f.Status))
+	}
+	if !f.From.IsZero() {
+		b.where("run_time >= " + b.arg(f.From.UTC()))
+	}
+	if !f.To.IsZero() {
+		b.where("run_time <= " + b.arg(f.To.UTC()))
+	}
+	order := "DESC"
+	if f.Ascending {
+		order = "ASC"
+	}
+	stmt := `SELECT ` + eventColumns + ` FROM ` + table + b.whereClause() +
+		` ORDER BY run_time ` + order + `, id ` + order
+	if f.Limit > 0 {
+		stmt += ` LIMIT ` + b.arg(f.Limit)
+	}
+	if f.Skip > 0 {
+		stmt += ` OFFSET ` + b.arg(f.Skip)
+	}
+
+	rows, err := db.QueryContext(ctx, stmt, b.args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []models.Event{}
+	for rows.Next() {
+		event, err := scanEvent(rows)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, *event)
+	}
+	return events, rows.Err()
+}
+
+func (s *EventStore) ForEach(ctx context.Context, fn func(*models.Event) error) error {
+	rows, err := s.db.QueryContext(ctx, `SELECT `+eventColumns+` FROM events`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		event, err := scanEvent(rows)
+		if err != nil {
+			return err
+		}
+		if err := fn(event); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// statusArgs returns the arguments that append entry to the status column.
+func statusArgs(entry models.StatusEntry) (string, string, error) {
+	raw, err := json.Marshal([]models.StatusEntry{entry})
+	if err != nil {
+		return "", "", err
+	}
+	return string(raw), entry.Status, nil
+}
+
+func (s *EventStore) PushStatus(ctx context.Context, id string, entry models.StatusEntry) error {
+	status, last, err := statusArgs(entry)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.ExecContext(ctx, `UPDATE events
+		SET status = status || $2::jsonb, last_status = $3
+		WHERE id = $1`, id, status, last)
+	return err
+}
+
+func (s *EventStore) PushStatusMany(ctx context.Context, ids []string, entry models.StatusEntry) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	status, last, err := statusArgs(entry)
+	if err != nil {
+		return err
+	}
+	b := &builder{}
+	statusArg, lastArg := b.arg(status), b.arg(last)
+	placeholders := make([]string, len(ids))
+	for i, id := range ids {
+		placeholders[i] = b.arg(id)
+	}
+	_, err = s.db.ExecContext(ctx, `UPDATE events
+		SET status = status || `+statusArg+`::jsonb, last_status = `+lastArg+`
+		WHERE id IN (`+strings.Join(placeholders, ", ")+`)`, b.args...)
+	return err
+}
+
+func (s *EventStore) RecordAttempt(ctx context.Context, id string, record models.AttemptRecord) error {
+	raw, err := json.Marshal([]models.AttemptRecord{record})
+	if err != nil {
+		return err
+	}
+	_, err = s.db.ExecContext(ctx, `UPDATE events SET attempts = attempts || $2::jsonb WHERE id = $1`,
+		id, string(raw))
+	return err
+}
+
+func (s *EventStore) ScheduleRetry(ctx context.Context, id string, attempt int, retryAt time.Time, entry models.StatusEntry) error {
+	status, last, err := statusArgs(entry)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.ExecContext(ctx, `UPDATE events
+		SET status = status || $2::jsonb, last_status = $3, attempt_count = $4, retry_at = $5
+		WHERE id = $1`, id, status, last, attempt, retryAt.UTC())
+	return err
+}
+
+// Archive moves the event in a single statement, so it is never in both or
+// neither of the tables.
+func (s *EventStore) Archive(ctx context.Context, id string, entry models.StatusEntry) error {
+	status, last, err := statusArgs(entry)
+	if err != nil {
+		return err
+	}
+	res, err := s.db.ExecContext(ctx, `WITH moved AS (
+			DELETE FROM events WHERE id = $1 RETURNING *
+		)
+		INSERT INTO archived_events (`+eventColumns+`, last_status)
+		SELECT id, schedule_id, run_time, status || $2::jsonb, attempts, attempt_count, retry_at,
+			manual, overrides, created_at, $3
+		FROM moved`, id, status, last)
+	if isUniqueViolation(err) {
+		return store.ErrDuplicate
+	}
+	return expectRow(res, err)
+}
+
+func (s *EventStore) Restore(ctx context.Context, id string, entry models.StatusEntry) error {
+	status, last, err := statusArgs(entry)
+	if err != nil {
+		return err
+	}
+	res, err := s.db.ExecContext(ctx, `WITH moved AS (
+			DELETE FROM archived_events WHERE id = $1 RETURNING *
+		)
+		INSERT INTO events (`+eventColumns+`, last_status)
+		SELECT id, schedule_id, run_time, status || $2::jsonb, attempts, 0, NULL,
+			manual, overrides, created_at, $3
+		FROM moved`, id, status, last)
+	if isUniqueViolation(err) {
+		return store.ErrDuplicate
+	}
+	return expectRow(res, err)
+}
+
+func (s *EventStore) DeleteBySchedule(ctx context.Context, scheduleID string) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM events WHERE schedule_id = $1`, scheduleID)
+	return err
+}
@@ -0,0 +1,55 @@
+-- Schedules. IDs are ObjectID hex strings, like the ones MongoDB generates,
+-- so they sort in creation order; the "C" collation keeps sorting and
+-- pagination in byte order.
+CREATE TABLE schedules (
+    id                 TEXT COLLATE "C" PRIMARY KEY,
+    name               TEXT COLLATE "C" NOT NULL,
+    rrule              TEXT NOT NULL,
+    timezone           TEXT NOT NULL DEFAULT '',
+    callback_url       TEXT COLLATE "C" NOT NULL,
+    method             TEXT NOT NULL DEFAULT '',
+    headers            JSONB,
+    body               TEXT NOT NULL DEFAULT '',
+    labels             JSONB,
+    success_criteria   JSONB,
+    retry_policy       JSONB,
+    misfire_policy     TEXT NOT NULL DEFAULT '',
+    concurrency_policy TEXT NOT NULL DEFAULT '',
+    state              TEXT NOT NULL DEFAULT '',
+    paused_at          TIMESTAMPTZ,
+    excluded_run_times JSONB NOT NULL DEFAULT '[]',
+    next_run_time      TIMESTAMPTZ,
+    last_event_time    TIMESTAMPTZ,
+    created_at         TIMESTAMPTZ
+);
+
+-- Finds the schedules that are due
+CREATE INDEX schedules_next_run_time_idx ON schedules (next_run_time);
+
+-- Pending events. last_status mirrors the status of the latest entry in
+-- status, so events can be filtered by it.
+CREATE TABLE events (
+    id            TEXT COLLATE "C" PRIMARY KEY,
+    schedule_id   TEXT NOT NULL,
+    run_time      TIMESTAMPTZ NOT NULL,
+    status        JSONB NOT NULL DEFAULT '[]',
+    last_status   TEXT NOT NULL DEFAULT '',
+    attempts      JSONB NOT NULL DEFAULT '[]',
+    attempt_count INTEGER NOT NULL DEFAULT 0,
+    retry_at      TIMESTAMPTZ,
+    manual        BOOLEAN NOT NULL DEFAULT FALSE,
+    overrides     JSONB,
+    created_at    TIMESTAMPTZ NOT NULL
+);
+
+-- Makes event generation idempotent across several prequeuer replicas
+CREATE UNIQUE INDEX events_schedule_id_run_time_idx ON events (schedule_id, run_time);
+CREATE INDEX events_run_time_idx ON events (run_time);
+
+-- Finished events, moved here from events
+CREATE TABLE archived_events (LIKE events INCLUDING DEFAULTS);
+ALTER TABLE archived_events ADD PRIMARY KEY (id);
+
+CREATE INDEX archived_events_schedule_id_run_time_idx ON archived_events (schedule_id, run_time);
+CREATE INDEX archived_events_run_time_idx ON archived_events (run_time);
+CREATE INDEX archived_events_last_status_idx ON archived_events (last_status);
@@ -0,0 +1,182 @@
+// Package pgstore implements the schedule and event stores on PostgreSQL.
+package pgstore
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/rs/zerolog/log"
+)
+
+//go:embed migrations/*.sql
+var migrations embed.FS
+
+// migrationLockID serialises migrations of concurrently starting services.
+const migrationLockID = 7_305_431_022
+
+// Migrate brings the schema up to date. Migrations are the files in
+// migrations/, applied in order of their numeric prefix, each in its own
+// transaction, and recorded in schema_migrations. Services that start at the
+// same time take turns through an advisory lock.
+func Migrate(ctx context.Context, db *sql.DB) error {
+	files, err := fs.Glob(migrations, "migrations/*.sql")
+	if err != nil {
+		return err
+	}
+	sort.Strings(files)
+
+	// The advisory lock belongs to the session, so everything runs on one
+	// connection.
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", migrationLockID); err != nil {
+		return fmt.Errorf("failed to lock migrations: %w", err)
+	}
+	defer func() {
+		if _, err := conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock($1)", migrationLockID); err != nil {
+			log.Error().Err(err).Msg("Failed to unlock migrations")
+		}
+	}()
+
+	if _, err := conn.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version    INTEGER PRIMARY KEY,
+		applied_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	)`); err != nil {
+		return fmt.Errorf("failed to create schema_migrations: %w", err)
+	}
+
+	for _, file := range files {
+		name := strings.TrimPrefix(file, "migrations/")
+		prefix, _, _ := strings.Cut(name, "_")
+		version, err := strconv.Atoi(prefix)
+		if err != nil {
+			return fmt.Errorf("invalid migration file name %q", name)
+		}
+		script, err := migrations.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		applied, err := applyMigration(ctx, conn, version, string(script))
+		if err != nil {
+			return fmt.Errorf("failed to apply migration %s: %w", name, err)
+		}
+		if applied {
+			log.Info().Str("migration", name).Msg("Applied database migration")
+		}
+	}
+	return nil
+}
+
+// applyMigration runs the script unless the version was already applied.
+func applyMigration(ctx context.Context, conn *sql.Conn, version int, script string) (bool, error) {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	var exists bool
+	if err := tx.QueryRowContext(ctx,
+		"SELECT EXISTS (SELECT 1 FROM schema_migrations WHERE version = $1)", version,
+	).Scan(&exists); err != nil {
+		return false, err
+	}
+	if exists {
+		return false, nil
+	}
+	if _, err := tx.ExecContext(ctx, script); err != nil {
+		return false, err
+	}
+	if _, err := tx.ExecContext(ctx, "INSERT INTO schema_migrations (version) VALUES ($1)", version); err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+// isUniqueViolation reports whether the statement failed on a unique index.
+func isUniqueViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "23505"
+}
+
+// builder collects the conditions and positional arguments of a statement.
+type builder struct {
+	conditions []string
+	args       []interface{}
+}
+
+// arg adds an argument and returns its placeholder.
+func (b *builder) arg(v interface{}) string {
+	b.args = append(b.args, v)
+	return "$" + strconv.Itoa(len(b.args))
+}
+
+func (b *builder) where(condition string) {
+	b.conditions = append(b.conditions, condition)
+}
+
+func (b *builder) whereClause() string {
+	if len(b.conditions) == 0 {
+		return ""
+	}
+	return " WHERE " + strings.Join(b.conditions, " AND ")
+}
+
+// jsonValue encodes v for a JSONB column; nil maps, slices and pointers are
+// stored as NULL.
+func jsonValue(v interface{}) (interface{}, error) {
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	if string(raw) == "null" {
+		return nil, nil
+	}
+	return string(raw), nil
+}
+
+// scanJSON decodes a JSONB column; NULL leaves v untouched.
+func scanJSON(raw []byte, v interface{}) error {
+	if len(raw) == 0 {
+		return nil
+	}
+	return json.Unmarshal(raw, v)
+}
+
+func timeValue(t *time.Time) interface{} {
+	if t == nil {
+		return nil
+	}
+	return t.UTC()
+}
+
+func timePtr(t sql.NullTime) *time.Time {
+	if !t.Valid {
+		return nil
+	}
+	utc := t.Time.UTC()
+	return &utc
+}
+
+// pageSize is how many rows the iterating methods read at a time. The rows
+// are read before the callback runs, so that it does not hold a connection
+// while it writes on others.
+const pageSize = 500
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
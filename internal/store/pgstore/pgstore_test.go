@@ -0,0 +1,91 @@
+package pgstore_test
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/cankoe/rrule-scheduler/internal/database"
+	"github.com/cankoe/rrule-scheduler/internal/store/pgstore"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// openTestDB opens the database in POSTGRES_DSN with a schema of its own,
+// which is dropped when the test ends. Tests are skipped without POSTGRES_DSN.
+func openTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+	dsn := os.Getenv("POSTGRES_DSN")
+	if dsn == "" {
+		t.Skip("POSTGRES_DSN is not set")
+	}
+	ctx := context.Background()
+
+	admin, err := database.NewPostgresDB(dsn)
+	if err != nil {
+		t.Fatalf("open database: %v", err)
+	}
+	t.Cleanup(func() { admin.Close() })
+	schema := "pgstore_test_" + primitive.NewObjectID().Hex()
+	if _, err := admin.ExecContext(ctx, `CREATE SCHEMA `+schema); err != nil {
+		t.Fatalf("create schema: %v", err)
+	}
+	t.Cleanup(func() {
+		if _, err := admin.ExecContext(ctx, `DROP SCHEMA `+schema+` CASCADE`); err != nil {
+			t.Errorf("drop schema: %v", err)
+		}
+	})
+
+	db, err := database.NewPostgresDB(withSearchPath(dsn, schema))
+	if err != nil {
+		t.Fatalf("open database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if err := pgstore.Migrate(ctx, db); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	return db
+}
+
+// withSearchPath adds a search_path run-time parameter to a URL or keyword/value
+// DSN.
+func withSearchPath(dsn, schema string) string {
+	if !strings.Contains(dsn, "://") {
+		return dsn + " search_path=" + schema
+	}
+	if strings.Contains(dsn, "?") {
+		return dsn + "&search_path=" + schema
+	}
+	return dsn + "?search_path=" + schema
+}
+
+func TestMigrate(t *testing.T) {
+	ctx := context.Background()
+	db := openTestDB(t)
+
+	// Migrating an up-to-date schema changes nothing
+	if err := pgstore.Migrate(ctx, db); err != nil {
+		t.Fatalf("migrate again: %v", err)
+	}
+
+	files, err := filepath.Glob(filepath.Join("migrations", "*.sql"))
+	if err != nil {
+		t.Fatalf("list migrations: %v", err)
+	}
+	var applied int
+	if err := db.QueryRowContext(ctx, `SELECT count(*) FROM schema_migrations`).Scan(&applied); err != nil {
+		t.Fatalf("count applied migrations: %v", err)
+	}
+	if applied != len(files) {
+		t.Errorf("got %d applied migrations, want %d", applied, len(files))
+	}
+
+	for _, table := range []string{"schedules", "events", "archived_events"} {
+		if _, err := db.ExecContext(ctx, `SELECT * FROM `+table+` LIMIT 1`); err != nil {
+			t.Errorf("query %s: %v", table, err)
+		}
+	}
+}
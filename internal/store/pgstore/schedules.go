@@ -0,0 +1,412 @@
+package pgstore
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/cankoe/rrule-scheduler/internal/models"
+	"github.com/cankoe/rrule-scheduler/internal/store"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const scheduleColumns = `id, name, rrule, timezone, callback_url, method, headers, body, labels,
+	success_criteria, retry_policy, misfire_policy, concurrency_policy, state, paused_at,
+	excluded_run_times, next_run_time, last_event_time, created_at`
+
+// sortColumns maps the sort keys to columns. Creation time is sorted by ID,
+// which embeds the creation timestamp.
+var sortColumns = map[string]string{
+	store.SortCreatedAt:   "id",
+	store.SortName:        "name",
+	store.SortCallbackURL: "callback_url",
+}
+
+// ScheduleStore keeps schedules in the "schedules" table.
+type ScheduleStore struct {
+	db *sql.DB
+}
+
+func NewScheduleStore(db *sql.DB) *ScheduleStore {
+	return &ScheduleStore{db: db}
+}
+
+// EnsureIndexes applies the migrations, which create the tables and indexes.
+func (s *ScheduleStore) EnsureIndexes(ctx context.Context) error {
+	return Migrate(ctx, s.db)
+}
+
+// editableColumns returns the values of the columns that can be changed
+// through Update, keyed by column (and JSON field) name.
+func editableColumns(schedule *models.Schedule) (map[string]interface{}, error) {
+	headers, err := jsonValue(schedule.Headers)
+	if err != nil {
+		return nil, err
+	}
+	labels, err := jsonValue(schedule.Labels)
+	if err != nil {
+		return nil, err
+	}
+	criteria, err := jsonValue(schedule.SuccessCriteria)
+	if err != nil {
+		return nil, err
+	}
+	policy, err := jsonValue(schedule.RetryPolicy)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{
+		"name":               schedule.Name,
+		"rrule":              schedule.RRule,
+		"timezone":           schedule.Timezone,
+		"callback_url":       schedule.CallbackURL,
+		"method":             schedule.Method,
+		"headers":            headers,
+		"body":               schedule.Body,
+		"labels":             labels,
+		"success_criteria":   criteria,
+		"retry_policy":       policy,
+		"misfire_policy":     schedule.MisfirePolicy,
+		"concurrency_policy": schedule.ConcurrencyPolicy,
+	}, nil
+}
+
+func (s *ScheduleStore) Create(ctx context.Context, schedule *models.Schedule) (string, error) {
+	columns, err := editableColumns(schedule)
+	if err != nil {
+		return "", err
+	}
+	excluded, err := json.Marshal(append([]time.Time{}, schedule.ExcludedRunTimes...))
+	if err != nil {
+		return "", err
+	}
+	var createdAt interface{}
+	if !schedule.CreatedAt.IsZero() {
+		createdAt = schedule.CreatedAt.UTC()
+	}
+
+	id := primitive.NewObjectID().Hex()
+	_, err = s.db.ExecContext(ctx, `INSERT INTO schedules (`+scheduleColumns+`)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19)`,
+		id, columns["name"], columns["rrule"], columns["timezone"], columns["callback_url"],
+		columns["method"], columns["headers"], columns["body"], columns["labels"],
+		columns["success_criteria"], columns["retry_policy"], columns["misfire_policy"],
+		columns["concurrency_policy"], schedule.State, timeValue(schedule.PausedAt), string(excluded),
+		timeValue(schedule.NextRunTime), timeValue(schedule.LastEventTime), createdAt,
+	)
+	if err != nil {
+		return "", err
+	}
+	schedule.ID = id
+	return id, nil
+}
+
+func scanSchedule(row rowScanner) (*models.Schedule, error) {
+	var schedule models.Schedule
+	var headers, labels, criteria, policy, excluded []byte
+	var pausedAt, nextRunTime, lastEventTime, createdAt sql.NullTime
+	if err := row.Scan(&schedule.ID, &schedule.Name, &schedule.RRule, &schedule.Timezone,
+		&schedule.CallbackURL, &schedule.Method, &headers, &schedule.Body, &labels,
+		&criteria, &policy, &schedule.MisfirePolicy, &schedule.ConcurrencyPolicy,
+		&schedule.State, &pausedAt, &excluded, &nextRunTime, &lastEventTime, &createdAt,
+	); err != nil {
+		return nil, err
+	}
+	for _, column := range []struct {
+		raw []byte
+		v   interface{}
+	}{
+		{headers, &schedule.Headers},
+		{labels, &schedule.Labels},
+		{criteria, &schedule.SuccessCriteria},
+		{policy, &schedule.RetryPolicy},
+		{excluded, &schedule.ExcludedRunTimes},
+	} {
+		if err := scanJSON(column.raw, column.v); err != nil {
+			return nil, err
+		}
+	}
+	if len(schedule.ExcludedRunTimes) == 0 {
+		schedule.ExcludedRunTimes = nil
+	}
+	for i, t := range schedule.ExcludedRunTimes {
+		schedule.ExcludedRunTimes[i] = t.UTC()
+	}
+	schedule.PausedAt = timePtr(pausedAt)
+	schedule.NextRunTime = timePtr(nextRunTime)
+	schedule.LastEventTime = timePtr(lastEventTime)
+	if createdAt.Valid {
+		schedule.CreatedAt = createdAt.Time.UTC()
+	}
+	return &schedule, nil
+}
+
+func (s *ScheduleStore) Get(ctx context.Context, id string) (*models.Schedule, error) {
+	schedule, err := scanSchedule(s.db.QueryRowContext(ctx,
+		`SELECT `+scheduleColumns+` FROM schedules WHERE id = $1`, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, store.ErrNotFound
+	}
+	return schedule, err
+}
+
+func (s *ScheduleStore) List(ctx context.Context, q *store.ScheduleQuery) ([]models.Schedule, error) {
+	column, ok := sortColumns[q.Sort]
+	if !ok {
+		column = "id"
+	}
+	b, err := buildListQuery(q, column)
+	if err != nil {
+		return nil, err
+	}
+	order := "ASC"
+	if q.Descending {
+		order = "DESC"
+	}
+	stmt := `SELECT ` + scheduleColumns + ` FROM schedules` + b.whereClause() + ` ORDER BY ` + column + ` ` + order
+	if column != "id" {
+		stmt += `, id ` + order
+	}
+	if q.Limit > 0 {
+		stmt += ` LIMIT ` + b.arg(q.Limit)
+	}
+
+	rows, err := s.db.QueryContext(ctx, stmt, b.args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	schedules := []models.Schedule{}
+	for rows.Next() {
+		schedule, err := scanSchedule(rows)
+		if err != nil {
+			return nil, err
+		}
+		schedules = append(schedules, *schedule)
+	}
+	return schedules, rows.Err()
+}
+
+func buildListQuery(q *store.ScheduleQuery, column string) (*builder, error) {
+	b := &builder{}
+
+	if q.NamePrefix != "" {
+		b.where("starts_with(name, " + b.arg(q.NamePrefix) + ")")
+	}
+	if q.CallbackHost != "" {
+		hostPattern := `^[a-zA-Z][a-zA-Z0-9+.-]*://([^/@]*@)?` + regexp.QuoteMeta(q.CallbackHost) + `(:[0-9]+)?([/?#]|$)`
+		b.where("callback_url ~* " + b.arg(hostPattern))
+	}
+	if q.Method != "" {
+		condition := "upper(method) = " + b.arg(strings.ToUpper(q.Method))
+		if q.Method == http.MethodGet {
+			// Schedules without a method are called with GET
+			condition = "(" + condition + " OR method = '')"
+		}
+		b.where(condition)
+	}
+	switch q.State {
+	case models.ScheduleStatePaused:
+		b.where("state = " + b.arg(models.ScheduleStatePaused))
+	case models.ScheduleStateActive:
+		b.where("state <> " + b.arg(models.ScheduleStatePaused))
+	}
+	if len(q.Labels) > 0 {
+		labels, err := json.Marshal(q.Labels)
+		if err != nil {
+			return nil, err
+		}
+		b.where("labels @> " + b.arg(string(labels)) + "::jsonb")
+	}
+	if !q.CreatedAfter.IsZero() {
+		b.where("id >= " + b.arg(primitive.NewObjectIDFromTimestamp(q.CreatedAfter).Hex()))
+	}
+	if !q.CreatedBefore.IsZero() {
+		b.where("id < " + b.arg(primitive.NewObjectIDFromTimestamp(q.CreatedBefore).Hex()))
+	}
+
+	if q.After != nil {
+		op := ">"
+		if q.Descending {
+			op = "<"
+		}
+		afterID := b.arg(q.After.ID)
+		if column == "id" {
+			b.where("id " + op + " " + afterID)
+		} else {
+			value := b.arg(q.After.Value)
+			b.where("(" + column + " " + op + " " + value + " OR (" + column + " = " + value + " AND id " + op + " " + afterID + "))")
+		}
+	}
+	return b, nil
+}
+
+func (s *ScheduleStore) Update(ctx context.Context, schedule *models.Schedule, fields []string) error {
+	columns, err := editableColumns(schedule)
+	if err != nil {
+		return err
+	}
+	b := &builder{}
+	sets := []string{"next_run_time = NULL", "exhausted = false"}
+	for _, field := range fields {
+		if value, ok := columns[field]; ok {
+			sets = append(sets, field+" = "+b.arg(value))
+		}
+	}
+	res, err := s.db.ExecContext(ctx,
+		`UPDATE schedules SET `+strings.Join(sets, ", ")+` WHERE id = `+b.arg(schedule.ID), b.args...)
+	return expectRow(res, err)
+}
+
+// expectRow turns an update or delete that matched nothing into ErrNotFound.
+func expectRow(res sql.Result, err error) error {
+	if err != nil {
+		return err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return store.ErrNotFound
+	}
+	return nil
+}
+
+func (s *ScheduleStore) Delete(ctx context.Context, id string) error {
+	return expectRow(s.db.ExecContext(ctx, `DELETE FROM schedules WHERE id = $1`, id))
+}
+
+func (s *ScheduleStore) Pause(ctx context.Context, id string, at time.Time) error {
+	return expectRow(s.db.ExecContext(ctx,
+		`UPDATE schedules SET state = $2, paused_at = $3 WHERE id = $1`,
+		id, models.ScheduleStatePaused, at.UTC()))
+}
+
+func (s *ScheduleStore) Resume(ctx context.Context, id string) error {
+	return expectRow(s.db.ExecContext(ctx,
+		`UPDATE schedules SET state = $2, paused_at = NULL, next_run_time = NULL, exhausted = false WHERE id = $1`,
+		id, models.ScheduleStateActive))
+}
+
+func (s *ScheduleStore) ExcludeRunTime(ctx context.Context, id string, runTime time.Time) error {
+	excluded, err := json.Marshal([]time.Time{runTime.UTC()})
+	if err != nil {
+		return err
+	}
+	res, err := s.db.ExecContext(ctx, `UPDATE schedules
+		SET excluded_run_times = excluded_run_times || $2::jsonb, next_run_time = NULL, exhausted = false
+		WHERE id = $1 AND NOT excluded_run_times @> $2::jsonb`,
+		id, string(excluded))
+	if err := expectRow(res, err); !errors.Is(err, store.ErrNotFound) {
+		return err
+	}
+	// Nothing changed: either the run time was already excluded or there is
+	// no such schedule
+	var exists bool
+	if err := s.db.QueryRowContext(ctx,
+		`SELECT EXISTS (SELECT 1 FROM schedules WHERE id = $1)`, id,
+	).Scan(&exists); err != nil {
+		return err
+	}
+	if !exists {
+		return store.ErrNotFound
+	}
+	return nil
+}
+
+func (s *ScheduleStore) IncludeRunTime(ctx context.Context, id string, runTime time.Time) error {
+	// Excluded run times are stored as JSON strings, as encoding/json formats them.
+	return expectRow(s.db.ExecContext(ctx, `UPDATE schedules
+		SET excluded_run_times = excluded_run_times - $2::text, next_run_time = NULL, exhausted = false
+		WHERE id = $1`,
+		id, runTime.UTC().Format(time.RFC3339Nano)))
+}
+
+func (s *ScheduleStore) ForEachDue(ctx context.Context, before time.Time, fn func(*models.Schedule) error) error {
+	afterID := ""
+	for {
+		page, err := s.dueAfter(ctx, before, afterID)
+		if err != nil {
+			return err
+		}
+		for i := range page {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if err := fn(&page[i]); err != nil {
+				return err
+			}
+		}
+		if len(page) < pageSize {
+			return nil
+		}
+		afterID = page[len(page)-1].ID
+	}
+}
+
+// dueAfter reads the next page of due schedules, ordered by ID.
+func (s *ScheduleStore) dueAfter(ctx context.Context, before time.Time, afterID string) ([]models.Schedule, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT `+scheduleColumns+` FROM schedules
+		WHERE state <> $1 AND (next_run_time IS NULL AND NOT exhausted OR next_run_time < $2) AND id > $3
+		ORDER BY id LIMIT $4`,
+		models.ScheduleStatePaused, before.UTC(), afterID, pageSize)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var schedules []models.Schedule
+	for rows.Next() {
+		schedule, err := scanSchedule(rows)
+		if err != nil {
+			return nil, err
+		}
+		schedules = append(schedules, *schedule)
+	}
+	return schedules, rows.Err()
+}
+
+// SetWatermarks only updates schedules whose recurrence and next_run_time are
+// still the ones the watermark was computed from.
+func (s *ScheduleStore) SetWatermarks(ctx context.Context, watermarks []store.Watermark) error {
+	if len(watermarks) == 0 {
+		return nil
+	}
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, w := range watermarks {
+		var pruneBefore interface{}
+		if !w.PruneExcludedBefore.IsZero() {
+			pruneBefore = w.PruneExcludedBefore.UTC()
+		}
+		if _, err := tx.ExecContext(ctx, `UPDATE schedules SET
+			next_run_time = $5,
+			exhausted = $5::timestamptz IS NULL,
+			last_event_time = COALESCE($6, last_event_time),
+			excluded_run_times = CASE WHEN $7::timestamptz IS NULL THEN excluded_run_times ELSE (
+				SELECT COALESCE(jsonb_agg(t), '[]'::jsonb)
+				FROM jsonb_array_elements(excluded_run_times) AS t
+				WHERE (t #>> '{}')::timestamptz >= $7::timestamptz
+			) END
+			WHERE id = $1 AND rrule = $2 AND timezone = $3 AND next_run_time IS NOT DISTINCT FROM $4::timestamptz
+				AND NOT exhausted`,
+			w.ScheduleID, w.RRule, w.Timezone, timeValue(w.PrevNextRunTime),
+			timeValue(w.NextRunTime), timeValue(w.LastEventTime), pruneBefore,
+		); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,253 @@
+package pgstore_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cankoe/rrule-scheduler/internal/models"
+	"github.com/cankoe/rrule-scheduler/internal/store"
+	"github.com/cankoe/rrule-scheduler/internal/store/pgstore"
+)
+
+func TestList(t *testing.T) {
+	ctx := context.Background()
+	schedules := pgstore.NewScheduleStore(openTestDB(t))
+
+	for _, schedule := range []*models.Schedule{
+		{Name: "report-daily", CallbackURL: "https://Example.com/report", Method: "POST", Labels: map[string]string{"team": "data"}},
+		{Name: "report-weekly", CallbackURL: "http://example.com:8080", Labels: map[string]string{"team": "data", "tier": "gold"}},
+		{Name: "cleanup", CallbackURL: "https://user@example.com?full=1", Method: "get"},
+		{Name: "ping", CallbackURL: "https://notexample.com/example.com", Method: "DELETE", State: models.ScheduleStatePaused},
+	} {
+		if _, err := schedules.Create(ctx, schedule); err != nil {
+			t.Fatalf("create schedule: %v", err)
+		}
+	}
+
+	tests := []struct {
+		name string
+		q    store.ScheduleQuery
+		want []string
+	}{
+		{"all", store.ScheduleQuery{}, []string{"report-daily", "report-weekly", "cleanup", "ping"}},
+		{"name prefix", store.ScheduleQuery{NamePrefix: "report-"}, []string{"report-daily", "report-weekly"}},
+		{"callback host", store.ScheduleQuery{CallbackHost: "example.com"}, []string{"report-daily", "report-weekly", "cleanup"}},
+		{"method", store.ScheduleQuery{Method: "post"}, []string{"report-daily"}},
+		{"default method", store.ScheduleQuery{Method: "GET"}, []string{"report-weekly", "cleanup"}},
+		{"paused", store.ScheduleQuery{State: models.ScheduleStatePaused}, []string{"ping"}},
+		{"active", store.ScheduleQuery{State: models.ScheduleStateActive}, []string{"report-daily", "report-weekly", "cleanup"}},
+		{"labels", store.ScheduleQuery{Labels: map[string]string{"team": "data", "tier": "gold"}}, []string{"report-weekly"}},
+		{"by name", store.ScheduleQuery{Sort: store.SortName}, []string{"cleanup", "ping", "report-daily", "report-weekly"}},
+		{"by name descending", store.ScheduleQuery{Sort: store.SortName, Descending: true, Limit: 2}, []string{"report-weekly", "report-daily"}},
+		{"created before", store.ScheduleQuery{CreatedBefore: time.Now().Add(-time.Hour)}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			found, err := schedules.List(ctx, &tt.q)
+			if err != nil {
+				t.Fatalf("list: %v", err)
+			}
+			var names []string
+			for _, schedule := range found {
+				names = append(names, schedule.Name)
+			}
+			if strings.Join(names, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("got %v, want %v", names, tt.want)
+			}
+		})
+	}
+}
+
+func TestListContinuesPastCursor(t *testing.T) {
+	ctx := context.Background()
+	schedules := pgstore.NewScheduleStore(openTestDB(t))
+	for _, name := range []string{"a", "b", "b", "c"} {
+		if _, err := schedules.Create(ctx, &models.Schedule{Name: name}); err != nil {
+			t.Fatalf("create schedule: %v", err)
+		}
+	}
+
+	for _, descending := range []bool{false, true} {
+		q := &store.ScheduleQuery{Sort: store.SortName, Descending: descending, Limit: 1}
+		seen := map[string]bool{}
+		for page := 0; page < 4; page++ {
+			found, err := schedules.List(ctx, q)
+			if err != nil {
+				t.Fatalf("list: %v", err)
+			}
+			if len(found) != 1 {
+				t.Fatalf("descending %v: got %d schedules on page %d, want 1", descending, len(found), page)
+			}
+			if seen[found[0].ID] {
+				t.Fatalf("descending %v: schedule %s listed twice", descending, found[0].ID)
+			}
+			seen[found[0].ID] = true
+			q.After = &store.ScheduleCursor{Value: found[0].Name, ID: found[0].ID}
+		}
+		if found, _ := schedules.List(ctx, q); len(found) != 0 {
+			t.Errorf("descending %v: got %d schedules past the last one", descending, len(found))
+		}
+	}
+}
+
+func TestExcludeRunTime(t *testing.T) {
+	ctx := context.Background()
+	schedules := pgstore.NewScheduleStore(openTestDB(t))
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	next := now.Add(time.Hour)
+	schedule := &models.Schedule{Name: "hourly", RRule: "FREQ=HOURLY", NextRunTime: &next}
+	if _, err := schedules.Create(ctx, schedule); err != nil {
+		t.Fatalf("create schedule: %v", err)
+	}
+
+	// Excluding a run time twice keeps it once
+	for i := 0; i < 2; i++ {
+		if err := schedules.ExcludeRunTime(ctx, schedule.ID, now.Add(2*time.Hour)); err != nil {
+			t.Fatalf("exclude run time: %v", err)
+		}
+	}
+	stored, err := schedules.Get(ctx, schedule.ID)
+	if err != nil {
+		t.Fatalf("get schedule: %v", err)
+	}
+	if len(stored.ExcludedRunTimes) != 1 || !stored.ExcludedRunTimes[0].Equal(now.Add(2*time.Hour)) {
+		t.Errorf("got excluded run times %v, want %s", stored.ExcludedRunTimes, now.Add(2*time.Hour))
+	}
+	if stored.NextRunTime != nil {
+		t.Errorf("got next_run_time %s, want it cleared", stored.NextRunTime)
+	}
+
+	if err := schedules.IncludeRunTime(ctx, schedule.ID, now.Add(2*time.Hour)); err != nil {
+		t.Fatalf("include run time: %v", err)
+	}
+	if stored, err := schedules.Get(ctx, schedule.ID); err != nil {
+		t.Fatalf("get schedule: %v", err)
+	} else if len(stored.ExcludedRunTimes) != 0 {
+		t.Errorf("got excluded run times %v, want none", stored.ExcludedRunTimes)
+	}
+
+	if err := schedules.ExcludeRunTime(ctx, "missing", now); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("got %v for a missing schedule, want ErrNotFound", err)
+	}
+}
+
+func TestSetWatermarks(t *testing.T) {
+	ctx := context.Background()
+	schedules := pgstore.NewScheduleStore(openTestDB(t))
+	now := time.Now().UTC().Truncate(time.Second)
+
+	create := func(name string) *models.Schedule {
+		schedule := &models.Schedule{
+			Name:             name,
+			RRule:            "FREQ=HOURLY",
+			ExcludedRunTimes: []time.Time{now.Add(-time.Hour), now, now.Add(time.Hour)},
+		}
+		if _, err := schedules.Create(ctx, schedule); err != nil {
+			t.Fatalf("create schedule: %v", err)
+		}
+		return schedule
+	}
+	watermarkFor := func(schedule *models.Schedule, next *time.Time) store.Watermark {
+		return store.Watermark{
+			ScheduleID:          schedule.ID,
+			RRule:               schedule.RRule,
+			Timezone:            schedule.Timezone,
+			PrevNextRunTime:     schedule.NextRunTime,
+			NextRunTime:         next,
+			LastEventTime:       &now,
+			PruneExcludedBefore: now,
+		}
+	}
+
+	pruned := create("pruned")
+	changed := create("changed")
+	exhausted := create("exhausted")
+	update := *changed
+	update.RRule = "FREQ=DAILY"
+	if err := schedules.Update(ctx, &update, []string{"rrule"}); err != nil {
+		t.Fatalf("update schedule: %v", err)
+	}
+
+	next := now.Add(2 * time.Hour)
+	if err := schedules.SetWatermarks(ctx, []store.Watermark{
+		watermarkFor(pruned, &next), watermarkFor(changed, &next), watermarkFor(exhausted, nil),
+	}); err != nil {
+		t.Fatalf("set watermarks: %v", err)
+	}
+
+	stored, err := schedules.Get(ctx, pruned.ID)
+	if err != nil {
+		t.Fatalf("get schedule: %v", err)
+	}
+	if stored.NextRunTime == nil || !stored.NextRunTime.Equal(next) {
+		t.Errorf("got next_run_time %v, want %s", stored.NextRunTime, next)
+	}
+	if stored.LastEventTime == nil || !stored.LastEventTime.Equal(now) {
+		t.Errorf("got last_event_time %v, want %s", stored.LastEventTime, now)
+	}
+	if want := []time.Time{now, now.Add(time.Hour)}; len(stored.ExcludedRunTimes) != len(want) ||
+		!stored.ExcludedRunTimes[0].Equal(want[0]) || !stored.ExcludedRunTimes[1].Equal(want[1]) {
+		t.Errorf("got excluded run times %v, want %v", stored.ExcludedRunTimes, want)
+	}
+
+	if stored, err := schedules.Get(ctx, changed.ID); err != nil {
+		t.Fatalf("get schedule: %v", err)
+	} else if stored.NextRunTime != nil || len(stored.ExcludedRunTimes) != 3 {
+		t.Errorf("changed schedule got next_run_time %v and excluded run times %v, want them untouched",
+			stored.NextRunTime, stored.ExcludedRunTimes)
+	}
+
+	// Only the changed schedule is left to compute; the exhausted one is not
+	// due until it is changed too
+	dueNames := func() string {
+		var names []string
+		if err := schedules.ForEachDue(ctx, now, func(schedule *models.Schedule) error {
+			names = append(names, schedule.Name)
+			return nil
+		}); err != nil {
+			t.Fatalf("for each due: %v", err)
+		}
+		return strings.Join(names, ",")
+	}
+	if got := dueNames(); got != "changed" {
+		t.Errorf("got due schedules %q, want %q", got, "changed")
+	}
+	if err := schedules.Resume(ctx, exhausted.ID); err != nil {
+		t.Fatalf("resume schedule: %v", err)
+	}
+	if got := dueNames(); got != "changed,exhausted" {
+		t.Errorf("got due schedules %q after resuming, want %q", got, "changed,exhausted")
+	}
+}
+
+func TestForEachDueLetsCallbackWrite(t *testing.T) {
+	ctx := context.Background()
+	db := openTestDB(t)
+	schedules := pgstore.NewScheduleStore(db)
+	now := time.Now().UTC()
+
+	// More than two pages of due schedules
+	const count = 1001
+	for i := 0; i < count; i++ {
+		if _, err := schedules.Create(ctx, &models.Schedule{Name: "due", RRule: "FREQ=HOURLY"}); err != nil {
+			t.Fatalf("create schedule: %v", err)
+		}
+	}
+
+	// With a single connection, a callback that writes while the rows are
+	// still being read would wait forever
+	db.SetMaxOpenConns(1)
+	visited := 0
+	if err := schedules.ForEachDue(ctx, now, func(schedule *models.Schedule) error {
+		visited++
+		return schedules.Pause(ctx, schedule.ID, now)
+	}); err != nil {
+		t.Fatalf("for each due: %v", err)
+	}
+	if visited != count {
+		t.Errorf("visited %d schedules, want %d", visited, count)
+	}
+}
@@ -0,0 +1,337 @@
+package sqlitestore
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/cankoe/rrule-scheduler/internal/models"
+	"github.com/cankoe/rrule-scheduler/internal/store"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// EventStore keeps pending events in the "events" table and finished ones in
+// "archived_events".
+type EventStore struct {
+	db *sql.DB
+}
+
+func NewEventStore(db *sql.DB) *EventStore {
+	return &EventStore{db: db}
+}
+
+// EnsureIndexes applies the migrations, which create the tables and the
+// unique (schedule_id, run_time) index that makes event generation
+// idempotent.
+func (s *EventStore) EnsureIndexes(ctx context.Context) error {
+	return Migrate(ctx, s.db)
+}
+
+func lastStatus(status []models.StatusEntry) string {
+	if len(status) == 0 {
+		return ""
+	}
+	return status[len(status)-1].Status
+}
+
+// insertEvent writes an event to table. onConflict is appended to the
+// statement.
+func insertEvent(ctx context.Context, db execer, table string, e *models.Event, onConflict string) (sql.Result, error) {
+	doc, err := json.Marshal(e)
+	if err != nil {
+		return nil, err
+	}
+	return db.ExecContext(ctx, `INSERT INTO `+table+`
+		(id, schedule_id, run_time, last_status, manual, doc)
+		VALUES (?, ?, ?, ?, ?, ?) `+onConflict,
+		e.ID, e.ScheduleID, millis(e.RunTime), lastStatus(e.Status), e.Manual, string(doc))
+}
+
+func scanEvent(row interface{ Scan(...interface{}) error }) (*models.Event, error) {
+	var doc string
+	if err := row.Scan(&doc); err != nil {
+		return nil, err
+	}
+	var event models.Event
+	if err := json.Unmarshal([]byte(doc), &event); err != nil {
+		return nil, err
+	}
+	utcEvent(&event)
+	return &event, nil
+}
+
+// utcEvent normalises the times decoded from the document to UTC, like the
+// other stores return them.
+func utcEvent(event *models.Event) {
+	event.RunTime = event.RunTime.UTC()
+	event.CreatedAt = event.CreatedAt.UTC()
+	if event.RetryAt != nil {
+		retryAt := event.RetryAt.UTC()
+		event.RetryAt = &retryAt
+	}
+	for i := range event.Status {
+		event.Status[i].Time = event.Status[i].Time.UTC()
+	}
+	for i := range event.Attempts {
+		event.Attempts[i].StartedAt = event.Attempts[i].StartedAt.UTC()
+	}
+}
+
+func (s *EventStore) Insert(ctx context.Context, e *models.Event) (string, error) {
+	stored := *e
+	stored.ID = primitive.NewObjectID().Hex()
+	if _, err := insertEvent(ctx, s.db, "events", &stored, ""); err != nil {
+		if isUniqueViolation(err) {
+			return "", store.ErrDuplicate
+		}
+		return "", err
+	}
+	e.ID = stored.ID
+	return e.ID, nil
+}
+
+// CreateIfAbsent skips events whose (schedule_id, run_time) exists already.
+func (s *EventStore) CreateIfAbsent(ctx context.Context, events []models.Event) ([]models.Event, error) {
+	var created []models.Event
+	err := withTx(ctx, s.db, func(tx *sql.Tx) error {
+		for i := range events {
+			event := events[i]
+			event.ID = primitive.NewObjectID().Hex()
+			res, err := insertEvent(ctx, tx, "events", &event, "ON CONFLICT (schedule_id, run_time) WHERE NOT manual DO NOTHING")
+			if err != nil {
+				return err
+			}
+			if affected, err := res.RowsAffected(); err != nil {
+				return err
+			} else if affected == 1 {
+				created = append(created, event)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return created, nil
+}
+
+func (s *EventStore) Get(ctx context.Context, id string) (*models.Event, error) {
+	return getEvent(ctx, s.db, "events", id)
+}
+
+func (s *EventStore) GetArchived(ctx context.Context, id string) (*models.Event, error) {
+	return getEvent(ctx, s.db, "archived_events", id)
+}
+
+func getEvent(ctx context.Context, db execer, table, id string) (*models.Event, error) {
+	event, err := scanEvent(db.QueryRowContext(ctx, `SELECT doc FROM `+table+` WHERE id = ?`, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, store.ErrNotFound
+	}
+	return event, err
+}
+
+func (s *EventStore) GetByRunTime(ctx context.Context, scheduleID string, runTime time.Time) (*models.Event, error) {
+	event, err := scanEvent(s.db.QueryRowContext(ctx, `SELECT doc FROM events
+		WHERE schedule_id = ? AND run_time = ? AND NOT manual`,
+		scheduleID, millis(runTime)))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, store.ErrNotFound
+	}
+	return event, err
+}
+
+func (s *EventStore) List(ctx context.Context, f *store.EventFilter) ([]models.Event, error) {
+	return listEvents(ctx, s.db, "events", f)
+}
+
+func (s *EventStore) ListArchived(ctx context.Context, f *store.EventFilter) ([]models.Event, error) {
+	return listEvents(ctx, s.db, "archived_events", f)
+}
+
+func listEvents(ctx context.Context, db *sql.DB, table string, f *store.EventFilter) ([]models.Event, error) {
+	b := &builder{}
+	if f.ScheduleID != "" {
+		b.where("schedule_id = " + b.arg(f.ScheduleID))
+	}
+	if f.Status != "" {
+		b.where("last_status = " + b.arg(f.Status))
+	}
+	if !f.From.IsZero() {
+		b.where("run_time >= " + b.arg(millis(f.From)))
+	}
+	if !f.To.IsZero() {
+		b.where("run_time <= " + b.arg(millis(f.To)))
+	}
+	order := "DESC"
+	if f.Ascending {
+		order = "ASC"
+	}
+	limit := -1
+	if f.Limit > 0 {
+		limit = f.Limit
+	}
+	stmt := `SELECT doc FROM ` + table + b.whereClause() +
+		` ORDER BY run_time ` + order + `, id ` + order +
+		` LIMIT ` + b.arg(limit) + ` OFFSET ` + b.arg(f.Skip)
+
+	rows, err := db.QueryContext(ctx, stmt, b.args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []models.Event{}
+	for rows.Next() {
+		event, err := scanEvent(rows)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, *event)
+	}
+	return events, rows.Err()
+}
+
+func (s *EventStore) ForEach(ctx context.Context, fn func(*models.Event) error) error {
+	afterID := ""
+	for {
+		page, err := s.pageAfter(ctx, afterID)
+		if err != nil {
+			return err
+		}
+		for i := range page {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if err := fn(&page[i]); err != nil {
+				return err
+			}
+		}
+		if len(page) < pageSize {
+			return nil
+		}
+		afterID = page[len(page)-1].ID
+	}
+}
+
+// pageAfter reads the next page of pending events, ordered by ID.
+func (s *EventStore) pageAfter(ctx context.Context, afterID string) ([]models.Event, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT doc FROM events WHERE id > ? ORDER BY id LIMIT ?`,
+		afterID, pageSize)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []models.Event
+	for rows.Next() {
+		event, err := scanEvent(rows)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, *event)
+	}
+	return events, rows.Err()
+}
+
+// putEvent writes a pending event that was read in the same transaction.
+func putEvent(ctx context.Context, db execer, e *models.Event) error {
+	doc, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	_, err = db.ExecContext(ctx, `UPDATE events SET last_status = ?, doc = ? WHERE id = ?`,
+		lastStatus(e.Status), string(doc), e.ID)
+	return err
+}
+
+// update changes pending events in a transaction. Like an update in MongoDB
+// that matches nothing, updating a missing event is not an error.
+func (s *EventStore) update(ctx context.Context, ids []string, change func(*models.Event)) error {
+	return withTx(ctx, s.db, func(tx *sql.Tx) error {
+		for _, id := range ids {
+			event, err := getEvent(ctx, tx, "events", id)
+			if errors.Is(err, store.ErrNotFound) {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			change(event)
+			if err := putEvent(ctx, tx, event); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *EventStore) PushStatus(ctx context.Context, id string, entry models.StatusEntry) error {
+	return s.update(ctx, []string{id}, func(e *models.Event) {
+		e.Status = append(e.Status, entry)
+	})
+}
+
+func (s *EventStore) PushStatusMany(ctx context.Context, ids []string, entry models.StatusEntry) error {
+	return s.update(ctx, ids, func(e *models.Event) {
+		e.Status = append(e.Status, entry)
+	})
+}
+
+func (s *EventStore) RecordAttempt(ctx context.Context, id string, record models.AttemptRecord) error {
+	return s.update(ctx, []string{id}, func(e *models.Event) {
+		e.Attempts = append(e.Attempts, record)
+	})
+}
+
+func (s *EventStore) ScheduleRetry(ctx context.Context, id string, attempt int, retryAt time.Time, entry models.StatusEntry) error {
+	return s.update(ctx, []string{id}, func(e *models.Event) {
+		e.Status = append(e.Status, entry)
+		e.AttemptCount = attempt
+		e.RetryAt = &retryAt
+	})
+}
+
+// move takes an event out of one table and inserts it, changed, into the
+// other, in one transaction.
+func (s *EventStore) move(ctx context.Context, id, from, to string, change func(*models.Event)) error {
+	return withTx(ctx, s.db, func(tx *sql.Tx) error {
+		event, err := getEvent(ctx, tx, from, id)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, `DELETE FROM `+from+` WHERE id = ?`, id); err != nil {
+			return err
+		}
+		change(event)
+		if _, err := insertEvent(ctx, tx, to, event, ""); err != nil {
+			if isUniqueViolation(err) {
+				return store.ErrDuplicate
+			}
+			return err
+		}
+		return nil
+	})
+}
+
+func (s *EventStore) Archive(ctx context.Context, id string, entry models.StatusEntry) error {
+	return s.move(ctx, id, "events", "archived_events", func(e *models.Event) {
+		e.Status = append(e.Status, entry)
+	})
+}
+
+func (s *EventStore) Restore(ctx context.Context, id string, entry models.StatusEntry) error {
+	return s.move(ctx, id, "archived_events", "events", func(e *models.Event) {
+		e.Status = append(e.Status, entry)
+		e.AttemptCount = 0
+		e.RetryAt = nil
+	})
+}
+
+func (s *EventStore) DeleteBySchedule(ctx context.Context, scheduleID string) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM events WHERE schedule_id = ?`, scheduleID)
+	return err
+}
@@ -0,0 +1,77 @@
+package sqlitestore_test
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/cankoe/rrule-scheduler/internal/database"
+	"github.com/cankoe/rrule-scheduler/internal/models"
+	"github.com/cankoe/rrule-scheduler/internal/store"
+	"github.com/cankoe/rrule-scheduler/internal/store/sqlitestore"
+)
+
+// openTestDB opens a migrated database in a temporary directory.
+func openTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+	db, err := database.NewSQLiteDB(filepath.Join(t.TempDir(), "scheduler.db"))
+	if err != nil {
+		t.Fatalf("open database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if err := sqlitestore.Migrate(context.Background(), db); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	return db
+}
+
+func newEventStore(t *testing.T) *sqlitestore.EventStore {
+	return sqlitestore.NewEventStore(openTestDB(t))
+}
+
+func TestCreateIfAbsentSkipsExistingOccurrences(t *testing.T) {
+	ctx := context.Background()
+	events := newEventStore(t)
+	runTime := time.Now().UTC().Truncate(time.Minute).Add(time.Hour)
+	event := models.Event{ScheduleID: "schedule", RunTime: runTime, CreatedAt: time.Now().UTC()}
+
+	created, err := events.CreateIfAbsent(ctx, []models.Event{event})
+	if err != nil || len(created) != 1 {
+		t.Fatalf("got %d created events (err %v), want 1", len(created), err)
+	}
+	created, err = events.CreateIfAbsent(ctx, []models.Event{event})
+	if err != nil || len(created) != 0 {
+		t.Fatalf("got %d created events (err %v) for an existing occurrence, want 0", len(created), err)
+	}
+	if _, err := events.Insert(ctx, &event); err != store.ErrDuplicate {
+		t.Errorf("got %v inserting an existing occurrence, want ErrDuplicate", err)
+	}
+}
+
+func TestManualEventsDoNotTakeOccurrences(t *testing.T) {
+	ctx := context.Background()
+	events := newEventStore(t)
+	runTime := time.Now().UTC().Truncate(time.Minute).Add(time.Hour)
+
+	manual := models.Event{ScheduleID: "schedule", RunTime: runTime, Manual: true, CreatedAt: time.Now().UTC()}
+	if _, err := events.Insert(ctx, &manual); err != nil {
+		t.Fatalf("insert manual event: %v", err)
+	}
+	if _, err := events.GetByRunTime(ctx, "schedule", runTime); err != store.ErrNotFound {
+		t.Errorf("got %v looking up the occurrence of a manual event, want ErrNotFound", err)
+	}
+
+	created, err := events.CreateIfAbsent(ctx, []models.Event{{ScheduleID: "schedule", RunTime: runTime, CreatedAt: time.Now().UTC()}})
+	if err != nil || len(created) != 1 {
+		t.Fatalf("got %d created events (err %v) next to a manual event, want 1", len(created), err)
+	}
+	scheduled, err := events.GetByRunTime(ctx, "schedule", runTime)
+	if err != nil {
+		t.Fatalf("get occurrence: %v", err)
+	}
+	if scheduled.ID != created[0].ID {
+		t.Errorf("got event %s for the occurrence, want %s", scheduled.ID, created[0].ID)
+	}
+}
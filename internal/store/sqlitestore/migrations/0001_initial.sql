@@ -0,0 +1,45 @@
+-- Rows keep the whole schedule or event as a JSON document in doc; the other
+-- columns duplicate the fields that are filtered and sorted on. IDs are
+-- ObjectID hex strings, like the ones MongoDB generates, so they sort in
+-- creation order. Times are Unix milliseconds.
+CREATE TABLE schedules (
+    id            TEXT PRIMARY KEY,
+    name          TEXT NOT NULL,
+    callback_url  TEXT NOT NULL,
+    method        TEXT NOT NULL DEFAULT '',
+    state         TEXT NOT NULL DEFAULT '',
+    next_run_time INTEGER,
+    doc           TEXT NOT NULL
+);
+
+-- Finds the schedules that are due
+CREATE INDEX schedules_next_run_time_idx ON schedules (next_run_time);
+
+-- Pending events. last_status is the status of the latest entry in the
+-- document's status list.
+CREATE TABLE events (
+    id          TEXT PRIMARY KEY,
+    schedule_id TEXT NOT NULL,
+    run_time    INTEGER NOT NULL,
+    last_status TEXT NOT NULL DEFAULT '',
+    manual      INTEGER NOT NULL DEFAULT 0,
+    doc         TEXT NOT NULL
+);
+
+-- Makes event generation idempotent
+CREATE UNIQUE INDEX events_schedule_id_run_time_idx ON events (schedule_id, run_time);
+CREATE INDEX events_run_time_idx ON events (run_time);
+
+-- Finished events, moved here from events
+CREATE TABLE archived_events (
+    id          TEXT PRIMARY KEY,
+    schedule_id TEXT NOT NULL,
+    run_time    INTEGER NOT NULL,
+    last_status TEXT NOT NULL DEFAULT '',
+    manual      INTEGER NOT NULL DEFAULT 0,
+    doc         TEXT NOT NULL
+);
+
+CREATE INDEX archived_events_schedule_id_run_time_idx ON archived_events (schedule_id, run_time);
+CREATE INDEX archived_events_run_time_idx ON archived_events (run_time);
+CREATE INDEX archived_events_last_status_idx ON archived_events (last_status);
@@ -0,0 +1,4 @@
+-- Only scheduled events have to be unique per run time; a manual run at the
+-- time of an occurrence must not keep the occurrence from being generated.
+DROP INDEX events_schedule_id_run_time_idx;
+CREATE UNIQUE INDEX events_schedule_id_run_time_idx ON events (schedule_id, run_time) WHERE NOT manual;
@@ -0,0 +1,4 @@
+-- Schedules without occurrences left have no next_run_time, like the ones it
+-- has not been computed for yet; exhausted tells them apart so that they are
+-- not rescanned for due events every tick.
+ALTER TABLE schedules ADD COLUMN exhausted INTEGER NOT NULL DEFAULT 0;
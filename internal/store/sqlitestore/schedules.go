@@ -0,0 +1,411 @@
+package sqlitestore
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/cankoe/rrule-scheduler/internal/models"
+	"github.com/cankoe/rrule-scheduler/internal/store"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// sortColumns maps the sort keys to columns. Creation time is sorted by ID,
+// which embeds the creation timestamp.
+var sortColumns = map[string]string{
+	store.SortCreatedAt:   "id",
+	store.SortName:        "name",
+	store.SortCallbackURL: "callback_url",
+}
+
+// ScheduleStore keeps schedules in the "schedules" table.
+type ScheduleStore struct {
+	db *sql.DB
+}
+
+func NewScheduleStore(db *sql.DB) *ScheduleStore {
+	return &ScheduleStore{db: db}
+}
+
+// EnsureIndexes applies the migrations, which create the tables and indexes.
+func (s *ScheduleStore) EnsureIndexes(ctx context.Context) error {
+	return Migrate(ctx, s.db)
+}
+
+// execer is implemented by both *sql.DB and *sql.Tx.
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+func (s *ScheduleStore) Create(ctx context.Context, schedule *models.Schedule) (string, error) {
+	id := primitive.NewObjectID().Hex()
+	stored := *schedule
+	stored.ID = id
+	doc, err := json.Marshal(&stored)
+	if err != nil {
+		return "", err
+	}
+	if _, err := s.db.ExecContext(ctx, `INSERT INTO schedules
+		(id, name, callback_url, method, state, next_run_time, doc)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		id, stored.Name, stored.CallbackURL, stored.Method, stored.State,
+		millisPtr(stored.NextRunTime), string(doc),
+	); err != nil {
+		return "", err
+	}
+	schedule.ID = id
+	return id, nil
+}
+
+func scanSchedule(row interface{ Scan(...interface{}) error }) (*models.Schedule, error) {
+	var doc string
+	if err := row.Scan(&doc); err != nil {
+		return nil, err
+	}
+	var schedule models.Schedule
+	if err := json.Unmarshal([]byte(doc), &schedule); err != nil {
+		return nil, err
+	}
+	utcSchedule(&schedule)
+	return &schedule, nil
+}
+
+// utcSchedule normalises the times decoded from the document to UTC, like
+// the other stores return them.
+func utcSchedule(schedule *models.Schedule) {
+	for i, t := range schedule.ExcludedRunTimes {
+		schedule.ExcludedRunTimes[i] = t.UTC()
+	}
+	for _, t := range []*time.Time{schedule.PausedAt, schedule.NextRunTime, schedule.LastEventTime, &schedule.CreatedAt} {
+		if t != nil {
+			*t = t.UTC()
+		}
+	}
+}
+
+func getSchedule(ctx context.Context, db execer, id string) (*models.Schedule, error) {
+	schedule, err := scanSchedule(db.QueryRowContext(ctx, `SELECT doc FROM schedules WHERE id = ?`, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, store.ErrNotFound
+	}
+	return schedule, err
+}
+
+// putSchedule writes a schedule that was read in the same transaction.
+// exhausted marks a schedule without occurrences left.
+func putSchedule(ctx context.Context, db execer, schedule *models.Schedule, exhausted bool) error {
+	doc, err := json.Marshal(schedule)
+	if err != nil {
+		return err
+	}
+	_, err = db.ExecContext(ctx, `UPDATE schedules
+		SET name = ?, callback_url = ?, method = ?, state = ?, next_run_time = ?, exhausted = ?, doc = ?
+		WHERE id = ?`,
+		schedule.Name, schedule.CallbackURL, schedule.Method, schedule.State,
+		millisPtr(schedule.NextRunTime), exhausted, string(doc), schedule.ID)
+	return err
+}
+
+// modify applies change to a schedule in a transaction. Changed schedules are
+// no longer considered exhausted.
+func (s *ScheduleStore) modify(ctx context.Context, id string, change func(*models.Schedule) error) error {
+	return withTx(ctx, s.db, func(tx *sql.Tx) error {
+		schedule, err := getSchedule(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+		if err := change(schedule); err != nil {
+			return err
+		}
+		return putSchedule(ctx, tx, schedule, false)
+	})
+}
+
+func (s *ScheduleStore) Get(ctx context.Context, id string) (*models.Schedule, error) {
+	return getSchedule(ctx, s.db, id)
+}
+
+func (s *ScheduleStore) List(ctx context.Context, q *store.ScheduleQuery) ([]models.Schedule, error) {
+	column, ok := sortColumns[q.Sort]
+	if !ok {
+		column = "id"
+	}
+	b := buildListQuery(q, column)
+	order := "ASC"
+	if q.Descending {
+		order = "DESC"
+	}
+	stmt := `SELECT doc FROM schedules` + b.whereClause() + ` ORDER BY ` + column + ` ` + order
+	if column != "id" {
+		stmt += `, id ` + order
+	}
+	if q.Limit > 0 {
+		stmt += ` LIMIT ` + b.arg(q.Limit)
+	}
+
+	rows, err := s.db.QueryContext(ctx, stmt, b.args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	schedules := []models.Schedule{}
+	for rows.Next() {
+		schedule, err := scanSchedule(rows)
+		if err != nil {
+			return nil, err
+		}
+		schedules = append(schedules, *schedule)
+	}
+	return schedules, rows.Err()
+}
+
+func buildListQuery(q *store.ScheduleQuery, column string) *builder {
+	b := &builder{}
+
+	if q.NamePrefix != "" {
+		// Unlike LIKE and GLOB, instr neither folds case nor has wildcards
+		b.where("instr(name, " + b.arg(q.NamePrefix) + ") = 1")
+	}
+	if q.CallbackHost != "" {
+		hostPattern := `(?i)^[a-zA-Z][a-zA-Z0-9+.-]*://([^/@]*@)?` + regexp.QuoteMeta(q.CallbackHost) + `(:[0-9]+)?([/?#]|$)`
+		b.where("callback_url REGEXP " + b.arg(hostPattern))
+	}
+	if q.Method != "" {
+		condition := "upper(method) = " + b.arg(strings.ToUpper(q.Method))
+		if q.Method == http.MethodGet {
+			// Schedules without a method are called with GET
+			condition = "(" + condition + " OR method = '')"
+		}
+		b.where(condition)
+	}
+	switch q.State {
+	case models.ScheduleStatePaused:
+		b.where("state = " + b.arg(models.ScheduleStatePaused))
+	case models.ScheduleStateActive:
+		b.where("state <> " + b.arg(models.ScheduleStatePaused))
+	}
+	for key, value := range q.Labels {
+		b.where("EXISTS (SELECT 1 FROM json_each(doc, '$.labels') WHERE key = " + b.arg(key) +
+			" AND value = " + b.arg(value) + ")")
+	}
+	if !q.CreatedAfter.IsZero() {
+		b.where("id >= " + b.arg(primitive.NewObjectIDFromTimestamp(q.CreatedAfter).Hex()))
+	}
+	if !q.CreatedBefore.IsZero() {
+		b.where("id < " + b.arg(primitive.NewObjectIDFromTimestamp(q.CreatedBefore).Hex()))
+	}
+
+	if q.After != nil {
+		op := ">"
+		if q.Descending {
+			op = "<"
+		}
+		if column == "id" {
+			b.where("id " + op + " " + b.arg(q.After.ID))
+		} else {
+			b.where("(" + column + " " + op + " " + b.arg(q.After.Value) + " OR (" +
+				column + " = " + b.arg(q.After.Value) + " AND id " + op + " " + b.arg(q.After.ID) + "))")
+		}
+	}
+	return b
+}
+
+// Update copies the fields, by their JSON name, from schedule to the stored
+// schedule; fields that are empty now are removed.
+func (s *ScheduleStore) Update(ctx context.Context, schedule *models.Schedule, fields []string) error {
+	return s.modify(ctx, schedule.ID, func(stored *models.Schedule) error {
+		var src, dst map[string]json.RawMessage
+		if err := roundTrip(schedule, &src); err != nil {
+			return err
+		}
+		if err := roundTrip(stored, &dst); err != nil {
+			return err
+		}
+		for _, field := range fields {
+			if value, ok := src[field]; ok {
+				dst[field] = value
+			} else {
+				delete(dst, field)
+			}
+		}
+		id := stored.ID
+		*stored = models.Schedule{}
+		if err := roundTrip(dst, stored); err != nil {
+			return err
+		}
+		stored.ID = id
+		stored.NextRunTime = nil
+		return nil
+	})
+}
+
+func roundTrip(from, to interface{}) error {
+	raw, err := json.Marshal(from)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(raw, to)
+}
+
+func (s *ScheduleStore) Delete(ctx context.Context, id string) error {
+	res, err := s.db.ExecContext(ctx, `DELETE FROM schedules WHERE id = ?`, id)
+	if err != nil {
+		return err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return store.ErrNotFound
+	}
+	return nil
+}
+
+func (s *ScheduleStore) Pause(ctx context.Context, id string, at time.Time) error {
+	return s.modify(ctx, id, func(schedule *models.Schedule) error {
+		schedule.State = models.ScheduleStatePaused
+		schedule.PausedAt = &at
+		return nil
+	})
+}
+
+func (s *ScheduleStore) Resume(ctx context.Context, id string) error {
+	return s.modify(ctx, id, func(schedule *models.Schedule) error {
+		schedule.State = models.ScheduleStateActive
+		schedule.PausedAt = nil
+		schedule.NextRunTime = nil
+		return nil
+	})
+}
+
+func (s *ScheduleStore) ExcludeRunTime(ctx context.Context, id string, runTime time.Time) error {
+	return s.modify(ctx, id, func(schedule *models.Schedule) error {
+		for _, excluded := range schedule.ExcludedRunTimes {
+			if excluded.Equal(runTime) {
+				return nil
+			}
+		}
+		schedule.ExcludedRunTimes = append(schedule.ExcludedRunTimes, runTime.UTC())
+		schedule.NextRunTime = nil
+		return nil
+	})
+}
+
+func (s *ScheduleStore) IncludeRunTime(ctx context.Context, id string, runTime time.Time) error {
+	return s.modify(ctx, id, func(schedule *models.Schedule) error {
+		kept := schedule.ExcludedRunTimes[:0]
+		for _, excluded := range schedule.ExcludedRunTimes {
+			if !excluded.Equal(runTime) {
+				kept = append(kept, excluded)
+			}
+		}
+		schedule.ExcludedRunTimes = kept
+		schedule.NextRunTime = nil
+		return nil
+	})
+}
+
+func (s *ScheduleStore) ForEachDue(ctx context.Context, before time.Time, fn func(*models.Schedule) error) error {
+	afterID := ""
+	for {
+		page, err := s.dueAfter(ctx, before, afterID)
+		if err != nil {
+			return err
+		}
+		for i := range page {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if err := fn(&page[i]); err != nil {
+				return err
+			}
+		}
+		if len(page) < pageSize {
+			return nil
+		}
+		afterID = page[len(page)-1].ID
+	}
+}
+
+// dueAfter reads the next page of due schedules, ordered by ID.
+func (s *ScheduleStore) dueAfter(ctx context.Context, before time.Time, afterID string) ([]models.Schedule, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT doc FROM schedules
+		WHERE state <> ? AND (next_run_time IS NULL AND NOT exhausted OR next_run_time < ?) AND id > ?
+		ORDER BY id LIMIT ?`,
+		models.ScheduleStatePaused, millis(before), afterID, pageSize)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var schedules []models.Schedule
+	for rows.Next() {
+		schedule, err := scanSchedule(rows)
+		if err != nil {
+			return nil, err
+		}
+		schedules = append(schedules, *schedule)
+	}
+	return schedules, rows.Err()
+}
+
+// SetWatermarks only updates schedules whose recurrence and next_run_time are
+// still the ones the watermark was computed from.
+func (s *ScheduleStore) SetWatermarks(ctx context.Context, watermarks []store.Watermark) error {
+	if len(watermarks) == 0 {
+		return nil
+	}
+	return withTx(ctx, s.db, func(tx *sql.Tx) error {
+		for _, w := range watermarks {
+			var exhausted bool
+			if err := tx.QueryRowContext(ctx,
+				`SELECT exhausted FROM schedules WHERE id = ?`, w.ScheduleID,
+			).Scan(&exhausted); errors.Is(err, sql.ErrNoRows) {
+				continue
+			} else if err != nil {
+				return err
+			}
+			schedule, err := getSchedule(ctx, tx, w.ScheduleID)
+			if err != nil {
+				return err
+			}
+			if exhausted || schedule.RRule != w.RRule || schedule.Timezone != w.Timezone ||
+				!sameTime(schedule.NextRunTime, w.PrevNextRunTime) {
+				continue
+			}
+			schedule.NextRunTime = w.NextRunTime
+			if w.LastEventTime != nil {
+				schedule.LastEventTime = w.LastEventTime
+			}
+			if !w.PruneExcludedBefore.IsZero() {
+				kept := schedule.ExcludedRunTimes[:0]
+				for _, excluded := range schedule.ExcludedRunTimes {
+					if !excluded.Before(w.PruneExcludedBefore) {
+						kept = append(kept, excluded)
+					}
+				}
+				schedule.ExcludedRunTimes = kept
+			}
+			if err := putSchedule(ctx, tx, schedule, w.NextRunTime == nil); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func sameTime(a, b *time.Time) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Equal(*b)
+}
@@ -0,0 +1,145 @@
+package sqlitestore_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cankoe/rrule-scheduler/internal/models"
+	"github.com/cankoe/rrule-scheduler/internal/store"
+	"github.com/cankoe/rrule-scheduler/internal/store/sqlitestore"
+)
+
+func TestSetWatermarksSkipsChangedSchedules(t *testing.T) {
+	ctx := context.Background()
+	schedules := sqlitestore.NewScheduleStore(openTestDB(t))
+	now := time.Now().UTC().Truncate(time.Second)
+
+	create := func(name string) *models.Schedule {
+		next := now.Add(time.Hour)
+		schedule := &models.Schedule{
+			Name:        name,
+			RRule:       "FREQ=HOURLY",
+			CallbackURL: "http://localhost/callback",
+			NextRunTime: &next,
+			CreatedAt:   now,
+		}
+		if _, err := schedules.Create(ctx, schedule); err != nil {
+			t.Fatalf("create schedule: %v", err)
+		}
+		read, err := schedules.Get(ctx, schedule.ID)
+		if err != nil {
+			t.Fatalf("get schedule: %v", err)
+		}
+		return read
+	}
+	watermarkFor := func(schedule *models.Schedule) store.Watermark {
+		next := now.Add(2 * time.Hour)
+		return store.Watermark{
+			ScheduleID:      schedule.ID,
+			RRule:           schedule.RRule,
+			Timezone:        schedule.Timezone,
+			PrevNextRunTime: schedule.NextRunTime,
+			NextRunTime:     &next,
+			LastEventTime:   &next,
+		}
+	}
+
+	unchanged := create("unchanged")
+	updated := create("updated")
+	resumed := create("resumed")
+	excluded := create("excluded")
+
+	changed := *updated
+	changed.RRule = "FREQ=DAILY"
+	if err := schedules.Update(ctx, &changed, []string{"rrule"}); err != nil {
+		t.Fatalf("update schedule: %v", err)
+	}
+	if err := schedules.Resume(ctx, resumed.ID); err != nil {
+		t.Fatalf("resume schedule: %v", err)
+	}
+	if err := schedules.ExcludeRunTime(ctx, excluded.ID, now.Add(time.Hour)); err != nil {
+		t.Fatalf("exclude run time: %v", err)
+	}
+
+	// Watermarks computed from the schedules as they were read
+	if err := schedules.SetWatermarks(ctx, []store.Watermark{
+		watermarkFor(unchanged), watermarkFor(updated), watermarkFor(resumed), watermarkFor(excluded),
+	}); err != nil {
+		t.Fatalf("set watermarks: %v", err)
+	}
+
+	for _, tt := range []struct {
+		schedule *models.Schedule
+		want     *time.Time
+	}{
+		{schedule: unchanged, want: watermarkFor(unchanged).NextRunTime},
+		{schedule: updated, want: nil},
+		{schedule: resumed, want: nil},
+		{schedule: excluded, want: nil},
+	} {
+		stored, err := schedules.Get(ctx, tt.schedule.ID)
+		if err != nil {
+			t.Fatalf("get schedule: %v", err)
+		}
+		if (stored.NextRunTime == nil) != (tt.want == nil) ||
+			stored.NextRunTime != nil && !stored.NextRunTime.Equal(*tt.want) {
+			t.Errorf("%s: got next_run_time %v, want %v", stored.Name, stored.NextRunTime, tt.want)
+		}
+	}
+}
+
+func TestForEachDueSkipsExhaustedSchedules(t *testing.T) {
+	ctx := context.Background()
+	schedules := sqlitestore.NewScheduleStore(openTestDB(t))
+	now := time.Now().UTC().Truncate(time.Second)
+
+	exhaust := func(name string) *models.Schedule {
+		schedule := &models.Schedule{
+			Name:        name,
+			RRule:       "FREQ=HOURLY;COUNT=1",
+			CallbackURL: "http://localhost/callback",
+			CreatedAt:   now,
+		}
+		if _, err := schedules.Create(ctx, schedule); err != nil {
+			t.Fatalf("create schedule: %v", err)
+		}
+		if err := schedules.SetWatermarks(ctx, []store.Watermark{{
+			ScheduleID: schedule.ID,
+			RRule:      schedule.RRule,
+			Timezone:   schedule.Timezone,
+		}}); err != nil {
+			t.Fatalf("set watermarks: %v", err)
+		}
+		return schedule
+	}
+
+	exhaust("unchanged")
+	updated := exhaust("updated")
+	resumed := exhaust("resumed")
+	excluded := exhaust("excluded")
+
+	changed := *updated
+	changed.RRule = "FREQ=HOURLY"
+	if err := schedules.Update(ctx, &changed, []string{"rrule"}); err != nil {
+		t.Fatalf("update schedule: %v", err)
+	}
+	if err := schedules.Resume(ctx, resumed.ID); err != nil {
+		t.Fatalf("resume schedule: %v", err)
+	}
+	if err := schedules.ExcludeRunTime(ctx, excluded.ID, now); err != nil {
+		t.Fatalf("exclude run time: %v", err)
+	}
+
+	var due []string
+	if err := schedules.ForEachDue(ctx, now, func(schedule *models.Schedule) error {
+		due = append(due, schedule.Name)
+		return nil
+	}); err != nil {
+		t.Fatalf("for each due: %v", err)
+	}
+	if want := []string{"updated", "resumed", "excluded"}; strings.Join(due, ",") != strings.Join(want, ",") {
+		t.Errorf("got due schedules %v, want %v", due, want)
+	}
+}
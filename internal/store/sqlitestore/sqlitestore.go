@@ -0,0 +1,181 @@
+// Package sqlitestore implements the schedule and event stores on an embedded
+// SQLite database, for single-node deployments.
+package sqlitestore
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+//go:embed migrations/*.sql
+var migrations embed.FS
+
+func init() {
+	// SQLite parses the REGEXP operator but leaves the implementation to the
+	// application. Registered functions are available on every connection
+	// opened afterwards.
+	if err := sqlite.RegisterDeterministicScalarFunction("regexp", 2, regexpFunc); err != nil {
+		panic(err)
+	}
+}
+
+// patterns caches the compiled REGEXP patterns, which are evaluated per row.
+var patterns sync.Map
+
+// regexpFunc implements "value REGEXP pattern", which SQLite calls as
+// regexp(pattern, value).
+func regexpFunc(ctx *sqlite.FunctionContext, args []driver.Value) (driver.Value, error) {
+	pattern, ok := args[0].(string)
+	if !ok {
+		return nil, fmt.Errorf("REGEXP pattern must be text")
+	}
+	value, ok := args[1].(string)
+	if !ok {
+		return false, nil
+	}
+	re, cached := patterns.Load(pattern)
+	if !cached {
+		compiled, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, err
+		}
+		re, _ = patterns.LoadOrStore(pattern, compiled)
+	}
+	return re.(*regexp.Regexp).MatchString(value), nil
+}
+
+// Migrate brings the schema up to date. Migrations are the files in
+// migrations/, applied in order of their numeric prefix, each in its own
+// transaction, and recorded in schema_migrations.
+func Migrate(ctx context.Context, db *sql.DB) error {
+	files, err := fs.Glob(migrations, "migrations/*.sql")
+	if err != nil {
+		return err
+	}
+	sort.Strings(files)
+
+	if _, err := db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version    INTEGER PRIMARY KEY,
+		applied_at TEXT NOT NULL DEFAULT CURRENT_TIMESTAMP
+	)`); err != nil {
+		return fmt.Errorf("failed to create schema_migrations: %w", err)
+	}
+
+	for _, file := range files {
+		name := strings.TrimPrefix(file, "migrations/")
+		prefix, _, _ := strings.Cut(name, "_")
+		version, err := strconv.Atoi(prefix)
+		if err != nil {
+			return fmt.Errorf("invalid migration file name %q", name)
+		}
+		script, err := migrations.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		applied, err := applyMigration(ctx, db, version, string(script))
+		if err != nil {
+			return fmt.Errorf("failed to apply migration %s: %w", name, err)
+		}
+		if applied {
+			log.Info().Str("migration", name).Msg("Applied database migration")
+		}
+	}
+	return nil
+}
+
+// applyMigration runs the script unless the version was already applied.
+func applyMigration(ctx context.Context, db *sql.DB, version int, script string) (bool, error) {
+	applied := false
+	err := withTx(ctx, db, func(tx *sql.Tx) error {
+		var exists bool
+		if err := tx.QueryRowContext(ctx,
+			"SELECT EXISTS (SELECT 1 FROM schema_migrations WHERE version = ?)", version,
+		).Scan(&exists); err != nil || exists {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, script); err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, "INSERT INTO schema_migrations (version) VALUES (?)", version); err != nil {
+			return err
+		}
+		applied = true
+		return nil
+	})
+	return applied, err
+}
+
+// withTx runs fn in a transaction, which is committed if fn succeeds.
+func withTx(ctx context.Context, db *sql.DB, fn func(*sql.Tx) error) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := fn(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// isUniqueViolation reports whether the statement failed on a unique index.
+func isUniqueViolation(err error) bool {
+	var sqliteErr *sqlite.Error
+	return errors.As(err, &sqliteErr) &&
+		(sqliteErr.Code() == sqlite3.SQLITE_CONSTRAINT_UNIQUE || sqliteErr.Code() == sqlite3.SQLITE_CONSTRAINT_PRIMARYKEY)
+}
+
+// builder collects the conditions and arguments of a statement.
+type builder struct {
+	conditions []string
+	args       []interface{}
+}
+
+// arg adds an argument and returns its placeholder.
+func (b *builder) arg(v interface{}) string {
+	b.args = append(b.args, v)
+	return "?"
+}
+
+func (b *builder) where(condition string) {
+	b.conditions = append(b.conditions, condition)
+}
+
+func (b *builder) whereClause() string {
+	if len(b.conditions) == 0 {
+		return ""
+	}
+	return " WHERE " + strings.Join(b.conditions, " AND ")
+}
+
+// millis converts a time to the Unix milliseconds stored in time columns.
+func millis(t time.Time) int64 {
+	return t.UnixMilli()
+}
+
+func millisPtr(t *time.Time) interface{} {
+	if t == nil {
+		return nil
+	}
+	return t.UnixMilli()
+}
+
+// pageSize is how many rows the iterating methods read at a time. The rows
+// are read before the callback runs, so it can write to the database.
+const pageSize = 500
@@ -0,0 +1,151 @@
+// Package store defines how schedules and events are persisted. The services
+// only talk to these interfaces; mongostore is the production implementation
+// and memstore keeps everything in process.
+package store
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/cankoe/rrule-scheduler/internal/models"
+)
+
+var (
+	// ErrNotFound is returned when the requested schedule or event does not exist.
+	ErrNotFound = errors.New("not found")
+	// ErrDuplicate is returned when an event with the same ID, or the same
+	// schedule and run time, already exists.
+	ErrDuplicate = errors.New("duplicate")
+)
+
+// Sort keys of a schedule listing. Creation order is the order of the IDs.
+const (
+	SortCreatedAt   = "created_at"
+	SortName        = "name"
+	SortCallbackURL = "callback_url"
+)
+
+// ScheduleQuery filters, sorts and pages a schedule listing.
+type ScheduleQuery struct {
+	NamePrefix    string
+	CallbackHost  string
+	Method        string
+	State         string
+	Labels        map[string]string
+	CreatedAfter  time.Time
+	CreatedBefore time.Time
+	Sort          string
+	Descending    bool
+	// After continues a listing just past the given schedule.
+	After *ScheduleCursor
+	Limit int
+}
+
+// ScheduleCursor is the position of a schedule in a sorted listing: its value
+// of the sort field (unused when sorting by creation) and its ID.
+type ScheduleCursor struct {
+	Value string
+	ID    string
+}
+
+// Watermark advances the prequeuer's bookkeeping of a schedule. It is only
+// applied while the schedule's recurrence and next_run_time are still the ones
+// the prequeuer read, i.e. the schedule was not changed in the meantime.
+type Watermark struct {
+	ScheduleID string
+	RRule      string
+	Timezone   string
+	// PrevNextRunTime is the next_run_time the prequeuer read (nil if unset).
+	PrevNextRunTime *time.Time
+
+	// NextRunTime is nil for schedules without occurrences left, which
+	// ForEachDue skips until they are changed.
+	NextRunTime   *time.Time
+	LastEventTime *time.Time
+	// PruneExcludedBefore drops excluded run times before it, unless zero.
+	PruneExcludedBefore time.Time
+}
+
+// ScheduleStore persists schedules.
+type ScheduleStore interface {
+	EnsureIndexes(ctx context.Context) error
+
+	// Create stores a new schedule and returns its ID.
+	Create(ctx context.Context, s *models.Schedule) (string, error)
+	Get(ctx context.Context, id string) (*models.Schedule, error)
+	List(ctx context.Context, q *ScheduleQuery) ([]models.Schedule, error)
+	// Update writes the given top-level fields (by their JSON name) of s and
+	// clears next_run_time, so the prequeuer recomputes the schedule.
+	Update(ctx context.Context, s *models.Schedule, fields []string) error
+	Delete(ctx context.Context, id string) error
+
+	Pause(ctx context.Context, id string, at time.Time) error
+	// Resume activates the schedule and clears next_run_time.
+	Resume(ctx context.Context, id string) error
+	// ExcludeRunTime adds the run time to excluded_run_times and clears
+	// next_run_time, so that a prequeuer run that read the schedule before
+	// does not move its watermark past the excluded run time.
+	ExcludeRunTime(ctx context.Context, id string, runTime time.Time) error
+	// IncludeRunTime undoes ExcludeRunTime.
+	IncludeRunTime(ctx context.Context, id string, runTime time.Time) error
+
+	// ForEachDue calls fn for every schedule that is not paused and whose
+	// next_run_time is before the given time or not computed yet.
+	ForEachDue(ctx context.Context, before time.Time, fn func(*models.Schedule) error) error
+	// SetWatermarks applies the watermarks whose schedules did not change.
+	SetWatermarks(ctx context.Context, watermarks []Watermark) error
+}
+
+// EventFilter selects events of a listing, sorted by run time (latest first
+// unless Ascending).
+type EventFilter struct {
+	ScheduleID string
+	// Status matches the latest status entry of the event.
+	Status    string
+	From      time.Time
+	To        time.Time
+	Ascending bool
+	Skip      int
+	// Limit caps the number of events; zero means no limit.
+	Limit int
+}
+
+// EventStore persists the pending events and the archive of finished ones.
+type EventStore interface {
+	EnsureIndexes(ctx context.Context) error
+
+	// Insert stores a new pending event and returns its ID.
+	Insert(ctx context.Context, e *models.Event) (string, error)
+	// CreateIfAbsent stores the events whose schedule and run time do not
+	// exist yet, and returns the ones it created with their IDs. On error,
+	// the events created before the error are still returned.
+	CreateIfAbsent(ctx context.Context, events []models.Event) ([]models.Event, error)
+
+	Get(ctx context.Context, id string) (*models.Event, error)
+	GetArchived(ctx context.Context, id string) (*models.Event, error)
+	// GetByRunTime returns the pending, not manually triggered event of the
+	// schedule at the given run time.
+	GetByRunTime(ctx context.Context, scheduleID string, runTime time.Time) (*models.Event, error)
+	List(ctx context.Context, f *EventFilter) ([]models.Event, error)
+	ListArchived(ctx context.Context, f *EventFilter) ([]models.Event, error)
+	// ForEach calls fn for every pending event.
+	ForEach(ctx context.Context, fn func(*models.Event) error) error
+
+	// PushStatus appends a status entry to the pending event.
+	PushStatus(ctx context.Context, id string, entry models.StatusEntry) error
+	// PushStatusMany appends the same status entry to several pending events.
+	PushStatusMany(ctx context.Context, ids []string, entry models.StatusEntry) error
+	RecordAttempt(ctx context.Context, id string, record models.AttemptRecord) error
+	// ScheduleRetry appends the status entry and records the attempt count
+	// and the time of the next attempt.
+	ScheduleRetry(ctx context.Context, id string, attempt int, retryAt time.Time, entry models.StatusEntry) error
+
+	// Archive appends the final status entry and moves the event to the archive.
+	Archive(ctx context.Context, id string, entry models.StatusEntry) error
+	// Restore moves an archived event back to the pending events, appends the
+	// status entry and resets its attempt count and retry time.
+	Restore(ctx context.Context, id string, entry models.StatusEntry) error
+	// DeleteBySchedule deletes the pending events of a schedule.
+	DeleteBySchedule(ctx context.Context, scheduleID string) error
+}
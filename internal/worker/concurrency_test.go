@@ -0,0 +1,239 @@
+package worker
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cankoe/rrule-scheduler/internal/models"
+	"github.com/cankoe/rrule-scheduler/internal/queue"
+	"github.com/cankoe/rrule-scheduler/internal/store/memstore"
+)
+
+// concurrencyEnv runs two events of one schedule against a callback that
+// keeps the first one running until it is released.
+type concurrencyEnv struct {
+	t         *testing.T
+	processor *eventProcessor
+	events    *memstore.EventStore
+	queues    *queue.MemoryQueues
+	first     string
+	second    string
+	// started receives the body of every callback request as it arrives.
+	started chan string
+	// release lets the first callback respond.
+	release chan struct{}
+	// cancelled is closed when the first callback's request is cancelled.
+	cancelled chan struct{}
+}
+
+func newConcurrencyEnv(t *testing.T, policy string) *concurrencyEnv {
+	t.Helper()
+	ctx := context.Background()
+	env := &concurrencyEnv{
+		t:         t,
+		events:    memstore.NewEventStore(),
+		queues:    queue.NewMemoryQueues(),
+		started:   make(chan string, 4),
+		release:   make(chan struct{}),
+		cancelled: make(chan struct{}),
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		env.started <- string(body)
+		if string(body) != "first" {
+			return
+		}
+		select {
+		case <-env.release:
+		case <-r.Context().Done():
+			close(env.cancelled)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	schedules := memstore.NewScheduleStore()
+	schedule := &models.Schedule{
+		Name:              "overlapping",
+		RRule:             "FREQ=MINUTELY",
+		CallbackURL:       server.URL,
+		Method:            http.MethodPost,
+		ConcurrencyPolicy: policy,
+		CreatedAt:         time.Now().UTC(),
+	}
+	if _, err := schedules.Create(ctx, schedule); err != nil {
+		t.Fatalf("create schedule: %v", err)
+	}
+	runTime := time.Now().UTC().Truncate(time.Minute)
+	for i, name := range []string{"first", "second"} {
+		body := name
+		event := &models.Event{
+			ScheduleID: schedule.ID,
+			RunTime:    runTime.Add(time.Duration(i) * time.Minute),
+			Overrides:  &models.CallbackOverrides{Body: &body},
+			CreatedAt:  time.Now().UTC(),
+		}
+		if _, err := env.events.Insert(ctx, event); err != nil {
+			t.Fatalf("insert event: %v", err)
+		}
+		if i == 0 {
+			env.first = event.ID
+		} else {
+			env.second = event.ID
+		}
+	}
+
+	env.processor = &eventProcessor{
+		queues:        env.queues,
+		locker:        queue.NewMemoryScheduleLocker(),
+		scheduleStore: schedules,
+		eventStore:    env.events,
+		httpClient:    server.Client(),
+		retryDefaults: models.RetryPolicy{MaxAttempts: 1},
+		lockTTL:       300 * time.Millisecond,
+	}
+	return env
+}
+
+// startFirst processes the first event in the background and waits until its
+// callback is running. The returned function waits for it to finish.
+func (env *concurrencyEnv) startFirst() (wait func()) {
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		env.processor.process(context.Background(), env.first)
+	}()
+	env.expectCallback("first")
+	return wg.Wait
+}
+
+func (env *concurrencyEnv) expectCallback(body string) {
+	env.t.Helper()
+	select {
+	case got := <-env.started:
+		if got != body {
+			env.t.Fatalf("callback %q started, want %q", got, body)
+		}
+	case <-time.After(5 * time.Second):
+		env.t.Fatalf("callback %q did not start", body)
+	}
+}
+
+func (env *concurrencyEnv) expectNoCallback() {
+	env.t.Helper()
+	select {
+	case got := <-env.started:
+		env.t.Fatalf("callback %q started, want none", got)
+	default:
+	}
+}
+
+// archivedStatus returns the final status of an archived event.
+func (env *concurrencyEnv) archivedStatus(eventID string) models.StatusEntry {
+	env.t.Helper()
+	event, err := env.events.GetArchived(context.Background(), eventID)
+	if err != nil {
+		env.t.Fatalf("event %s is not archived: %v", eventID, err)
+	}
+	return event.Status[len(event.Status)-1]
+}
+
+func TestConcurrencyPolicyAllowRunsBoth(t *testing.T) {
+	env := newConcurrencyEnv(t, models.ConcurrencyPolicyAllow)
+	wait := env.startFirst()
+
+	env.processor.process(context.Background(), env.second)
+	env.expectCallback("second")
+	if status := env.archivedStatus(env.second); status.Status != "completed" {
+		t.Errorf("second event status = %+v, want completed", status)
+	}
+
+	close(env.release)
+	wait()
+	if status := env.archivedStatus(env.first); status.Status != "completed" {
+		t.Errorf("first event status = %+v, want completed", status)
+	}
+}
+
+func TestConcurrencyPolicyForbidSkipsOverlappingEvent(t *testing.T) {
+	env := newConcurrencyEnv(t, models.ConcurrencyPolicyForbid)
+	wait := env.startFirst()
+
+	env.processor.process(context.Background(), env.second)
+	env.expectNoCallback()
+	status := env.archivedStatus(env.second)
+	if status.Status != "skipped" || !strings.Contains(status.Message, env.first) {
+		t.Errorf("second event status = %+v, want skipped for %s", status, env.first)
+	}
+
+	close(env.release)
+	wait()
+	if status := env.archivedStatus(env.first); status.Status != "completed" {
+		t.Errorf("first event status = %+v, want completed", status)
+	}
+}
+
+func TestConcurrencyPolicyQueueDefersOverlappingEvent(t *testing.T) {
+	ctx := context.Background()
+	env := newConcurrencyEnv(t, models.ConcurrencyPolicyQueue)
+	wait := env.startFirst()
+
+	env.processor.process(ctx, env.second)
+	env.expectNoCallback()
+	event, err := env.events.Get(ctx, env.second)
+	if err != nil {
+		t.Fatalf("second event is no longer pending: %v", err)
+	}
+	if status := event.Status[len(event.Status)-1]; status.Status != "waiting" || !strings.Contains(status.Message, env.first) {
+		t.Errorf("second event status = %+v, want waiting for %s", status, env.first)
+	}
+	if ready, _ := env.queues.Scheduled(ctx); !slices.Equal(ready, []string{env.second}) {
+		t.Errorf("ready_queue = %v, want the second event back in it", ready)
+	}
+
+	// Still waiting while the first one runs, without recording it again.
+	env.processor.process(ctx, env.second)
+	env.expectNoCallback()
+	if again, _ := env.events.Get(ctx, env.second); len(again.Status) != len(event.Status) {
+		t.Errorf("second event got %d statuses, want %d", len(again.Status), len(event.Status))
+	}
+
+	close(env.release)
+	wait()
+	env.processor.process(ctx, env.second)
+	env.expectCallback("second")
+	if status := env.archivedStatus(env.second); status.Status != "completed" {
+		t.Errorf("second event status = %+v, want completed", status)
+	}
+}
+
+func TestConcurrencyPolicyReplaceCancelsRunningEvent(t *testing.T) {
+	env := newConcurrencyEnv(t, models.ConcurrencyPolicyReplace)
+	wait := env.startFirst()
+
+	env.processor.process(context.Background(), env.second)
+	env.expectCallback("second")
+	if status := env.archivedStatus(env.second); status.Status != "completed" {
+		t.Errorf("second event status = %+v, want completed", status)
+	}
+
+	// The first callback is cancelled once its lock heartbeat notices.
+	select {
+	case <-env.cancelled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("first callback was not cancelled")
+	}
+	wait()
+	status := env.archivedStatus(env.first)
+	if status.Status != "cancelled" || !strings.Contains(status.Message, "replaced by a newer event") {
+		t.Errorf("first event status = %+v, want cancelled as replaced", status)
+	}
+}
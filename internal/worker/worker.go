@@ -3,49 +3,82 @@ package worker
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
+	"slices"
+	"strings"
 	"time"
+	"unicode/utf8"
 
 	"github.com/cankoe/rrule-scheduler/internal/events"
+	"github.com/cankoe/rrule-scheduler/internal/models"
+	"github.com/cankoe/rrule-scheduler/internal/queue"
+	"github.com/cankoe/rrule-scheduler/internal/store"
 
 	"sync"
 
-	"github.com/go-redis/redis/v8"
 	"github.com/rs/zerolog/log"
-	"go.mongodb.org/mongo-driver/bson"
-	"go.mongodb.org/mongo-driver/bson/primitive"
-	"go.mongodb.org/mongo-driver/mongo"
 )
 
-// EnsureIndexes ensures indexes needed by the worker logic.
-func EnsureIndexes(eventsCol, schedulesCol *mongo.Collection) error {
-	ctx := context.Background()
-	if _, err := eventsCol.Indexes().CreateOne(ctx, mongo.IndexModel{
-		Keys: bson.D{{Key: "run_time", Value: 1}},
-	}); err != nil {
-		return fmt.Errorf("failed to create index on events.run_time: %w", err)
-	}
+// maxMatchedBodyBytes caps how much of a response body is read to evaluate
+// the body_match success criterion.
+const maxMatchedBodyBytes = 1 << 20
 
-	if _, err := schedulesCol.Indexes().CreateOne(ctx, mongo.IndexModel{
-		Keys: bson.D{{Key: "last_event_time", Value: 1}},
-	}); err != nil {
-		return fmt.Errorf("failed to create index on schedules.last_event_time: %w", err)
-	}
+// maxCapturedBytes caps the response body and error message stored with each
+// attempt.
+const maxCapturedBytes = 4 << 10
 
-	log.Info().Msg("Indexes ensured successfully")
-	return nil
+// capturedHeaders are the response headers stored with each attempt.
+var capturedHeaders = []string{
+	"Content-Type",
+	"Content-Length",
+	"Date",
+	"Location",
+	"Retry-After",
+	"X-Request-Id",
+	"X-Correlation-Id",
 }
 
+// reapBatchSize is how many expired leases are re-enqueued per queue call.
+const reapBatchSize = 100
+
+// lockWaitDelay is how long an event waits in the ready_queue before it tries
+// again to take the lock of its schedule.
+const lockWaitDelay = 5 * time.Second
+
+// errReplaced cancels a running callback whose schedule lock was taken over
+// by a newer event (concurrency policy replace).
+var errReplaced = errors.New("replaced by a newer event of the schedule")
+
 // EventWorker continuously polls worker_queue for events and performs callbacks.
+// Every claimed event is leased for visibilityTimeout and the lease is kept
+// alive while the callback runs; if the worker dies, the LeaseReaper puts the
+// event back into the worker_queue. Failed callbacks are put back into the
+// ready_queue with an exponential backoff until the schedule's retry policy
+// (or retryDefaults) is exhausted.
 func EventWorker(ctx context.Context,
 	wg *sync.WaitGroup,
-	redisClient *redis.Client,
-	eventsCol, archivedEventsCol, schedulesCol *mongo.Collection,
-	workerID, maxRetries int,
+	queues queue.Queues,
+	locker queue.ScheduleLocker,
+	scheduleStore store.ScheduleStore,
+	eventStore store.EventStore,
+	workerID int,
+	retryDefaults models.RetryPolicy,
+	visibilityTimeout time.Duration,
 ) {
 	defer wg.Done()
-	httpClient := &http.Client{}
+	p := &eventProcessor{
+		queues:        queues,
+		locker:        locker,
+		scheduleStore: scheduleStore,
+		eventStore:    eventStore,
+		httpClient:    &http.Client{},
+		workerID:      workerID,
+		retryDefaults: retryDefaults,
+		lockTTL:       visibilityTimeout,
+	}
 
 	for {
 		select {
@@ -55,9 +88,9 @@ func EventWorker(ctx context.Context,
 		default:
 		}
 
-		eventID, err := redisClient.RPop(ctx, "worker_queue").Result()
+		eventID, err := queues.Claim(ctx, visibilityTimeout)
 		if err != nil {
-			if err == redis.Nil {
+			if errors.Is(err, queue.ErrEmpty) {
 				log.Debug().Int("worker_id", workerID).Msg("No events in queue, retrying...")
 				time.Sleep(1 * time.Second)
 			} else {
@@ -66,100 +99,418 @@ func EventWorker(ctx context.Context,
 			continue
 		}
 
-		// Fetch the event doc
-		objectID, err := primitive.ObjectIDFromHex(eventID)
-		if err != nil {
-			log.Error().Err(err).Int("worker_id", workerID).Str("event_id", eventID).Msg("Invalid ObjectID format")
-			events.RecordErrorStatus(ctx, eventsCol, archivedEventsCol,
-				eventID, "Invalid event ObjectID: "+err.Error())
-			continue
+		stopHeartbeat := p.keepLeaseAlive(ctx, eventID, visibilityTimeout)
+		p.process(ctx, eventID)
+		stopHeartbeat()
+
+		// Leave the lease in place on shutdown; the reaper re-enqueues the event.
+		if ctx.Err() == nil {
+			if err := queues.Ack(ctx, eventID); err != nil {
+				log.Error().Err(err).Int("worker_id", workerID).Str("event_id", eventID).Msg("Failed to release event lease")
+			}
 		}
-		var eventDoc bson.M
-		if err := eventsCol.FindOne(ctx, bson.M{"_id": objectID}).Decode(&eventDoc); err != nil {
-			log.Error().Err(err).Int("worker_id", workerID).Str("event_id", eventID).Msg("Failed to retrieve event")
-			events.RecordErrorStatus(ctx, eventsCol, archivedEventsCol,
-				eventID, "Failed to retrieve event: "+err.Error())
-			continue
+	}
+}
+
+// keepLeaseAlive extends the lease of the event until the returned function
+// is called, so that slow callbacks are not mistaken for crashed workers.
+func (p *eventProcessor) keepLeaseAlive(ctx context.Context, eventID string, visibilityTimeout time.Duration) func() {
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(visibilityTimeout / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := p.queues.ExtendLease(ctx, eventID, visibilityTimeout); err != nil {
+					log.Warn().Err(err).Int("worker_id", p.workerID).Str("event_id", eventID).Msg("Failed to extend event lease")
+				}
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		<-stopped
+	}
+}
+
+// LeaseReaper periodically re-enqueues events whose worker lease expired,
+// i.e. events held by a worker that crashed or was killed mid-callback.
+func LeaseReaper(ctx context.Context,
+	wg *sync.WaitGroup,
+	workQueue queue.WorkQueue,
+	eventStore store.EventStore,
+	interval time.Duration,
+) {
+	defer wg.Done()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			log.Info().Msg("Lease reaper stopped by cancellation")
+			return
+		case <-ticker.C:
+			reapExpiredLeases(ctx, workQueue, eventStore)
 		}
+	}
+}
 
-		// Fetch schedule doc
-		scheduleID, _ := eventDoc["schedule_id"].(string)
-		scheduleOID, err := primitive.ObjectIDFromHex(scheduleID)
+func reapExpiredLeases(ctx context.Context, workQueue queue.WorkQueue, eventStore store.EventStore) {
+	for {
+		eventIDs, err := workQueue.ReapExpired(ctx, time.Now(), reapBatchSize)
 		if err != nil {
-			log.Error().Err(err).Int("worker_id", workerID).Str("schedule_id", scheduleID).Msg("Invalid schedule OID")
-			events.RecordErrorStatus(ctx, eventsCol, archivedEventsCol,
-				eventID, "Invalid schedule ObjectID: "+err.Error())
-			continue
+			log.Error().Err(err).Msg("Failed to reap expired worker leases")
+			return
 		}
-		var scheduleDoc struct {
-			ID          string            `bson:"_id"`
-			CallbackURL string            `bson:"callback_url"`
-			Method      string            `bson:"method"`
-			Headers     map[string]string `bson:"headers"`
-			Body        string            `bson:"body"`
-		}
-		if err := schedulesCol.FindOne(ctx, bson.M{"_id": scheduleOID}).Decode(&scheduleDoc); err != nil {
-			log.Error().Err(err).Int("worker_id", workerID).Str("event_id", eventID).Msg("Failed to retrieve schedule")
-			events.RecordErrorStatus(ctx, eventsCol, archivedEventsCol,
-				eventID, "Failed to retrieve schedule: "+err.Error())
-			continue
+		for _, eventID := range eventIDs {
+			log.Warn().Str("event_id", eventID).Msg("Worker lease expired, event re-enqueued")
+			if err := events.UpdateEventStatus(ctx, eventStore, eventID, "worker_queue",
+				"Worker lease expired, event re-enqueued"); err != nil {
+				log.Error().Err(err).Str("event_id", eventID).Msg("Failed to record re-enqueued event")
+			}
 		}
+		if len(eventIDs) < reapBatchSize {
+			return
+		}
+	}
+}
+
+// eventProcessor holds what a worker needs to execute a single event.
+type eventProcessor struct {
+	queues        queue.Queues
+	locker        queue.ScheduleLocker
+	scheduleStore store.ScheduleStore
+	eventStore    store.EventStore
+	httpClient    *http.Client
+	workerID      int
+	retryDefaults models.RetryPolicy
+	lockTTL       time.Duration
+}
+
+// process performs one callback attempt for the event and then either
+// archives it or schedules the next attempt.
+func (p *eventProcessor) process(ctx context.Context, eventID string) {
+	workerID := p.workerID
+	eventStore := p.eventStore
 
-		if scheduleDoc.Method == "" {
-			scheduleDoc.Method = http.MethodGet
+	// Fetch the event doc
+	eventDoc, err := eventStore.Get(ctx, eventID)
+	if errors.Is(err, store.ErrNotFound) {
+		// A redelivered event that a previous worker already archived.
+		log.Warn().Int("worker_id", workerID).Str("event_id", eventID).Msg("Event no longer pending, skipping")
+		return
+	}
+	if err != nil {
+		log.Error().Err(err).Int("worker_id", workerID).Str("event_id", eventID).Msg("Failed to retrieve event")
+		events.RecordErrorStatus(ctx, eventStore,
+			eventID, "Failed to retrieve event: "+err.Error())
+		return
+	}
+
+	// Fetch schedule doc
+	scheduleDoc, err := p.scheduleStore.Get(ctx, eventDoc.ScheduleID)
+	if err != nil {
+		log.Error().Err(err).Int("worker_id", workerID).Str("event_id", eventID).Msg("Failed to retrieve schedule")
+		events.RecordErrorStatus(ctx, eventStore,
+			eventID, "Failed to retrieve schedule: "+err.Error())
+		return
+	}
+
+	if scheduleDoc.State == models.ScheduleStatePaused {
+		log.Info().Int("worker_id", workerID).Str("event_id", eventID).
+			Msg("Schedule is paused, cancelling event")
+		if err := events.UpdateAndArchiveEvent(ctx, eventStore,
+			eventID, "cancelled", "Event cancelled because schedule was paused"); err != nil {
+			log.Error().Err(err).Int("worker_id", workerID).Str("event_id", eventID).
+				Msg("Failed to cancel event of paused schedule")
 		}
+		return
+	}
 
-		var reqBody *bytes.Reader
-		if scheduleDoc.Body != "" {
-			reqBody = bytes.NewReader([]byte(scheduleDoc.Body))
-		} else {
-			reqBody = bytes.NewReader(nil)
+	// Skipped through the API after the prequeuer had read the schedule
+	if !eventDoc.Manual && slices.ContainsFunc(scheduleDoc.ExcludedRunTimes, eventDoc.RunTime.Equal) {
+		log.Info().Int("worker_id", workerID).Str("event_id", eventID).
+			Msg("Occurrence was skipped, cancelling event")
+		if err := events.UpdateAndArchiveEvent(ctx, eventStore,
+			eventID, "cancelled", "Event cancelled because its occurrence was skipped"); err != nil {
+			log.Error().Err(err).Int("worker_id", workerID).Str("event_id", eventID).
+				Msg("Failed to cancel event of skipped occurrence")
 		}
+		return
+	}
 
-		// Attempt callback with retries
-		var finalErr error
-		for attempt := 1; attempt <= maxRetries; attempt++ {
-			if attempt > 1 {
-				reqBody = bytes.NewReader([]byte(scheduleDoc.Body))
-			}
-			req, buildErr := http.NewRequest(scheduleDoc.Method, scheduleDoc.CallbackURL, reqBody)
-			if buildErr != nil {
-				finalErr = buildErr
-				break
-			}
-			for k, v := range scheduleDoc.Headers {
-				req.Header.Set(k, v)
+	callCtx, cancelCall := context.WithCancelCause(ctx)
+	defer cancelCall(nil)
+	if scheduleDoc.ConcurrencyPolicy != "" && scheduleDoc.ConcurrencyPolicy != models.ConcurrencyPolicyAllow {
+		if !p.lockSchedule(ctx, eventDoc, scheduleDoc.ConcurrencyPolicy) {
+			return
+		}
+		stopLock := p.keepScheduleLock(callCtx, cancelCall, eventDoc.ScheduleID, eventID)
+		defer func() {
+			stopLock()
+			if err := p.locker.Release(ctx, eventDoc.ScheduleID, eventID); err != nil {
+				log.Error().Err(err).Int("worker_id", workerID).Str("event_id", eventID).Msg("Failed to release schedule lock")
 			}
+		}()
+	}
+
+	if scheduleDoc.Method == "" {
+		scheduleDoc.Method = http.MethodGet
+	}
+	if o := eventDoc.Overrides; o != nil {
+		if o.Body != nil {
+			scheduleDoc.Body = *o.Body
+		}
+		if len(o.Headers) > 0 && scheduleDoc.Headers == nil {
+			scheduleDoc.Headers = make(map[string]string, len(o.Headers))
+		}
+		for k, v := range o.Headers {
+			scheduleDoc.Headers[k] = v
+		}
+	}
+	policy := scheduleDoc.RetryPolicy.WithDefaults(p.retryDefaults)
+	attempt := eventDoc.AttemptCount + 1
+
+	record := models.AttemptRecord{StartedAt: time.Now().UTC()}
+	req, callErr := http.NewRequest(scheduleDoc.Method, scheduleDoc.CallbackURL,
+		bytes.NewReader([]byte(scheduleDoc.Body)))
+	if callErr == nil {
+		for k, v := range scheduleDoc.Headers {
+			req.Header.Set(k, v)
+		}
+		record, callErr = performCallback(p.httpClient, req.WithContext(callCtx), scheduleDoc.SuccessCriteria)
+	}
+	record.Attempt = attempt
+	if callErr != nil {
+		record.Error, _ = truncate(callErr.Error(), maxCapturedBytes)
+	}
+	statusCode := record.StatusCode
+	if err := events.RecordAttempt(ctx, eventStore, eventID, record); err != nil {
+		log.Error().Err(err).Int("worker_id", workerID).Str("event_id", eventID).Msg("Failed to record callback attempt")
+	}
+
+	if errors.Is(context.Cause(callCtx), errReplaced) {
+		log.Info().Int("worker_id", workerID).Str("event_id", eventID).Msg("Callback replaced by a newer event")
+		if err := events.ArchiveEvent(ctx, eventStore, eventID, models.StatusEntry{
+			Status:     "cancelled",
+			Message:    "Callback cancelled: replaced by a newer event of the schedule (concurrency policy replace)",
+			StatusCode: statusCode,
+			Attempt:    attempt,
+		}); err != nil {
+			log.Error().Err(err).Str("event_id", eventID).Msg("Failed to record cancelled status")
+		}
+		return
+	}
+
+	if callErr == nil {
+		log.Info().Int("worker_id", workerID).Str("event_id", eventID).
+			Msg("Marking event as completed")
+		// Mark event as completed
+		err := events.ArchiveEvent(ctx, eventStore, eventID, models.StatusEntry{
+			Status:     "completed",
+			Message:    "Event successfully processed",
+			StatusCode: statusCode,
+			Attempt:    attempt,
+		})
+		if err != nil {
+			log.Error().Err(err).Int("worker_id", workerID).Str("event_id", eventID).
+				Msg("Failed to mark event as completed")
+			events.RecordErrorStatus(ctx, eventStore,
+				eventID, "Failed to update status to completed: "+err.Error())
+		}
+		return
+	}
+
+	if attempt >= policy.MaxAttempts {
+		log.Error().Err(callErr).Int("worker_id", workerID).Str("event_id", eventID).
+			Int("attempt", attempt).Msg("Callback failed after max attempts")
+		if err := events.ArchiveEvent(ctx, eventStore, eventID, models.StatusEntry{
+			Status:     "error",
+			Message:    fmt.Sprintf("Callback failed after %d attempts: %s", attempt, callErr),
+			StatusCode: statusCode,
+			Attempt:    attempt,
+		}); err != nil {
+			log.Error().Err(err).Str("event_id", eventID).Msg("Failed to record error status")
+		}
+		return
+	}
+
+	delay := policy.Delay(attempt)
+	retryAt := time.Now().UTC().Add(delay)
+	log.Warn().Err(callErr).Int("worker_id", workerID).Str("event_id", eventID).
+		Int("attempt", attempt).Dur("retry_in", delay).Msg("Callback attempt failed, scheduling retry")
+	if err := events.ScheduleRetry(ctx, eventStore, eventID, attempt, retryAt, models.StatusEntry{
+		Status: "retry_scheduled",
+		Message: fmt.Sprintf("Attempt %d/%d failed: %s; retrying in %s",
+			attempt, policy.MaxAttempts, callErr, delay.Round(time.Second)),
+		StatusCode: statusCode,
+		Attempt:    attempt,
+	}); err != nil {
+		events.RecordErrorStatus(ctx, eventStore,
+			eventID, "Failed to schedule retry: "+err.Error())
+		return
+	}
+	if err := p.queues.Reschedule(ctx, queue.Item{ID: eventID, DueAt: retryAt}); err != nil {
+		log.Error().Err(err).Int("worker_id", workerID).Str("event_id", eventID).Msg("Failed to requeue event for retry")
+		events.RecordErrorStatus(ctx, eventStore,
+			eventID, "Failed to requeue event for retry: "+err.Error())
+	}
+}
+
+// lockSchedule enforces the schedule's concurrency policy. It returns true if
+// the event may run; otherwise the event has been skipped or put back into the
+// ready_queue to wait for the running one.
+func (p *eventProcessor) lockSchedule(ctx context.Context, eventDoc *models.Event, policy string) bool {
+	eventID, scheduleID := eventDoc.ID, eventDoc.ScheduleID
+
+	if policy == models.ConcurrencyPolicyReplace {
+		previous, err := p.locker.TakeOver(ctx, scheduleID, eventID, p.lockTTL)
+		if err != nil {
+			p.waitForLock(ctx, eventDoc, "Failed to take over the schedule lock: "+err.Error())
+			return false
+		}
+		if previous != "" && previous != eventID {
+			log.Info().Int("worker_id", p.workerID).Str("event_id", eventID).Str("replaced_event_id", previous).
+				Msg("Replacing running event of the schedule")
+			_ = events.UpdateEventStatus(ctx, p.eventStore, eventID, "running",
+				fmt.Sprintf("Replacing still running event %s (concurrency policy replace)", previous))
+		}
+		return true
+	}
+
+	acquired, holder, err := p.locker.Acquire(ctx, scheduleID, eventID, p.lockTTL)
+	switch {
+	case err != nil:
+		p.waitForLock(ctx, eventDoc, "Failed to acquire the schedule lock: "+err.Error())
+		return false
+	case acquired:
+		return true
+	case policy == models.ConcurrencyPolicyForbid:
+		log.Info().Int("worker_id", p.workerID).Str("event_id", eventID).Str("running_event_id", holder).
+			Msg("Skipping event, previous event of the schedule is still running")
+		if err := events.UpdateAndArchiveEvent(ctx, p.eventStore, eventID, "skipped",
+			fmt.Sprintf("Skipped because event %s of the schedule was still running (concurrency policy forbid)", holder)); err != nil {
+			log.Error().Err(err).Str("event_id", eventID).Msg("Failed to record skipped status")
+		}
+	default:
+		p.waitForLock(ctx, eventDoc,
+			fmt.Sprintf("Waiting for event %s of the schedule to finish (concurrency policy queue)", holder))
+	}
+	return false
+}
 
-			resp, callErr := httpClient.Do(req)
-			if callErr == nil {
-				resp.Body.Close()
-				log.Info().Int("worker_id", workerID).Str("event_id", eventID).
-					Msg("Marking event as completed")
-				// Mark event as completed
-				err := events.UpdateAndArchiveEvent(ctx, eventsCol, archivedEventsCol,
-					eventID, "completed", "Event successfully processed")
+// waitForLock puts the event back into the ready_queue to try again shortly.
+// The reason is only recorded once per wait, not on every try.
+func (p *eventProcessor) waitForLock(ctx context.Context, eventDoc *models.Event, reason string) {
+	eventID := eventDoc.ID
+	if n := len(eventDoc.Status); n == 0 || eventDoc.Status[n-1].Message != reason {
+		if err := events.UpdateEventStatus(ctx, p.eventStore, eventID, "waiting", reason); err != nil {
+			log.Error().Err(err).Str("event_id", eventID).Msg("Failed to record waiting status")
+		}
+	}
+	if err := p.queues.Reschedule(ctx, queue.Item{ID: eventID, DueAt: time.Now().Add(lockWaitDelay)}); err != nil {
+		log.Error().Err(err).Int("worker_id", p.workerID).Str("event_id", eventID).Msg("Failed to requeue waiting event")
+		events.RecordErrorStatus(ctx, p.eventStore,
+			eventID, "Failed to requeue waiting event: "+err.Error())
+	}
+}
+
+// keepScheduleLock extends the schedule lock until the returned function is
+// called. If a newer event takes the lock over, the callback is cancelled.
+func (p *eventProcessor) keepScheduleLock(ctx context.Context, cancel context.CancelCauseFunc, scheduleID, eventID string) func() {
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(p.lockTTL / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				held, err := p.locker.Extend(ctx, scheduleID, eventID, p.lockTTL)
 				if err != nil {
-					log.Error().Err(err).Int("worker_id", workerID).Str("event_id", eventID).
-						Msg("Failed to mark event as completed")
-					events.RecordErrorStatus(ctx, eventsCol, archivedEventsCol,
-						eventID, "Failed to update status to completed: "+err.Error())
-				}
-				finalErr = nil
-				break
-			} else {
-				finalErr = callErr
-				if attempt < maxRetries {
+					log.Warn().Err(err).Int("worker_id", p.workerID).Str("event_id", eventID).Msg("Failed to extend schedule lock")
 					continue
 				}
+				if !held {
+					cancel(errReplaced)
+					return
+				}
 			}
 		}
+	}()
+	return func() {
+		close(done)
+		<-stopped
+	}
+}
+
+// performCallback sends the request and checks the response against the
+// schedule's success criteria. The returned record describes the response
+// whenever one was received, even if it does not count as a success.
+func performCallback(client *http.Client, req *http.Request, criteria *models.SuccessCriteria) (models.AttemptRecord, error) {
+	record := models.AttemptRecord{StartedAt: time.Now().UTC()}
+	resp, err := client.Do(req)
+	if err != nil {
+		record.LatencyMs = time.Since(record.StartedAt).Milliseconds()
+		return record, err
+	}
+	defer resp.Body.Close()
+
+	record.StatusCode = resp.StatusCode
+	for _, name := range capturedHeaders {
+		if values := resp.Header.Values(name); len(values) > 0 {
+			if record.Headers == nil {
+				record.Headers = make(map[string]string)
+			}
+			record.Headers[name] = strings.Join(values, ", ")
+		}
+	}
+
+	// Read one byte past the cap to tell whether the body was truncated.
+	limit := int64(maxCapturedBytes + 1)
+	if criteria.NeedsBody() {
+		limit = maxMatchedBodyBytes
+	}
+	body, readErr := io.ReadAll(io.LimitReader(resp.Body, limit))
+	record.LatencyMs = time.Since(record.StartedAt).Milliseconds()
+	record.Body, record.BodyTruncated = truncate(string(body), maxCapturedBytes)
+
+	if !criteria.AcceptsStatus(resp.StatusCode) {
+		return record, fmt.Errorf("unexpected response status %d", resp.StatusCode)
+	}
+	if criteria.NeedsBody() {
+		if readErr != nil {
+			return record, fmt.Errorf("failed to read response body: %w", readErr)
+		}
+		if !criteria.AcceptsBody(body) {
+			return record, errors.New("response body does not match success criteria")
+		}
+	}
+	return record, nil
+}
 
-		if finalErr != nil {
-			log.Error().Err(finalErr).Int("worker_id", workerID).Str("event_id", eventID).
-				Msg("Callback failed after max retries")
-			events.RecordErrorStatus(ctx, eventsCol, archivedEventsCol,
-				eventID, "Callback failed after max retries: "+finalErr.Error())
+// truncate cuts s to at most max bytes without splitting a rune, and reports
+// whether anything was cut. Invalid UTF-8 is replaced, as it cannot be stored.
+func truncate(s string, max int) (string, bool) {
+	truncated := len(s) > max
+	if truncated {
+		for max > 0 && !utf8.RuneStart(s[max]) {
+			max--
 		}
+		s = s[:max]
 	}
+	return strings.ToValidUTF8(s, "\uFFFD"), truncated
 }
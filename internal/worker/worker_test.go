@@ -0,0 +1,237 @@
+package worker
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cankoe/rrule-scheduler/internal/models"
+	"github.com/cankoe/rrule-scheduler/internal/queue"
+	"github.com/cankoe/rrule-scheduler/internal/store/memstore"
+)
+
+func TestPerformCallbackChecksSuccessCriteria(t *testing.T) {
+	tests := []struct {
+		name     string
+		status   int
+		body     string
+		criteria *models.SuccessCriteria
+		wantErr  string
+	}{
+		{name: "2xx by default", status: http.StatusNoContent},
+		{name: "non-2xx by default", status: http.StatusInternalServerError, wantErr: "unexpected response status 500"},
+		{
+			name:     "accepted status",
+			status:   http.StatusNotFound,
+			criteria: &models.SuccessCriteria{StatusCodes: []string{"2xx", "404"}},
+		},
+		{
+			name:     "rejected status",
+			status:   http.StatusOK,
+			criteria: &models.SuccessCriteria{StatusCodes: []string{"201"}},
+			wantErr:  "unexpected response status 200",
+		},
+		{
+			name:     "body matches",
+			status:   http.StatusOK,
+			body:     `{"status":"ok"}`,
+			criteria: &models.SuccessCriteria{BodyMatch: `"status":"ok"`},
+		},
+		{
+			name:     "body does not match",
+			status:   http.StatusOK,
+			body:     `{"status":"failed"}`,
+			criteria: &models.SuccessCriteria{BodyMatch: `"status":"ok"`},
+			wantErr:  "response body does not match success criteria",
+		},
+		{
+			name:     "status is checked before the body",
+			status:   http.StatusBadGateway,
+			body:     `{"status":"ok"}`,
+			criteria: &models.SuccessCriteria{BodyMatch: `"status":"ok"`},
+			wantErr:  "unexpected response status 502",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("X-Request-Id", "req-1")
+				w.WriteHeader(tt.status)
+				w.Write([]byte(tt.body))
+			}))
+			defer server.Close()
+
+			req, err := http.NewRequest(http.MethodGet, server.URL, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			record, err := performCallback(server.Client(), req, tt.criteria)
+			if tt.wantErr == "" && err != nil {
+				t.Fatalf("performCallback: %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || err.Error() != tt.wantErr) {
+				t.Fatalf("performCallback error = %v, want %q", err, tt.wantErr)
+			}
+			if record.StatusCode != tt.status {
+				t.Errorf("record status = %d, want %d", record.StatusCode, tt.status)
+			}
+			if record.Body != tt.body {
+				t.Errorf("record body = %q, want %q", record.Body, tt.body)
+			}
+			if record.Headers["X-Request-Id"] != "req-1" {
+				t.Errorf("record headers = %v, want X-Request-Id captured", record.Headers)
+			}
+		})
+	}
+}
+
+func TestPerformCallbackTruncatesCapturedBody(t *testing.T) {
+	body := strings.Repeat("a", maxCapturedBytes+10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(body))
+	}))
+	defer server.Close()
+
+	// The whole body is matched even though only the first bytes are stored.
+	criteria := &models.SuccessCriteria{BodyMatch: `a{1000}$`}
+	req, _ := http.NewRequest(http.MethodGet, server.URL, nil)
+	record, err := performCallback(server.Client(), req, criteria)
+	if err != nil {
+		t.Fatalf("performCallback: %v", err)
+	}
+	if !record.BodyTruncated || len(record.Body) != maxCapturedBytes {
+		t.Errorf("captured %d bytes, truncated %v; want %d bytes, truncated", len(record.Body), record.BodyTruncated, maxCapturedBytes)
+	}
+}
+
+// claimEvents stores n pending events and has them claimed with the given
+// visibility timeout, as a worker would.
+func claimEvents(t *testing.T, queues *queue.MemoryQueues, eventStore *memstore.EventStore, n int, visibility time.Duration) []string {
+	t.Helper()
+	ctx := context.Background()
+	var ids []string
+	for i := 0; i < n; i++ {
+		event := &models.Event{ScheduleID: "schedule", RunTime: time.Now().UTC(), Manual: true}
+		if _, err := eventStore.Insert(ctx, event); err != nil {
+			t.Fatalf("insert event: %v", err)
+		}
+		if err := queues.Push(ctx, event.ID); err != nil {
+			t.Fatalf("push event: %v", err)
+		}
+		if _, err := queues.Claim(ctx, visibility); err != nil {
+			t.Fatalf("claim event: %v", err)
+		}
+		ids = append(ids, event.ID)
+	}
+	return ids
+}
+
+func TestReapExpiredLeasesReenqueuesEvents(t *testing.T) {
+	ctx := context.Background()
+	queues := queue.NewMemoryQueues()
+	eventStore := memstore.NewEventStore()
+
+	// The worker holding this one died: its lease ran out.
+	crashed := claimEvents(t, queues, eventStore, 1, -2*time.Second)[0]
+	running := claimEvents(t, queues, eventStore, 1, time.Hour)[0]
+
+	reapExpiredLeases(ctx, queues, eventStore)
+
+	if waiting, _ := queues.Waiting(ctx); !slices.Equal(waiting, []string{crashed}) {
+		t.Errorf("worker_queue = %v, want %v", waiting, []string{crashed})
+	}
+	if leased, _ := queues.Leased(ctx); !slices.Equal(leased, []string{running}) {
+		t.Errorf("worker_leases = %v, want %v", leased, []string{running})
+	}
+	event, err := eventStore.Get(ctx, crashed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := len(event.Status); n != 1 || event.Status[0].Status != "worker_queue" ||
+		event.Status[0].Message != "Worker lease expired, event re-enqueued" {
+		t.Errorf("status = %+v, want the lease expiry recorded", event.Status)
+	}
+
+	// A reaped event can be claimed again.
+	if id, err := queues.Claim(ctx, time.Hour); err != nil || id != crashed {
+		t.Errorf("Claim = %q, %v; want %q", id, err, crashed)
+	}
+}
+
+func TestReapExpiredLeasesReapsEveryBatch(t *testing.T) {
+	ctx := context.Background()
+	queues := queue.NewMemoryQueues()
+	eventStore := memstore.NewEventStore()
+	n := 2*reapBatchSize + 1
+	claimEvents(t, queues, eventStore, n, -2*time.Second)
+
+	reapExpiredLeases(ctx, queues, eventStore)
+
+	waiting, _ := queues.Waiting(ctx)
+	leased, _ := queues.Leased(ctx)
+	if len(waiting) != n || len(leased) != 0 {
+		t.Errorf("%d waiting and %d leased, want %d waiting", len(waiting), len(leased), n)
+	}
+}
+
+func TestKeepLeaseAliveExtendsLease(t *testing.T) {
+	ctx := context.Background()
+	queues := queue.NewMemoryQueues()
+	eventStore := memstore.NewEventStore()
+	p := &eventProcessor{queues: queues, eventStore: eventStore}
+	visibility := 1500 * time.Millisecond
+	eventID := claimEvents(t, queues, eventStore, 1, visibility)[0]
+
+	stop := p.keepLeaseAlive(ctx, eventID, visibility)
+	time.Sleep(2 * visibility)
+	reaped, err := queues.ReapExpired(ctx, time.Now(), reapBatchSize)
+	stop()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reaped) != 0 {
+		t.Errorf("reaped %v while the lease was kept alive", reaped)
+	}
+
+	time.Sleep(visibility + time.Second)
+	if reaped, _ := queues.ReapExpired(ctx, time.Now(), reapBatchSize); !slices.Equal(reaped, []string{eventID}) {
+		t.Errorf("reaped %v after the heartbeat stopped, want %v", reaped, []string{eventID})
+	}
+}
+
+func TestProcessCancelsEventOfSkippedOccurrence(t *testing.T) {
+	ctx := context.Background()
+	env := newConcurrencyEnv(t, models.ConcurrencyPolicyAllow)
+	event, err := env.events.Get(ctx, env.second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The occurrence was skipped after the prequeuer created its event.
+	if err := env.processor.scheduleStore.ExcludeRunTime(ctx, event.ScheduleID, event.RunTime); err != nil {
+		t.Fatal(err)
+	}
+
+	env.processor.process(ctx, env.second)
+	env.expectNoCallback()
+	status := env.archivedStatus(env.second)
+	if status.Status != "cancelled" || status.Message != "Event cancelled because its occurrence was skipped" {
+		t.Errorf("status = %+v, want cancelled as skipped", status)
+	}
+
+	// A manual run at the same time is not an occurrence and still runs.
+	body := "second"
+	manual := &models.Event{ScheduleID: event.ScheduleID, RunTime: event.RunTime, Manual: true,
+		Overrides: &models.CallbackOverrides{Body: &body}}
+	if _, err := env.events.Insert(ctx, manual); err != nil {
+		t.Fatal(err)
+	}
+	env.processor.process(ctx, manual.ID)
+	env.expectCallback("second")
+	if status := env.archivedStatus(manual.ID); status.Status != "completed" {
+		t.Errorf("manual event status = %+v, want completed", status)
+	}
+}